@@ -0,0 +1,210 @@
+package banlist
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+the peers a node refuses to talk to, by their address when the node banned them for misbehaving and by IP when the administrator did, each until its ban runs out
+the list is kept in a file next to the chain so bans survive a restart, and so the CLI can change them while the node runs
+the node reads the file again whenever it changed on disk
+*/
+const (
+	fileVersion    = 1
+	DefaultBanTime = 24 * time.Hour
+)
+
+type Ban struct {
+	Addr   string
+	Until  time.Time
+	Reason string
+}
+
+type fileContent struct {
+	Version int
+	Bans    []Ban
+}
+
+type BanList struct {
+	mu      sync.Mutex
+	path    string
+	bans    map[string]Ban
+	modTime time.Time //of the file when we last read or wrote it
+	size    int64
+}
+
+//the file of the ban list of the node with nodeID
+func File(nodeID string) string {
+	return fmt.Sprintf(".tmp/banlist_%s.data", nodeID)
+}
+
+/*
+the IPs of a peer given as IP, HOST or HOST:PORT, the bans of the administrator are by IP
+a host name is looked up, so banning localhost bans every node on this machine
+*/
+func PeerIPs(peer string) ([]string, error) {
+	host := peer
+	if h, _, err := net.SplitHostPort(peer); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	return net.LookupHost(host)
+}
+
+//an empty list saved to path, an empty path keeps it in memory only
+func New(path string) *BanList {
+	return &BanList{path: path, bans: make(map[string]Ban)}
+}
+
+//the list saved in path, a missing file is an empty list
+func Load(path string) (*BanList, error) {
+	bl := New(path)
+	if err := bl.Reload(); err != nil {
+		return nil, err
+	}
+	return bl, nil
+}
+
+//reads the file again when it changed since we last read or wrote it
+func (bl *BanList) Reload() error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	return bl.reload()
+}
+
+func (bl *BanList) reload() error {
+	if bl.path == "" {
+		return nil
+	}
+	info, err := os.Stat(bl.path)
+	if os.IsNotExist(err) {
+		bl.bans = make(map[string]Ban)
+		bl.modTime, bl.size = time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(bl.modTime) && info.Size() == bl.size {
+		return nil
+	}
+	data, err := ioutil.ReadFile(bl.path)
+	if err != nil {
+		return err
+	}
+	var content fileContent
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&content); err != nil {
+		return err
+	}
+	if content.Version != fileVersion {
+		return fmt.Errorf("Ban list has version %d, expected %d", content.Version, fileVersion)
+	}
+	bl.bans = make(map[string]Ban)
+	for _, ban := range content.Bans {
+		bl.bans[ban.Addr] = ban
+	}
+	bl.modTime, bl.size = info.ModTime(), info.Size()
+	return nil
+}
+
+/*
+writes the bans that did not run out yet, the file is replaced atomically like the mempool's
+every change reads the file first, so a node and the CLI changing the list at the same time do not undo each other's bans
+*/
+func (bl *BanList) save() error {
+	if bl.path == "" {
+		return nil
+	}
+	content := fileContent{Version: fileVersion, Bans: bl.list()}
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(content); err != nil {
+		return err
+	}
+	tmp := bl.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buffer.Bytes(), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, bl.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(bl.path); err == nil {
+		bl.modTime, bl.size = info.ModTime(), info.Size()
+	}
+	return nil
+}
+
+//bans addr for duration, a ban that is already there is replaced
+func (bl *BanList) Ban(addr string, duration time.Duration, reason string) error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if err := bl.reload(); err != nil {
+		return err
+	}
+	bl.bans[addr] = Ban{addr, time.Now().Add(duration), reason}
+	return bl.save()
+}
+
+//lifts the ban of addr, false when it was not banned
+func (bl *BanList) Unban(addr string) (bool, error) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if err := bl.reload(); err != nil {
+		return false, err
+	}
+	if _, ok := bl.bans[addr]; !ok {
+		return false, nil
+	}
+	delete(bl.bans, addr)
+	return true, bl.save()
+}
+
+func (bl *BanList) Clear() error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if err := bl.reload(); err != nil {
+		return err
+	}
+	bl.bans = make(map[string]Ban)
+	return bl.save()
+}
+
+func (bl *BanList) IsBanned(addr string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	ban, ok := bl.bans[addr]
+	if ok && time.Now().After(ban.Until) {
+		delete(bl.bans, addr)
+		return false
+	}
+	return ok
+}
+
+//the bans that did not run out yet, the one that runs out first comes first
+func (bl *BanList) List() []Ban {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	return bl.list()
+}
+
+func (bl *BanList) list() []Ban {
+	var bans []Ban
+	now := time.Now()
+	for _, ban := range bl.bans {
+		if now.Before(ban.Until) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
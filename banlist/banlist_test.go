@@ -0,0 +1,49 @@
+package banlist
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//a node and the CLI have the same file open, a change by one must not undo the changes the other made since it last read the file
+func TestChangesKeepBansMadeElsewhere(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banlist.data")
+	node, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Ban("10.0.0.1", time.Hour, "by the cli"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Ban("10.0.0.2", time.Hour, "by the node"); err != nil {
+		t.Fatal(err)
+	}
+	if !node.IsBanned("10.0.0.1") {
+		t.Fatal("the node does not know the ban of the cli")
+	}
+	if removed, err := cli.Unban("10.0.0.2"); err != nil || !removed {
+		t.Fatalf("the cli could not lift the ban of the node: %v %v", removed, err)
+	}
+
+	saved, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.IsBanned("10.0.0.1") || saved.IsBanned("10.0.0.2") {
+		t.Fatalf("saved bans are %v, expected only 10.0.0.1", saved.List())
+	}
+}
+
+func TestPeerIPs(t *testing.T) {
+	for peer, expected := range map[string]string{"10.0.0.1": "10.0.0.1", "10.0.0.1:3000": "10.0.0.1", "[::1]:3000": "::1"} {
+		ips, err := PeerIPs(peer)
+		if err != nil || len(ips) != 1 || ips[0] != expected {
+			t.Errorf("%s has IPs %v (%v), expected %s", peer, ips, err, expected)
+		}
+	}
+}
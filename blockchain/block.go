@@ -4,17 +4,51 @@ import (
 	"bytes"
 	"encoding/gob"
 	"log"
+	"time"
 )
 
 type Block struct {
+	Timestamp    int64 //unix time the block was mined at, used for median time past and time based locks
 	Hash         []byte
 	Transactions []*Transaction
 	PrevHash     []byte
 	Nonce        int
+	Height       int //number of blocks before this one, genesis is at height 0
 }
 
-func CreateBlock(tx []*Transaction, prevHash []byte) *Block {
-	block := &Block{[]byte{}, tx, prevHash, 0}
+/*
+gob numbers the types a process encodes in the order it first uses them and those numbers are part of the encoding
+blocks and transactions are hashed from their gob encoding, so their types are used here before anything else
+that way every process encodes them to the same bytes, no matter if it read a wallet file or a message from a peer first
+*/
+func init() {
+	var buff bytes.Buffer
+	Handle(gob.NewEncoder(&buff).Encode(Block{}))
+}
+
+/*
+a header is a block without its transactions, only the merkle root of them
+it is enough to check the proof of work and how blocks link up, so a node can learn a chain from its headers before downloading any block
+*/
+type BlockHeader struct {
+	Timestamp  int64
+	Hash       []byte
+	PrevHash   []byte
+	MerkleRoot []byte
+	Nonce      int
+	Height     int
+}
+
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{b.Timestamp, b.Hash, b.PrevHash, b.HashTransactions(), b.Nonce, b.Height}
+}
+
+func CreateBlock(tx []*Transaction, prevHash []byte, height int) *Block {
+	return createBlockAt(tx, prevHash, height, time.Now().Unix())
+}
+
+func createBlockAt(tx []*Transaction, prevHash []byte, height int, timestamp int64) *Block {
+	block := &Block{timestamp, []byte{}, tx, prevHash, 0, height}
 	pow := NewProof(block)
 	nonce, hash := pow.Run()
 	block.Nonce = nonce
@@ -23,7 +57,7 @@ func CreateBlock(tx []*Transaction, prevHash []byte) *Block {
 }
 
 func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{})
+	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0)
 }
 
 //pow algo must consider the algo that are stored in a block, so we create this function that allows to use a hashing mechanism to provide a unique representation to all our transactions combined
@@ -52,11 +86,19 @@ func (b *Block) Serialize() []byte {
 }
 
 func Deserialize(data []byte) *Block {
+	block, err := DecodeBlock(data)
+	Handle(err)
+	return block
+}
+
+//like Deserialize but for data from a peer, which may not be a block at all
+func DecodeBlock(data []byte) (*Block, error) {
 	var block Block
 	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&block)
-	Handle(err)
-	return &block
+	if err := decoder.Decode(&block); err != nil {
+		return nil, err
+	}
+	return &block, nil
 }
 
 func Handle(err error) {
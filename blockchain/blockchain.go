@@ -2,14 +2,15 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
+	"time"
 
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
 	"github.com/dgraph-io/badger"
 )
 
@@ -104,14 +105,14 @@ func ContinueBlockChain(address string) *Blockchain {
 	return &chain
 } //now we can easily create the functionality that we need for our command line to be able to check the amt of tokens that are assigned to an account as well as be able to send tokens from one account to the next
 
-func (chain *Blockchain) AddBlock(transactions []*Transaction) *Block {
+/*
+verifies the transactions, mines a new block with them on top of our last block and makes it the new last block
+a block added while we mined would be overwritten as the last block, so that panics instead, the caller has to keep other writes out meanwhile
+*/
+func (chain *Blockchain) MineBlock(transactions []*Transaction) *Block {
 	var lastHash []byte
+	var lastHeight int
 
-	for _, tx := range transactions {
-		if chain.VerifyTransaction(tx) != true {
-			log.Panic("Invalid Transaction")
-		}
-	}
 	err := chain.Database.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("lh"))
 		Handle(err)
@@ -120,13 +121,36 @@ func (chain *Blockchain) AddBlock(transactions []*Transaction) *Block {
 			return nil
 		})
 		Handle(err1)
+		item, err = txn.Get(lastHash)
+		Handle(err)
+		err1 = item.Value(func(val []byte) error {
+			lastHeight = Deserialize(val).Height
+			return nil
+		})
+		Handle(err1)
 		return err
 	})
 	Handle(err)
 
-	newBlock := CreateBlock(transactions, lastHash)
+	if err := chain.verifyBlockTransactions(transactions, lastHeight+1, lastHash); err != nil {
+		log.Panic(err)
+	}
+
+	//peers only take a block whose timestamp is after the median time past, blocks mined within the same second would not be
+	timestamp := time.Now().Unix()
+	if medianTime := chain.MedianTimePast(lastHash); timestamp <= medianTime {
+		timestamp = medianTime + 1
+	}
+	newBlock := createBlockAt(transactions, lastHash, lastHeight+1, timestamp)
 	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
+		item, err := txn.Get([]byte("lh"))
+		Handle(err)
+		current, err := item.ValueCopy(nil)
+		Handle(err)
+		if !bytes.Equal(current, lastHash) {
+			return fmt.Errorf("Last block changed to %x while block %x was mined", current, newBlock.Hash)
+		}
+		err = txn.Set(newBlock.Hash, newBlock.Serialize())
 		Handle(err)
 		err = txn.Set([]byte("lh"), newBlock.Hash)
 		chain.LastHash = newBlock.Hash
@@ -136,6 +160,226 @@ func (chain *Blockchain) AddBlock(transactions []*Transaction) *Block {
 	return newBlock
 }
 
+/*
+stores a block we received from a peer, its hash has to be the one its proof of work was done for and its transactions and locks are checked against its parent
+if it ends up higher than our last block it becomes the new last block
+*/
+func (chain *Blockchain) AddBlock(block *Block) error {
+	if _, err := chain.GetBlock(block.Hash); err == nil {
+		return nil
+	}
+	parent, err := chain.GetBlock(block.PrevHash)
+	if err != nil {
+		return fmt.Errorf("Parent of block %x is unknown", block.Hash)
+	}
+	if block.Height != parent.Height+1 {
+		return fmt.Errorf("Block %x has height %d on top of height %d", block.Hash, block.Height, parent.Height)
+	}
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("Block %x has no transactions", block.Hash)
+	}
+	if header := block.Header(); !header.Validate() {
+		return fmt.Errorf("Block %x has an invalid proof of work", block.Hash)
+	}
+	if block.Timestamp <= chain.MedianTimePast(parent.Hash) {
+		return fmt.Errorf("Block %x has a timestamp before the median time past", block.Hash)
+	}
+	if err := chain.verifyBlockTransactions(block.Transactions, block.Height, parent.Hash); err != nil {
+		return fmt.Errorf("Block %x: %s", block.Hash, err)
+	}
+
+	bestHeight := chain.GetBestHeight()
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		err := txn.Set(block.Hash, block.Serialize())
+		Handle(err)
+		if block.Height > bestHeight {
+			err = txn.Set([]byte("lh"), block.Hash)
+			chain.LastHash = block.Hash
+		}
+		return err
+	})
+	Handle(err)
+	return nil
+}
+
+/*
+checks the transactions of a block at height on top of prevHash against the unspent outputs right after prevHash
+the first transaction and no other is the coinbase, it can pay out Subsidy and the fees of the others
+every other transaction spends outputs that exist and are unspent and pays out no more than they are worth
+a transaction can spend the outputs of one before it in the same block, that is how a chain of unconfirmed transactions gets mined at once, but no output can be spent twice
+*/
+func (chain *Blockchain) verifyBlockTransactions(transactions []*Transaction, height int, prevHash []byte) error {
+	if len(transactions) == 0 || !transactions[0].IsCoinbase() {
+		return errors.New("First transaction is not a coinbase")
+	}
+	view, err := chain.viewAt(prevHash)
+	if err != nil {
+		return err
+	}
+	unconfirmed := make(map[string]bool)
+	fees := 0
+	for i, tx := range transactions {
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return fmt.Errorf("Transaction %x does not match its ID", tx.ID)
+		}
+		if len(tx.Outputs) == 0 {
+			return fmt.Errorf("Transaction %x has no outputs", tx.ID)
+		}
+		outputs := 0
+		for _, out := range tx.Outputs {
+			if out.Value <= 0 || outputs+out.Value < outputs {
+				return fmt.Errorf("Transaction %x has an output with an invalid value", tx.ID)
+			}
+			outputs += out.Value
+		}
+		//a transaction with the ID of one whose outputs are still unspent would overwrite them in the UTXO set
+		for outIdx := range tx.Outputs {
+			if _, ok := view.FindOutput(tx.ID, outIdx); ok {
+				return fmt.Errorf("Transaction %x is already on the chain", tx.ID)
+			}
+		}
+		if tx.IsCoinbase() {
+			if i != 0 {
+				return fmt.Errorf("Transaction %x is a second coinbase", tx.ID)
+			}
+		} else {
+			if len(tx.Inputs) == 0 {
+				return fmt.Errorf("Transaction %x has no inputs", tx.ID)
+			}
+			var prevOuts []TxOutput
+			inputs := 0
+			for _, in := range tx.Inputs {
+				prevOut, ok := view.FindOutput(in.ID, in.Out)
+				if !ok {
+					return fmt.Errorf("Transaction %x spends %s:%d that does not exist or is already spent", tx.ID, hex.EncodeToString(in.ID), in.Out)
+				}
+				//spent right away, so a second input or a later transaction spending it again fails above
+				view.spend(in.ID, in.Out)
+				prevOuts = append(prevOuts, prevOut)
+				inputs += prevOut.Value
+			}
+			if inputs < outputs {
+				return fmt.Errorf("Transaction %x spends %d but its inputs are only worth %d", tx.ID, outputs, inputs)
+			}
+			if !tx.VerifyOutputs(prevOuts) {
+				return fmt.Errorf("Invalid transaction %x", tx.ID)
+			}
+			fees += inputs - outputs
+		}
+		if err := chain.checkLocks(tx, height, prevHash, unconfirmed, view.minedIn); err != nil {
+			return err
+		}
+		view.addOutputs(tx)
+		unconfirmed[hex.EncodeToString(tx.ID)] = true
+	}
+	coinbase := 0
+	for _, out := range transactions[0].Outputs {
+		coinbase += out.Value
+	}
+	if coinbase > Subsidy+fees {
+		return fmt.Errorf("Coinbase pays %d, more than the subsidy of %d and the fees of %d", coinbase, Subsidy, fees)
+	}
+	return nil
+}
+
+func (chain *Blockchain) GetBestHeight() int {
+	lastBlock, err := chain.GetBlock(chain.LastHash)
+	Handle(err)
+	return lastBlock.Height
+}
+
+func (chain *Blockchain) GetBlock(blockHash []byte) (Block, error) {
+	var block Block
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(blockHash)
+		if err != nil {
+			return errors.New("Block is not found")
+		}
+		return item.Value(func(val []byte) error {
+			block = *Deserialize(val)
+			return nil
+		})
+	})
+	return block, err
+}
+
+/*
+hashes of our chain from the tip down to genesis, the first ten one after another and then with the step doubling every time
+a peer finds the last block we have in common with it from the first hash it knows, however far back our chains split
+*/
+func (chain *Blockchain) Locator() [][]byte {
+	var locator [][]byte
+	step := 1
+	next := chain.GetBestHeight()
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		if block.Height == next || len(block.PrevHash) == 0 {
+			locator = append(locator, block.Hash)
+			if len(locator) >= 10 {
+				step *= 2
+			}
+			next = block.Height - step
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	return locator
+}
+
+/*
+the blocks of our chain after the fork point with locator, oldest first, at most max of them and ending early at the block with hash stop
+the fork point is the first block of locator that is on our chain, we walk down from our tip until we reach it
+so the work and the reply grow with the number of blocks the peer is missing and not with the length of the chain
+with no block in common they start right after genesis
+*/
+func (chain *Blockchain) blocksAfter(locator [][]byte, stop []byte, max int) []*Block {
+	known := make(map[string]bool)
+	for _, hash := range locator {
+		known[string(hash)] = true
+	}
+	//only the hashes are kept on the way down, a peer missing most of the chain must not make us hold all of it
+	var missing [][]byte
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		if known[string(block.Hash)] || len(block.PrevHash) == 0 {
+			break
+		}
+		missing = append(missing, block.Hash)
+	}
+	var blocks []*Block
+	for i := len(missing) - 1; i >= 0 && len(blocks) < max; i-- {
+		block, err := chain.GetBlock(missing[i])
+		Handle(err)
+		blocks = append(blocks, &block)
+		if bytes.Equal(block.Hash, stop) {
+			break
+		}
+	}
+	return blocks
+}
+
+//headers of the blocks after the fork point with locator, see blocksAfter
+func (chain *Blockchain) HeadersAfter(locator [][]byte, stop []byte, max int) []BlockHeader {
+	var headers []BlockHeader
+	for _, block := range chain.blocksAfter(locator, stop, max) {
+		headers = append(headers, block.Header())
+	}
+	return headers
+}
+
+//hashes of the blocks after the fork point with locator, see blocksAfter
+func (chain *Blockchain) BlockHashesAfter(locator [][]byte, stop []byte, max int) [][]byte {
+	var hashes [][]byte
+	for _, block := range chain.blocksAfter(locator, stop, max) {
+		hashes = append(hashes, block.Hash)
+	}
+	return hashes
+}
+
 //converting the Blockchain struct into the BlockchainIterator struct
 func (chain *Blockchain) Iterator() *BlockchainIterator {
 	iter := &BlockchainIterator{chain.LastHash, chain.Database}
@@ -173,7 +417,9 @@ func (chain *Blockchain) FindUTXO() map[string]TxOutputs {
 
 	for {
 		block := iter.Next()
-		for _, tx := range block.Transactions {
+		//backwards, so outputs spent by a later transaction of the same block are known to be spent
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			tx := block.Transactions[i]
 			txID := hex.EncodeToString(tx.ID)
 
 		Outputs:
@@ -187,6 +433,7 @@ func (chain *Blockchain) FindUTXO() map[string]TxOutputs {
 				}
 				outs := UTXO[txID]
 				outs.Outputs = append(outs.Outputs, out)
+				outs.Indexes = append(outs.Indexes, outIdx)
 				UTXO[txID] = outs
 			}
 			if tx.IsCoinbase() == false {
@@ -203,30 +450,78 @@ func (chain *Blockchain) FindUTXO() map[string]TxOutputs {
 	return UTXO
 }
 
-func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+//every public key hash that was ever paid to or spent from, used to find the keys of a restored wallet
+func (bc *Blockchain) UsedPubKeyHashes() map[string]bool {
+	used := make(map[string]bool)
 	iter := bc.Iterator()
 	for {
 		block := iter.Next()
 		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
+			for _, out := range tx.Outputs {
+				if out.HTLC != nil {
+					used[hex.EncodeToString(out.HTLC.RecipientHash)] = true
+					used[hex.EncodeToString(out.HTLC.RefundHash)] = true
+				} else {
+					used[hex.EncodeToString(out.PubkeyHash)] = true
+				}
+			}
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, in := range tx.Inputs {
+				used[hex.EncodeToString(wallet.PublicKeyHash(in.Pubkey))] = true
 			}
 		}
 		if len(block.PrevHash) == 0 {
 			break
 		}
 	}
+	return used
+}
+
+//a transaction of our chain, found through the transaction index
+func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+	block, err := bc.FindTransactionBlock(ID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	for _, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, ID) {
+			return *tx, nil
+		}
+	}
 	return Transaction{}, errors.New("Transaction does not exist")
 }
 
-func (bc *Blockchain) SignTransaction(tx *Transaction, prevKey ecdsa.PrivateKey) {
+//the block of our chain containing the transaction, needed for relative locks which count from the height the spent output was mined at
+func (bc *Blockchain) FindTransactionBlock(ID []byte) (*Block, error) {
+	var hash []byte
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append(append([]byte{}, txIndexPrefix...), ID...))
+		if err != nil {
+			return err
+		}
+		hash, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("Transaction does not exist")
+	}
+	block, err := bc.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (bc *Blockchain) SignTransaction(tx *Transaction, signer wallet.Signer) {
 	prevTXs := make(map[string]Transaction)
 	for _, in := range tx.Inputs {
 		prevTX, err := bc.FindTransaction(in.ID)
 		Handle(err)
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
-	tx.Sign(prevKey, prevTXs)
+	tx.Sign(signer, prevTXs)
 }
 
 func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
@@ -0,0 +1,367 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+	"github.com/dgraph-io/badger"
+)
+
+//a new chain in a temporary directory whose genesis pays owner, the working directory is restored when the test ends
+func newTestChain(t *testing.T, owner *wallet.Wallet) (*Blockchain, UTXOSet) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	chain := InitBlockchain(string(owner.Address()))
+	t.Cleanup(func() {
+		chain.Database.Close()
+		os.Chdir(cwd)
+	})
+	UTXOSet := UTXOSet{chain}
+	UTXOSet.Reindex()
+	return chain, UTXOSet
+}
+
+//a block with transactions on top of parent, it is not stored
+func blockOn(chain *Blockchain, parent []byte, transactions []*Transaction) *Block {
+	block, err := chain.GetBlock(parent)
+	Handle(err)
+	timestamp := time.Now().Unix()
+	if medianTime := chain.MedianTimePast(parent); timestamp <= medianTime {
+		timestamp = medianTime + 1
+	}
+	return createBlockAt(transactions, parent, block.Height+1, timestamp)
+}
+
+//a transaction of w spending output out of prev and paying value to to
+func spendTx(w *wallet.Wallet, prev *Transaction, out, value int, to string) *Transaction {
+	in := TxInput{prev.ID, out, nil, w.PublicKey, SequenceFinal, nil}
+	tx := Transaction{nil, []TxInput{in}, []TxOutput{*NewTXOutput(value, to)}, 0}
+	Handle(tx.SignInput(0, w, prev.Outputs[out]))
+	tx.ID = tx.Hash()
+	return &tx
+}
+
+func genesisCoinbase(t *testing.T, chain *Blockchain) *Transaction {
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return genesis.Transactions[0]
+}
+
+func expectRejected(t *testing.T, chain *Blockchain, block *Block, reason string) {
+	t.Helper()
+	err := chain.AddBlock(block)
+	if err == nil {
+		t.Fatalf("block was accepted, expected it to fail with %q", reason)
+	}
+	if !strings.Contains(err.Error(), reason) {
+		t.Fatalf("block failed with %q, expected %q", err, reason)
+	}
+}
+
+func TestAddBlockAcceptsValidBlock(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	chain, UTXOSet := newTestChain(t, owner)
+	coinbase := genesisCoinbase(t, chain)
+	tx := spendTx(owner, coinbase, 0, Subsidy-5, string(other.Address()))
+	//the coinbase can take the fee of 5
+	reward := CoinbaseTx(string(owner.Address()), "")
+	reward.Outputs[0].Value = Subsidy + 5
+	reward.ID = reward.Hash()
+
+	block := blockOn(chain, chain.LastHash, []*Transaction{reward, tx})
+	if err := chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet.Update(block)
+	if _, ok := UTXOSet.FindOutput(tx.ID, 0); !ok {
+		t.Fatal("output of the mined transaction is not in the UTXO set")
+	}
+}
+
+func TestAddBlockRejectsSecondCoinbase(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain, _ := newTestChain(t, owner)
+	extra := CoinbaseTx(string(owner.Address()), "")
+	extra.Outputs[0].Value = 1000000
+	extra.ID = extra.Hash()
+	block := blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), extra})
+	expectRejected(t, chain, block, "second coinbase")
+}
+
+func TestAddBlockRejectsCoinbaseOverpaying(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain, _ := newTestChain(t, owner)
+	reward := CoinbaseTx(string(owner.Address()), "")
+	reward.Outputs[0].Value = Subsidy + 1
+	reward.ID = reward.Hash()
+	expectRejected(t, chain, blockOn(chain, chain.LastHash, []*Transaction{reward}), "more than the subsidy")
+}
+
+func TestAddBlockRejectsMissingCoinbase(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain, _ := newTestChain(t, owner)
+	tx := spendTx(owner, genesisCoinbase(t, chain), 0, Subsidy, string(owner.Address()))
+	block := blockOn(chain, chain.LastHash, []*Transaction{tx, CoinbaseTx(string(owner.Address()), "")})
+	expectRejected(t, chain, block, "not a coinbase")
+}
+
+func TestAddBlockRejectsDoubleSpendInBlock(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	chain, _ := newTestChain(t, owner)
+	coinbase := genesisCoinbase(t, chain)
+	first := spendTx(owner, coinbase, 0, Subsidy, string(other.Address()))
+	second := spendTx(owner, coinbase, 0, Subsidy-1, string(owner.Address()))
+	block := blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), first, second})
+	expectRejected(t, chain, block, "already spent")
+}
+
+func TestAddBlockRejectsSpentOutput(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	chain, UTXOSet := newTestChain(t, owner)
+	coinbase := genesisCoinbase(t, chain)
+	first := spendTx(owner, coinbase, 0, Subsidy, string(other.Address()))
+	block := blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), first})
+	if err := chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet.Update(block)
+
+	again := spendTx(owner, coinbase, 0, Subsidy-1, string(owner.Address()))
+	expectRejected(t, chain, blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), again}), "already spent")
+}
+
+func TestAddBlockRejectsOverspending(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain, _ := newTestChain(t, owner)
+	tx := spendTx(owner, genesisCoinbase(t, chain), 0, Subsidy+1, string(owner.Address()))
+	block := blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), tx})
+	expectRejected(t, chain, block, "only worth")
+}
+
+func TestAddBlockRejectsWrongID(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	chain, _ := newTestChain(t, owner)
+	tx := spendTx(owner, genesisCoinbase(t, chain), 0, Subsidy, string(other.Address()))
+	tx.ID = CoinbaseTx(string(owner.Address()), "").ID
+	block := blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), tx})
+	expectRejected(t, chain, block, "does not match its ID")
+}
+
+func TestAddBlockRejectsHashWithoutWork(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain, _ := newTestChain(t, owner)
+	block := blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(owner.Address()), "")})
+	block.Hash = append([]byte{}, chain.LastHash...)
+	block.Hash[len(block.Hash)-1]++
+	expectRejected(t, chain, block, "invalid proof of work")
+}
+
+//a block on a side branch is checked against the outputs of its own branch, not those of our last block
+func TestAddBlockChecksSideBranchAgainstItsParent(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	chain, UTXOSet := newTestChain(t, owner)
+	genesis := chain.LastHash
+	coinbase := genesisCoinbase(t, chain)
+
+	//our chain spends the genesis output
+	spent := spendTx(owner, coinbase, 0, Subsidy, string(other.Address()))
+	tip := blockOn(chain, genesis, []*Transaction{CoinbaseTx(string(owner.Address()), ""), spent})
+	if err := chain.AddBlock(tip); err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet.Update(tip)
+
+	//on a branch from genesis that output is still unspent
+	side := blockOn(chain, genesis, []*Transaction{CoinbaseTx(string(owner.Address()), ""), spendTx(owner, coinbase, 0, Subsidy-1, string(owner.Address()))})
+	if err := chain.AddBlock(side); err != nil {
+		t.Fatal(err)
+	}
+	//but an output that only exists on our chain can not be spent there
+	child := blockOn(chain, side.Hash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), spendTx(other, spent, 0, Subsidy, string(owner.Address()))})
+	expectRejected(t, chain, child, "does not exist or is already spent")
+}
+
+//adds n blocks on top of parent and returns their hashes, lowest first
+func extendChain(t *testing.T, chain *Blockchain, owner *wallet.Wallet, parent []byte, n int) [][]byte {
+	var hashes [][]byte
+	for i := 0; i < n; i++ {
+		block := blockOn(chain, parent, []*Transaction{CoinbaseTx(string(owner.Address()), "")})
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		parent = block.Hash
+		hashes = append(hashes, block.Hash)
+	}
+	return hashes
+}
+
+//fails the test unless got holds the hashes of expected in the same order
+func sameHashes(t *testing.T, what string, got, expected [][]byte) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("%s: got %d hashes, expected %d", what, len(got), len(expected))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], expected[i]) {
+			t.Fatalf("%s: hash %d is %x, expected %x", what, i, got[i], expected[i])
+		}
+	}
+}
+
+func TestLocatorResumesAtTheForkPoint(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain, _ := newTestChain(t, owner)
+	//main[h] is the block at height h of our chain, a shorter branch leaves it after height 8
+	genesis := chain.LastHash
+	main := append([][]byte{genesis}, extendChain(t, chain, owner, genesis, 20)...)
+	side := extendChain(t, chain, owner, main[8], 3)
+	if !bytes.Equal(chain.LastHash, main[20]) {
+		t.Fatal("the shorter branch became our chain")
+	}
+
+	//ten hashes one after another, then the step doubles until genesis
+	expected := [][]byte{}
+	for height := 20; height > 10; height-- {
+		expected = append(expected, main[height])
+	}
+	sameHashes(t, "locator", chain.Locator(), append(expected, main[9], main[5], main[0]))
+
+	//a peer on the side branch asks with its own locator
+	peer := &Blockchain{side[2], chain.Database}
+	locator := peer.Locator()
+	sameHashes(t, "side locator", locator[:4], [][]byte{side[2], side[1], side[0], main[8]})
+
+	sameHashes(t, "blocks after the fork", chain.BlockHashesAfter(locator, nil, 500), main[9:])
+	headers := chain.HeadersAfter(locator, nil, 500)
+	if len(headers) != 12 || !bytes.Equal(headers[0].PrevHash, main[8]) || !bytes.Equal(headers[11].Hash, main[20]) {
+		t.Fatalf("%d headers that do not start at the fork point", len(headers))
+	}
+
+	sameHashes(t, "up to the stop hash", chain.BlockHashesAfter(locator, main[12], 500), main[9:13])
+	sameHashes(t, "up to the limit", chain.BlockHashesAfter(locator, nil, 5), main[9:14])
+	if headers := chain.HeadersAfter(locator, main[12], 2); len(headers) != 2 || !bytes.Equal(headers[1].Hash, main[10]) {
+		t.Fatal("the limit did not cut the headers off before the stop hash")
+	}
+
+	//with no block of our chain in it everything after genesis, and nothing for a peer that is up to date
+	sameHashes(t, "side branch only", chain.BlockHashesAfter([][]byte{side[2]}, nil, 500), main[1:])
+	sameHashes(t, "our own locator", chain.BlockHashesAfter(chain.Locator(), nil, 500), nil)
+}
+
+//the UTXO set and the transaction index as they are in the database, outpoints to values and transaction IDs to block hashes
+func utxoSnapshot(t *testing.T, chain *Blockchain) map[string]string {
+	snapshot := make(map[string]string)
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			val, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			outs := DeserializeOutputs(val)
+			for i, out := range outs.Outputs {
+				snapshot[viewOutpoint(it.Item().Key()[prefixLength:], outs.Index(i))] = fmt.Sprintf("%d %x", out.Value, out.PubkeyHash)
+			}
+		}
+		for it.Seek(txIndexPrefix); it.ValidForPrefix(txIndexPrefix); it.Next() {
+			val, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			snapshot[fmt.Sprintf("%x", it.Item().Key()[len(txIndexPrefix):])] = fmt.Sprintf("%x", val)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+func TestReorganizeMatchesReindex(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	chain, UTXOSet := newTestChain(t, owner)
+	coinbase := genesisCoinbase(t, chain)
+
+	//a transaction with two outputs, each branch spends one of them
+	in := TxInput{coinbase.ID, 0, nil, owner.PublicKey, SequenceFinal, nil}
+	split := &Transaction{nil, []TxInput{in}, []TxOutput{*NewTXOutput(Subsidy/2, string(owner.Address())), *NewTXOutput(Subsidy/2, string(owner.Address()))}, 0}
+	Handle(split.SignInput(0, owner, coinbase.Outputs[0]))
+	split.ID = split.Hash()
+	fork := blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), split})
+	if err := chain.AddBlock(fork); err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet.Update(fork)
+	old := blockOn(chain, fork.Hash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), spendTx(owner, split, 0, Subsidy/2, string(other.Address()))})
+	if err := chain.AddBlock(old); err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet.Update(old)
+
+	side := blockOn(chain, fork.Hash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), spendTx(owner, split, 1, Subsidy/2, string(other.Address()))})
+	if err := chain.AddBlock(side); err != nil {
+		t.Fatal(err)
+	}
+	tip := extendChain(t, chain, owner, side.Hash, 1)[0]
+	if !bytes.Equal(chain.LastHash, tip) {
+		t.Fatal("the longer branch did not become our chain")
+	}
+	disconnected, connected, err := chain.Branches(old.Hash, tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UTXOSet.Reorganize(disconnected, connected); err != nil {
+		t.Fatal(err)
+	}
+	reorganized := utxoSnapshot(t, chain)
+	if _, ok := UTXOSet.FindOutput(split.ID, 0); !ok {
+		t.Fatal("the output the old branch spent did not come back")
+	}
+	if _, ok := UTXOSet.FindOutput(split.ID, 1); ok {
+		t.Fatal("the output the new branch spends is still unspent")
+	}
+
+	UTXOSet.Reindex()
+	reindexed := utxoSnapshot(t, chain)
+	if len(reorganized) != len(reindexed) {
+		t.Fatalf("the reorganized set has %d entries, a reindex gives %d", len(reorganized), len(reindexed))
+	}
+	for key, value := range reindexed {
+		if reorganized[key] != value {
+			t.Fatalf("%s is %q after the reorganization, %q after a reindex", key, reorganized[key], value)
+		}
+	}
+}
+
+func TestReorganizeWritesNothingOnError(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain, UTXOSet := newTestChain(t, owner)
+	before := utxoSnapshot(t, chain)
+	//spends an output that does not exist, after the first block was applied
+	good := blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(owner.Address()), "")})
+	bad := &Block{Hash: []byte("bad"), Transactions: []*Transaction{{[]byte("tx"), []TxInput{{[]byte("missing"), 0, nil, nil, SequenceFinal, nil}}, nil, 0}}}
+	if err := UTXOSet.Reorganize(nil, []*Block{good, bad}); err == nil {
+		t.Fatal("a branch spending an output that does not exist was applied")
+	}
+	after := utxoSnapshot(t, chain)
+	if len(after) != len(before) {
+		t.Fatalf("%d entries before and %d after a reorganization that failed", len(before), len(after))
+	}
+}
@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+var ErrInsufficientFunds = errors.New("Error: not enough funds")
+
+//an unspent output together with where it is, so it can be turned into an input
+type SpendableOutput struct {
+	TxID   []byte
+	Out    int
+	Output TxOutput
+}
+
+/*
+picks which of the candidates to spend so their value covers target
+feePerInput is what adding an input costs, every selector works with the value of an output minus that so it never picks outputs that cost more than they are worth
+*/
+type CoinSelector interface {
+	Select(candidates []SpendableOutput, target, feePerInput int) ([]SpendableOutput, error)
+}
+
+//the selectors that can be picked by name, "keyorder" is what FindSpendableOutputs has always done
+var CoinSelectors = map[string]CoinSelector{
+	"keyorder": KeyOrder{},
+	"largest":  LargestFirst{},
+	"smallest": SmallestFirst{},
+	"bnb":      BranchAndBound{MaxTries: 100000},
+	"random":   RandomImprove{},
+}
+
+func GetCoinSelector(name string) (CoinSelector, error) {
+	selector, ok := CoinSelectors[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown coin selection strategy %s", name)
+	}
+	return selector, nil
+}
+
+func effectiveValue(candidate SpendableOutput, feePerInput int) int {
+	return candidate.Output.Value - feePerInput
+}
+
+//takes candidates in the given order until target is covered
+func accumulate(candidates []SpendableOutput, target, feePerInput int) ([]SpendableOutput, error) {
+	var selected []SpendableOutput
+	total := 0
+	for _, candidate := range candidates {
+		if total >= target {
+			break
+		}
+		if effectiveValue(candidate, feePerInput) <= 0 {
+			continue
+		}
+		selected = append(selected, candidate)
+		total += effectiveValue(candidate, feePerInput)
+	}
+	if total < target {
+		return nil, ErrInsufficientFunds
+	}
+	return selected, nil
+}
+
+//takes outputs in the order they are stored in the database
+type KeyOrder struct{}
+
+func (KeyOrder) Select(candidates []SpendableOutput, target, feePerInput int) ([]SpendableOutput, error) {
+	return accumulate(candidates, target, feePerInput)
+}
+
+//uses as few inputs as possible, consolidates nothing and keeps the small outputs around
+type LargestFirst struct{}
+
+func (LargestFirst) Select(candidates []SpendableOutput, target, feePerInput int) ([]SpendableOutput, error) {
+	sorted := append([]SpendableOutput{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Output.Value > sorted[j].Output.Value })
+	return accumulate(sorted, target, feePerInput)
+}
+
+//spends dust first, which consolidates the wallet at the cost of bigger transactions
+type SmallestFirst struct{}
+
+func (SmallestFirst) Select(candidates []SpendableOutput, target, feePerInput int) ([]SpendableOutput, error) {
+	sorted := append([]SpendableOutput{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Output.Value < sorted[j].Output.Value })
+	return accumulate(sorted, target, feePerInput)
+}
+
+/*
+searches for a set of inputs that adds up to target without any change, anything up to CostOfChange above target is accepted because creating a change output would cost that much anyway
+the search is depth first over the candidates sorted from large to small and gives up after MaxTries steps, when no match is found it falls back to LargestFirst
+*/
+type BranchAndBound struct {
+	CostOfChange int
+	MaxTries     int
+}
+
+func (b BranchAndBound) Select(candidates []SpendableOutput, target, feePerInput int) ([]SpendableOutput, error) {
+	var sorted []SpendableOutput
+	available := 0
+	for _, candidate := range candidates {
+		if effectiveValue(candidate, feePerInput) > 0 {
+			sorted = append(sorted, candidate)
+			available += effectiveValue(candidate, feePerInput)
+		}
+	}
+	if available < target {
+		return nil, ErrInsufficientFunds
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Output.Value > sorted[j].Output.Value })
+
+	tries := 0
+	var included []bool
+	var search func(index, total, remaining int) bool
+	search = func(index, total, remaining int) bool {
+		tries++
+		if total > target+b.CostOfChange || total+remaining < target || tries > b.MaxTries {
+			return false
+		}
+		if total >= target {
+			return true
+		}
+		if index == len(sorted) {
+			return false
+		}
+		value := effectiveValue(sorted[index], feePerInput)
+		included = append(included, true)
+		if search(index+1, total+value, remaining-value) {
+			return true
+		}
+		included[len(included)-1] = false
+		if search(index+1, total, remaining-value) {
+			return true
+		}
+		included = included[:len(included)-1]
+		return false
+	}
+
+	if !search(0, 0, available) {
+		return LargestFirst{}.Select(candidates, target, feePerInput)
+	}
+	var selected []SpendableOutput
+	for i, in := range included {
+		if in {
+			selected = append(selected, sorted[i])
+		}
+	}
+	return selected, nil
+}
+
+/*
+picks random outputs until target is covered and then keeps adding random outputs while that brings the total closer to twice the target, without going over three times the target
+the change this leaves behind is about the size of the payment, which keeps the wallet's outputs from fragmenting into dust over time
+*/
+type RandomImprove struct{}
+
+func (RandomImprove) Select(candidates []SpendableOutput, target, feePerInput int) ([]SpendableOutput, error) {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	shuffled := append([]SpendableOutput{}, candidates...)
+	random.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	var selected []SpendableOutput
+	total := 0
+	next := 0
+	for ; next < len(shuffled) && total < target; next++ {
+		if value := effectiveValue(shuffled[next], feePerInput); value > 0 {
+			selected = append(selected, shuffled[next])
+			total += value
+		}
+	}
+	if total < target {
+		return nil, ErrInsufficientFunds
+	}
+
+	ideal := 2 * target
+	for _, candidate := range shuffled[next:] {
+		value := effectiveValue(candidate, feePerInput)
+		if value <= 0 || total+value > 3*target {
+			continue
+		}
+		if abs(ideal-(total+value)) < abs(ideal-total) {
+			selected = append(selected, candidate)
+			total += value
+		}
+	}
+	return selected, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,143 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//unspent outputs kept in memory, an OutputSet without a database
+type testOutputs []SpendableOutput
+
+func (outputs testOutputs) ListSpendableOutputs(pubKeyHash []byte) []SpendableOutput {
+	var spendable []SpendableOutput
+	for _, output := range outputs {
+		if output.Output.IsLockedWithKey(pubKeyHash) {
+			spendable = append(spendable, output)
+		}
+	}
+	return spendable
+}
+
+func (outputs testOutputs) FindOutput(txID []byte, out int) (TxOutput, bool) {
+	for _, output := range outputs {
+		if bytes.Equal(output.TxID, txID) && output.Out == out {
+			return output.Output, true
+		}
+	}
+	return TxOutput{}, false
+}
+
+func testCandidates(owner string, values ...int) testOutputs {
+	var outputs testOutputs
+	for i, value := range values {
+		id := sha256.Sum256([]byte{byte(i)})
+		outputs = append(outputs, SpendableOutput{id[:], 0, *NewTXOutput(value, owner)})
+	}
+	return outputs
+}
+
+func selectedValue(selected []SpendableOutput) int {
+	total := 0
+	for _, output := range selected {
+		total += output.Output.Value
+	}
+	return total
+}
+
+func TestSelectorsInputsAndChange(t *testing.T) {
+	candidates := testCandidates(string(wallet.MakeWallet().Address()), 1, 2, 3, 5, 8, 13, 21, 34, 55)
+	target := 20
+	cases := []struct {
+		name   string
+		inputs int
+		change int
+	}{
+		{"keyorder", 6, 12}, //1+2+3+5+8+13
+		{"largest", 1, 35},  //55
+		{"smallest", 6, 12}, //the same as keyorder for outputs stored from small to large
+		{"bnb", 3, 0},       //13+5+2, no change at all
+	}
+	for _, c := range cases {
+		selector, err := GetCoinSelector(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected, err := selector.Select(candidates, target, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(selected) != c.inputs || selectedValue(selected)-target != c.change {
+			t.Errorf("%s picked %d inputs leaving %d change, expected %d and %d", c.name, len(selected), selectedValue(selected)-target, c.inputs, c.change)
+		}
+	}
+
+	//random ends up between the target and three times of it, with change about the size of the payment
+	selected, err := RandomImprove{}.Select(candidates, target, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := selectedValue(selected); total < target {
+		t.Errorf("random picked %d, less than %d", total, target)
+	}
+	if _, err := (LargestFirst{}).Select(candidates, 1000, 0); err != ErrInsufficientFunds {
+		t.Errorf("selecting more than there is gave %v", err)
+	}
+}
+
+//an output worth no more than the fee for spending it is never picked, and the others count for what is left of them
+func TestSelectorsPayFeePerInput(t *testing.T) {
+	candidates := testCandidates(string(wallet.MakeWallet().Address()), 1, 2, 3, 5, 8, 13, 21, 34, 55)
+	feePerInput := 3
+	target := 20
+	for name, selector := range CoinSelectors {
+		selected, err := selector.Select(candidates, target, feePerInput)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, output := range selected {
+			if output.Output.Value <= feePerInput {
+				t.Errorf("%s picked an output of %d that costs %d to spend", name, output.Output.Value, feePerInput)
+			}
+		}
+		if covered := selectedValue(selected) - len(selected)*feePerInput; covered < target {
+			t.Errorf("%s covers %d after the fees, less than %d", name, covered, target)
+		}
+	}
+	//without the fee the three smallest would have been enough for 5 with smallest first, with it they are worth nothing
+	selected, err := SmallestFirst{}.Select(candidates, 5, feePerInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 2 || selected[0].Output.Value != 5 || selected[1].Output.Value != 8 {
+		t.Errorf("smallest first picked %d inputs starting with %d", len(selected), selected[0].Output.Value)
+	}
+}
+
+func TestPartialTransactionPaysFeeRate(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	candidates := testCandidates(from, 1000, 2000, 3000, 5000)
+	payments := []Payment{{string(other.Address()), 2500}}
+
+	for _, feeRate := range []int{0, 10, 100} {
+		for _, name := range []string{"smallest", "largest"} {
+			selector, _ := GetCoinSelector(name)
+			ptx := partialTx(t, from, "", payments, TxOptions{Selector: selector, FeeRate: feeRate}, candidates)
+			if _, err := ptx.Sign(owner); err != nil {
+				t.Fatal(err)
+			}
+			tx, err := ptx.Finalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := feeFor(len(tx.Serialize()), feeRate)
+			//the estimate rounds up for every input, so it can be a little above the fee of the actual size
+			if fee := ptx.Fee(); fee < expected || fee > expected+len(tx.Inputs)+1 {
+				t.Errorf("%s at a fee rate of %d pays %d for %d bytes, expected %d", name, feeRate, fee, len(tx.Serialize()), expected)
+			}
+		}
+	}
+}
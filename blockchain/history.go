@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"sort"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//what a transaction did to the keys of a wallet
+type WalletTransaction struct {
+	TxID          []byte
+	Height        int
+	Confirmations int
+	Timestamp     int64
+	Coinbase      bool
+
+	Received int //value of the outputs paid to our keys, change included
+	Sent     int //value of our outputs this transaction spent
+	Fee      int //only known for transactions we paid for, coinbases have none
+
+	Addresses      []string //our addresses the transaction paid to or spent from
+	Counterparties []string //the other side, who we paid for outgoing transactions and who paid us for incoming ones
+}
+
+//the net change to our balance, negative for payments we made
+func (wtx WalletTransaction) Amount() int {
+	return wtx.Received - wtx.Sent
+}
+
+/*
+walks the chain from the genesis block and collects every transaction that paid to or spent from one of the keys in owned
+owned holds hex encoded public key hashes, the wallet decides which keys count so watch-only ones can be included
+the result is ordered from oldest to newest
+*/
+func (bc *Blockchain) WalletHistory(owned map[string]bool) []WalletTransaction {
+	var blocks []*Block
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+		blocks = append(blocks, block)
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	best := bc.GetBestHeight()
+
+	var history []WalletTransaction
+	outputs := make(map[string][]TxOutput)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		for _, tx := range block.Transactions {
+			outputs[hex.EncodeToString(tx.ID)] = tx.Outputs
+			wtx := WalletTransaction{
+				TxID:          tx.ID,
+				Height:        block.Height,
+				Confirmations: best - block.Height + 1,
+				Timestamp:     block.Timestamp,
+				Coinbase:      tx.IsCoinbase(),
+			}
+			ours := make(map[string]bool)
+			others := make(map[string]bool)
+
+			spent := 0
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					prevOutputs := outputs[hex.EncodeToString(in.ID)]
+					if in.Out < 0 || in.Out >= len(prevOutputs) {
+						continue
+					}
+					value := prevOutputs[in.Out].Value
+					spent += value
+					pubKeyHash := wallet.PublicKeyHash(in.Pubkey)
+					address := string(wallet.PubKeyHashToAddress(pubKeyHash))
+					if owned[hex.EncodeToString(pubKeyHash)] {
+						wtx.Sent += value
+						ours[address] = true
+					} else {
+						others[address] = true
+					}
+				}
+			}
+
+			paid := 0
+			var payees []string
+			for _, out := range tx.Outputs {
+				paid += out.Value
+				pubKeyHash := out.PubkeyHash
+				if out.HTLC != nil {
+					pubKeyHash = out.HTLC.RecipientHash
+				}
+				address := string(wallet.PubKeyHashToAddress(pubKeyHash))
+				if out.HTLC == nil && owned[hex.EncodeToString(pubKeyHash)] {
+					wtx.Received += out.Value
+					ours[address] = true
+				} else {
+					payees = append(payees, address)
+				}
+			}
+
+			if len(ours) == 0 {
+				continue
+			}
+			if wtx.Sent > 0 {
+				wtx.Fee = spent - paid
+				others = make(map[string]bool)
+				for _, address := range payees {
+					others[address] = true
+				}
+			}
+			for address := range ours {
+				wtx.Addresses = append(wtx.Addresses, address)
+			}
+			for address := range others {
+				wtx.Counterparties = append(wtx.Counterparties, address)
+			}
+			sort.Strings(wtx.Addresses)
+			sort.Strings(wtx.Counterparties)
+			history = append(history, wtx)
+		}
+	}
+	return history
+}
@@ -0,0 +1,104 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//an output of owner's wallet to spend
+type spend struct {
+	owner *wallet.Wallet
+	prev  *Transaction
+	out   int
+}
+
+//a transaction spending every one of spends and paying outputs, each input signed by the owner of what it spends
+func spendAllTx(spends []spend, outputs ...TxOutput) *Transaction {
+	tx := Transaction{nil, nil, outputs, 0}
+	for _, s := range spends {
+		tx.Inputs = append(tx.Inputs, TxInput{s.prev.ID, s.out, nil, s.owner.PublicKey, SequenceFinal, nil})
+	}
+	for i, s := range spends {
+		Handle(tx.SignInput(i, s.owner, s.prev.Outputs[s.out]))
+	}
+	tx.ID = tx.Hash()
+	return &tx
+}
+
+func TestWalletHistoryAcrossASpend(t *testing.T) {
+	owner, change, other, miner := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	chain, UTXOSet := newTestChain(t, owner)
+	coinbase := genesisCoinbase(t, chain)
+	mine := func(tx *Transaction) {
+		block := blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(miner.Address()), ""), tx})
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		UTXOSet.Update(block)
+	}
+
+	//we pay other 5 out of the genesis reward, 14 come back to a change key and 1 is the fee
+	payment := spendAllTx([]spend{{owner, coinbase, 0}}, *NewTXOutput(5, string(other.Address())), *NewTXOutput(14, string(change.Address())))
+	mine(payment)
+	//other pays 3 of it back and keeps 2
+	refund := spendAllTx([]spend{{other, payment, 0}}, *NewTXOutput(3, string(owner.Address())), *NewTXOutput(2, string(other.Address())))
+	mine(refund)
+	//the change and the refund go to one of our keys, again with a fee of 1
+	consolidation := spendAllTx([]spend{{change, payment, 1}, {owner, refund, 0}}, *NewTXOutput(16, string(owner.Address())))
+	mine(consolidation)
+
+	owned := map[string]bool{hex.EncodeToString(owner.PubKeyHash()): true, hex.EncodeToString(change.PubKeyHash()): true}
+	history := chain.WalletHistory(owned)
+	expected := []struct {
+		tx                        *Transaction
+		received, sent, fee       int
+		addresses, counterparties []string
+	}{
+		{coinbase, 20, 0, 0, []string{string(owner.Address())}, nil},
+		{payment, 14, 20, 1, []string{string(owner.Address()), string(change.Address())}, []string{string(other.Address())}},
+		{refund, 3, 0, 0, []string{string(owner.Address())}, []string{string(other.Address())}},
+		{consolidation, 16, 17, 1, []string{string(owner.Address()), string(change.Address())}, nil},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("history has %d transactions, expected %d, the miner's rewards are not ours", len(history), len(expected))
+	}
+	balance := 0
+	for i, e := range expected {
+		wtx := history[i]
+		if string(wtx.TxID) != string(e.tx.ID) || wtx.Height != i || wtx.Confirmations != len(expected)-i {
+			t.Fatalf("transaction %d is %x at height %d with %d confirmations", i, wtx.TxID, wtx.Height, wtx.Confirmations)
+		}
+		if wtx.Received != e.received || wtx.Sent != e.sent || wtx.Fee != e.fee {
+			t.Fatalf("transaction %d received %d, sent %d and paid %d, expected %d, %d and %d", i, wtx.Received, wtx.Sent, wtx.Fee, e.received, e.sent, e.fee)
+		}
+		if !sameAddresses(wtx.Addresses, e.addresses) || !sameAddresses(wtx.Counterparties, e.counterparties) {
+			t.Fatalf("transaction %d touched %v with counterparties %v", i, wtx.Addresses, wtx.Counterparties)
+		}
+		balance += wtx.Amount()
+	}
+	if !history[0].Coinbase || history[1].Amount() != -6 {
+		t.Fatal("the reward is not a coinbase or the payment did not cost what was paid plus the fee")
+	}
+
+	//what the history adds up to is what the keys hold
+	held := 0
+	for _, key := range []*wallet.Wallet{owner, change} {
+		for _, out := range UTXOSet.FindUTXO(key.PubKeyHash()) {
+			held += out.Value
+		}
+	}
+	if balance != 16 || held != balance {
+		t.Fatalf("the history adds up to %d and the keys hold %d, expected 16", balance, held)
+	}
+}
+
+//the history sorts the addresses, expected can be in any order
+func sameAddresses(got, expected []string) bool {
+	expected = append([]string{}, expected...)
+	sort.Strings(expected)
+	return strings.Join(got, ",") == strings.Join(expected, ",")
+}
@@ -0,0 +1,142 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+const SecretLength = 32
+
+/*
+hash time locked contract, the output can be spent in two ways
+redeem: by the recipient's key together with the secret whose sha256 is SecretHash
+refund: by the refund key with a transaction whose LockTime is at least the contract's LockTime, so only once that time has passed
+
+an atomic swap between two chains locks both sides to the same SecretHash
+the initiator knows the secret and redeems on the other chain which reveals it, the participant then uses it to redeem on the first chain
+the initiator's contract has the longer timeout so the participant always has time to redeem after the secret is revealed
+*/
+type HTLC struct {
+	SecretHash    []byte
+	RecipientHash []byte //public key hash of the party that can redeem with the secret
+	RefundHash    []byte //public key hash of the party that can take the coins back after LockTime
+	LockTime      int64  //block height or unix time, same meaning as Transaction.LockTime
+}
+
+//creates a random secret and its hash for a new contract
+func NewSecret() ([]byte, []byte) {
+	secret := make([]byte, SecretLength)
+	_, err := rand.Read(secret)
+	Handle(err)
+	secretHash := sha256.Sum256(secret)
+	return secret, secretHash[:]
+}
+
+func (h *HTLC) Hash() []byte {
+	var encoded bytes.Buffer
+	enc := gob.NewEncoder(&encoded)
+	err := enc.Encode(h)
+	Handle(err)
+	hash := sha256.Sum256(encoded.Bytes())
+	return hash[:]
+}
+
+func (h *HTLC) IsRedeemedBy(secret []byte) bool {
+	secretHash := sha256.Sum256(secret)
+	return len(secret) == SecretLength && bytes.Equal(secretHash[:], h.SecretHash)
+}
+
+//the signature itself is checked by the caller, here we check which path the input takes and that its conditions are met
+func (h *HTLC) CanBeSpentBy(tx *Transaction, in TxInput) bool {
+	if in.UsesKey(h.RecipientHash) && h.IsRedeemedBy(in.Secret) {
+		return true
+	}
+	if !in.UsesKey(h.RefundHash) || tx.LockTime == 0 {
+		return false
+	}
+	//heights and unix times can not be compared, the refund has to use the same kind of lock as the contract
+	if (tx.LockTime < LockTimeThreshold) != (h.LockTime < LockTimeThreshold) {
+		return false
+	}
+	return tx.LockTime >= h.LockTime
+}
+
+func NewHTLCOutput(value int, contract HTLC) *TxOutput {
+	return &TxOutput{value, nil, &contract}
+}
+
+//funds a contract from the outputs of the wallet, ContractOutput tells which output it ended up in
+func NewHTLCTransaction(signer wallet.Signer, change string, contract HTLC, amt int, options TxOptions, UTXO OutputSet) (*Transaction, error) {
+	from, err := wallet.SignerAddress(signer)
+	if err != nil {
+		return nil, err
+	}
+	ptx, err := newPartialTransaction(from, change, []TxOutput{*NewHTLCOutput(amt, contract)}, options, UTXO)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ptx.Sign(signer); err != nil {
+		return nil, err
+	}
+	return ptx.Finalize()
+}
+
+func (tx *Transaction) ContractOutput() int {
+	for i, out := range tx.Outputs {
+		if out.HTLC != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+//spends the contract at txID:out to the address of the signer, with the secret when redeeming or with the contract's lock time when refunding
+func NewHTLCSpend(txID []byte, out int, contractOut TxOutput, signer wallet.Signer, secret []byte) (*Transaction, error) {
+	if contractOut.HTLC == nil {
+		return nil, errors.New("Output is not a contract")
+	}
+	pubKey, err := signer.GetPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	in := TxInput{txID, out, nil, pubKey, SequenceNoReplace, nil}
+	tx := Transaction{nil, []TxInput{in}, []TxOutput{*NewTXOutput(contractOut.Value, string(wallet.PubKeyHashToAddress(wallet.PublicKeyHash(pubKey))))}, 0}
+	if secret != nil {
+		tx.Inputs[0].Secret = secret
+	} else {
+		tx.LockTime = contractOut.HTLC.LockTime
+	}
+	if !contractOut.HTLC.CanBeSpentBy(&tx, tx.Inputs[0]) {
+		return nil, errors.New("Wallet key and secret do not match the contract")
+	}
+	if err := tx.SignInput(0, signer, contractOut); err != nil {
+		return nil, err
+	}
+	tx.ID = tx.Hash()
+	return &tx, nil
+}
+
+//looks for the transaction spending txID:out, if it redeemed the contract the secret is returned as well
+func (bc *Blockchain) FindContractSpend(txID []byte, out int) (*Transaction, []byte, error) {
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			for _, in := range tx.Inputs {
+				if bytes.Equal(in.ID, txID) && in.Out == out {
+					return tx, in.Secret, nil
+				}
+			}
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	return nil, nil, errors.New("Contract " + hex.EncodeToString(txID) + " has not been spent")
+}
@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+/*
+a transaction can be locked in two ways
+absolute: Transaction.LockTime is a block height when it is below LockTimeThreshold and a unix time otherwise, the transaction can only go into a block with a greater height or whose median time past is greater
+relative: TxInput.Sequence says how many blocks (or units of 512 seconds when SequenceLockTimeTypeFlag is set) must pass after the spent output was mined, setting SequenceLockTimeDisableFlag turns the relative lock off
+*/
+const (
+	LockTimeThreshold = 500000000 //same threshold as bitcoin, heights below it and unix times above it
+
+	SequenceFinal               = uint32(0xffffffff)
+	SequenceLockTimeDisableFlag = uint32(1 << 31)
+	SequenceLockTimeTypeFlag    = uint32(1 << 22)
+	SequenceLockTimeMask        = uint32(0x0000ffff)
+	SequenceLockTimeGranularity = 9 //time based relative locks count in units of 2^9 = 512 seconds
+
+	medianTimeBlocks = 11 //number of blocks used to calculate the median time past
+)
+
+//relative lock of a number of blocks
+func SequenceFromHeight(blocks int) uint32 {
+	return uint32(blocks) & SequenceLockTimeMask
+}
+
+//relative lock of a number of seconds, rounded up to the next 512 seconds
+func SequenceFromSeconds(seconds int64) uint32 {
+	units := (seconds + (1 << SequenceLockTimeGranularity) - 1) >> SequenceLockTimeGranularity
+	return SequenceLockTimeTypeFlag | (uint32(units) & SequenceLockTimeMask)
+}
+
+//checks the absolute lock time against the height of the block the transaction goes into and the median time past of its parent
+func (tx *Transaction) IsFinal(height int, medianTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	if tx.LockTime < LockTimeThreshold {
+		return tx.LockTime < int64(height)
+	}
+	return tx.LockTime < medianTime
+}
+
+//median timestamp of the block with this hash and the ones before it, a block's own timestamp can be set by its miner so locks are checked against this instead
+func (chain *Blockchain) MedianTimePast(hash []byte) int64 {
+	var timestamps []int64
+	iter := &BlockchainIterator{hash, chain.Database}
+	for len(timestamps) < medianTimeBlocks {
+		block := iter.Next()
+		timestamps = append(timestamps, block.Timestamp)
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+/*
+returns an error if tx can not be included in a block at height on top of the block with hash prevHash
+this is used for mined and received blocks as well as for transactions we accept from peers
+*/
+func (chain *Blockchain) CheckLocks(tx *Transaction, height int, prevHash []byte) error {
+	return chain.CheckLocksUnconfirmed(tx, height, prevHash, nil)
+}
+
+/*
+same as CheckLocks for a transaction spending outputs of transactions that are not mined yet, unconfirmed holds their IDs
+they are mined in the same block as tx at the earliest, so a relative lock on an input spending one of them can not have passed yet
+*/
+func (chain *Blockchain) CheckLocksUnconfirmed(tx *Transaction, height int, prevHash []byte, unconfirmed map[string]bool) error {
+	return chain.checkLocks(tx, height, prevHash, unconfirmed, chain.FindTransactionBlock)
+}
+
+//minedIn finds the block of a spent transaction on the branch of prevHash, only called for inputs whose relative lock is on
+func (chain *Blockchain) checkLocks(tx *Transaction, height int, prevHash []byte, unconfirmed map[string]bool, minedIn func([]byte) (*Block, error)) error {
+	medianTime := chain.MedianTimePast(prevHash)
+	if !tx.IsFinal(height, medianTime) {
+		return fmt.Errorf("Transaction %x is locked until %d", tx.ID, tx.LockTime)
+	}
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	for _, in := range tx.Inputs {
+		if in.Sequence&SequenceLockTimeDisableFlag != 0 {
+			continue
+		}
+		if unconfirmed[hex.EncodeToString(in.ID)] {
+			if in.Sequence&SequenceLockTimeMask != 0 {
+				return fmt.Errorf("Input %s:%d of transaction %x is locked and spends an unconfirmed output", hex.EncodeToString(in.ID), in.Out, tx.ID)
+			}
+			continue
+		}
+		prevBlock, err := minedIn(in.ID)
+		if err != nil {
+			return err
+		}
+		value := int64(in.Sequence & SequenceLockTimeMask)
+		if in.Sequence&SequenceLockTimeTypeFlag != 0 {
+			minTime := chain.MedianTimePast(prevBlock.Hash) + value<<SequenceLockTimeGranularity
+			if minTime > medianTime {
+				return fmt.Errorf("Input %s:%d of transaction %x is locked until %d", hex.EncodeToString(in.ID), in.Out, tx.ID, minTime)
+			}
+		} else if int64(prevBlock.Height)+value > int64(height) {
+			return fmt.Errorf("Input %s:%d of transaction %x is locked until height %d", hex.EncodeToString(in.ID), in.Out, tx.ID, int64(prevBlock.Height)+value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//mines the transactions on top of our last block with a coinbase in front and brings the UTXO set up to date
+func mineTestBlock(t *testing.T, chain *Blockchain, UTXOSet UTXOSet, owner *wallet.Wallet, transactions ...*Transaction) *Block {
+	block := blockOn(chain, chain.LastHash, append([]*Transaction{CoinbaseTx(string(owner.Address()), "")}, transactions...))
+	if err := chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet.Update(block)
+	return block
+}
+
+func TestFindTransactionUsesIndex(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain, UTXOSet := newTestChain(t, owner)
+	tx := spendTx(owner, genesisCoinbase(t, chain), 0, Subsidy, string(owner.Address()))
+	block := mineTestBlock(t, chain, UTXOSet, owner, tx)
+
+	found, err := chain.FindTransactionBlock(tx.ID)
+	if err != nil || !bytes.Equal(found.Hash, block.Hash) {
+		t.Fatalf("transaction found in %x (%v), expected %x", found, err, block.Hash)
+	}
+	//a rebuilt index finds it as well
+	UTXOSet.Reindex()
+	if _, err := chain.FindTransaction(tx.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.FindTransaction([]byte("unknown")); err == nil {
+		t.Fatal("found a transaction that does not exist")
+	}
+}
+
+func TestRelativeLockCountsFromBlockOfSpentOutput(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain, UTXOSet := newTestChain(t, owner)
+	parent := spendTx(owner, genesisCoinbase(t, chain), 0, Subsidy, string(owner.Address()))
+	mineTestBlock(t, chain, UTXOSet, owner, parent)
+
+	in := TxInput{parent.ID, 0, nil, owner.PublicKey, SequenceFromHeight(2), nil}
+	child := Transaction{nil, []TxInput{in}, []TxOutput{*NewTXOutput(Subsidy, string(owner.Address()))}, 0}
+	Handle(child.SignInput(0, owner, parent.Outputs[0]))
+	child.ID = child.Hash()
+
+	//the parent is at height 1, so the child can go into a block at height 3 at the earliest
+	early := blockOn(chain, chain.LastHash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), &child})
+	expectRejected(t, chain, early, "locked until height 3")
+	mineTestBlock(t, chain, UTXOSet, owner)
+	mineTestBlock(t, chain, UTXOSet, owner, &child)
+}
+
+//the spent output is only on the branch the block is on, so the lock is counted on that branch
+func TestRelativeLockOnSideBranch(t *testing.T) {
+	owner := wallet.MakeWallet()
+	chain, UTXOSet := newTestChain(t, owner)
+	genesis := chain.LastHash
+	coinbase := genesisCoinbase(t, chain)
+	mineTestBlock(t, chain, UTXOSet, owner)
+	mineTestBlock(t, chain, UTXOSet, owner)
+
+	parent := spendTx(owner, coinbase, 0, Subsidy, string(owner.Address()))
+	side := blockOn(chain, genesis, []*Transaction{CoinbaseTx(string(owner.Address()), ""), parent})
+	if err := chain.AddBlock(side); err != nil {
+		t.Fatal(err)
+	}
+	in := TxInput{parent.ID, 0, nil, owner.PublicKey, SequenceFromHeight(1), nil}
+	child := Transaction{nil, []TxInput{in}, []TxOutput{*NewTXOutput(Subsidy, string(owner.Address()))}, 0}
+	Handle(child.SignInput(0, owner, parent.Outputs[0]))
+	child.ID = child.Hash()
+	next := blockOn(chain, side.Hash, []*Transaction{CoinbaseTx(string(owner.Address()), ""), &child})
+	if err := chain.AddBlock(next); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -30,10 +30,17 @@ func NewProof(b *Block) *ProofofWork {
 
 //we create a cohesive set of bytes which we return from this function
 func (pow *ProofofWork) InitData(nonce int) []byte {
+	return powData(pow.Block.PrevHash, pow.Block.HashTransactions(), pow.Block.Timestamp, pow.Block.Height, nonce)
+}
+
+//everything the proof of work hashes, a header carries all of it so its work can be checked without the transactions
+func powData(prevHash, merkleRoot []byte, timestamp int64, height, nonce int) []byte {
 	data := bytes.Join(
 		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
+			prevHash,
+			merkleRoot,
+			ToByte(timestamp),
+			ToByte(int64(height)),
 			ToByte(int64(nonce)),
 			ToByte(int64(Difficulty)),
 		},
@@ -93,3 +100,13 @@ func (pow *ProofofWork) Validate() bool {
 
 	return intHash.Cmp(pow.Target) == -1
 }
+
+//checks the work of a header and that its hash is the one the work was done for
+func (h *BlockHeader) Validate() bool {
+	var intHash big.Int
+
+	hash := sha256.Sum256(powData(h.PrevHash, h.MerkleRoot, h.Timestamp, h.Height, h.Nonce))
+	intHash.SetBytes(hash[:])
+
+	return intHash.Cmp(NewProof(&Block{}).Target) == -1 && bytes.Equal(hash[:], h.Hash)
+}
@@ -0,0 +1,231 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+/*
+a partially signed transaction carries an unsigned (or not fully signed) transaction together with the outputs its inputs spend
+because the spent outputs travel with it a machine that only has the wallet file and no copy of the chain can still sign it
+the usual flow is create -> sign (possibly by several parties) -> combine -> finalize -> broadcast
+*/
+type PartialTransaction struct {
+	Tx          Transaction
+	PrevOutputs []TxOutput //PrevOutputs[i] is the output spent by Tx.Inputs[i]
+}
+
+//the dust threshold the CLI uses unless it is given another one
+const DefaultDustThreshold = 1
+
+//how a new transaction is put together
+type TxOptions struct {
+	LockTime      int64        //see Transaction.LockTime
+	Selector      CoinSelector //picks the outputs that are spent, nil keeps the database order
+	FeeRate       int          //fee per 1000 bytes of the signed transaction, the unit the mempool compares transactions in
+	DustThreshold int          //change worth less than this is not given an output of its own, it is left out of the transaction and so becomes part of the fee
+	Replaceable   bool         //the transaction can be replaced in the mempool by bumping its fee
+}
+
+//the fee of size bytes at feeRate, rounded up so the transaction never pays less than the rate
+func feeFor(size, feeRate int) int {
+	return (size*feeRate + 999) / 1000
+}
+
+//an input with the largest key and signature, sizes are measured on it so they follow the encoding
+func signedInput() TxInput {
+	return TxInput{make([]byte, 32), 0, make([]byte, wallet.SignatureLength), make([]byte, 1+64), SequenceFinal, nil}
+}
+
+//bytes every signed input adds to a transaction
+func signedInputSize() int {
+	one := Transaction{nil, []TxInput{signedInput()}, nil, 0}
+	two := Transaction{nil, []TxInput{signedInput(), signedInput()}, nil, 0}
+	return len(two.Serialize()) - len(one.Serialize())
+}
+
+//builds an unsigned transaction spending outputs of from, only the addresses are needed and no private key is loaded
+//the change goes to change or back to from when that is empty, an error when a payment is not valid or from can not pay for them and the fee
+func NewPartialTransaction(from, change string, payments []Payment, options TxOptions, UTXO OutputSet) (*PartialTransaction, error) {
+	outputs, err := PaymentOutputs(payments)
+	if err != nil {
+		return nil, err
+	}
+	return newPartialTransaction(from, change, outputs, options, UTXO)
+}
+
+/*
+funds the payment outputs and the fee from the outputs of from and sends the rest to change in a single change output
+the fee is options.FeeRate for the transaction without inputs and a change output, and for every input the selector picks
+so the selector weighs each output by what it is worth once the fee for spending it is paid
+the change output is put at a random position so it can not be told apart from the payments by where it is
+*/
+func newPartialTransaction(from, change string, payments []TxOutput, options TxOptions, UTXO OutputSet) (*PartialTransaction, error) {
+	var inputs []TxInput
+	var outputs []TxOutput
+	var prevOutputs []TxOutput
+
+	amt := 0
+	for _, payment := range payments {
+		amt += payment.Value
+	}
+	pubKeyHash := wallet.AddressToPubKeyHash(from)
+	selector := options.Selector
+	if selector == nil {
+		selector = KeyOrder{}
+	}
+	if change == "" {
+		change = from
+	}
+	//measured with an ID and one input, the encoding describes the input type once so that part is paid for whatever the selector picks
+	unfunded := Transaction{make([]byte, 32), []TxInput{signedInput()}, append(append([]TxOutput{}, payments...), *NewTXOutput(1, change)), options.LockTime}
+	fee := feeFor(len(unfunded.Serialize())-signedInputSize(), options.FeeRate)
+	feePerInput := feeFor(signedInputSize(), options.FeeRate)
+	accumulated, validOutputs, err := selectOutputs(UTXO, pubKeyHash, amt+fee, feePerInput, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	for txid, outs := range validOutputs {
+		txID, err := hex.DecodeString(txid)
+		if err != nil {
+			return nil, err
+		}
+		for _, out := range outs {
+			prevOutput, ok := UTXO.FindOutput(txID, out)
+			if !ok {
+				return nil, fmt.Errorf("Output %s:%d is not spendable", txid, out)
+			}
+			inputs = append(inputs, TxInput{txID, out, nil, nil, inputSequence(options.Replaceable), nil})
+			prevOutputs = append(prevOutputs, prevOutput)
+		}
+	}
+	fee += len(inputs) * feePerInput
+
+	outputs = append(outputs, payments...)
+	if rest := accumulated - amt - fee; rest > 0 && rest >= options.DustThreshold {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		pos := random.Intn(len(outputs) + 1)
+		outputs = append(outputs, TxOutput{})
+		copy(outputs[pos+1:], outputs[pos:])
+		outputs[pos] = *NewTXOutput(rest, change)
+	}
+
+	return &PartialTransaction{Transaction{nil, inputs, outputs, options.LockTime}, prevOutputs}, nil
+}
+
+//signs every input that is locked to the signer's key and not signed yet, returns how many inputs were signed
+func (ptx *PartialTransaction) Sign(signer wallet.Signer) (int, error) {
+	pubKey, err := signer.GetPublicKey()
+	if err != nil {
+		return 0, err
+	}
+	pubKeyHash := wallet.PublicKeyHash(pubKey)
+	if ts, ok := signer.(TransactionSigner); ok {
+		return ptx.signWith(ts, pubKey, pubKeyHash)
+	}
+	signed := 0
+	for inId, in := range ptx.Tx.Inputs {
+		if len(in.Sig) != 0 || !ptx.PrevOutputs[inId].IsLockedWithKey(pubKeyHash) {
+			continue
+		}
+		if err := ptx.Tx.SignInput(inId, signer, ptx.PrevOutputs[inId]); err != nil {
+			return signed, err
+		}
+		ptx.Tx.Inputs[inId].Pubkey = pubKey
+		signed++
+	}
+	return signed, nil
+}
+
+//takes the signatures of a TransactionSigner, each is checked as the signer works out the digests on its own
+func (ptx *PartialTransaction) signWith(signer TransactionSigner, pubKey, pubKeyHash []byte) (int, error) {
+	signatures, err := signer.SignTransaction(ptx)
+	if err != nil {
+		return 0, err
+	}
+	signed := 0
+	for inId, sig := range signatures {
+		in := &ptx.Tx.Inputs[inId]
+		if sig == nil || len(in.Sig) != 0 || !ptx.PrevOutputs[inId].IsLockedWithKey(pubKeyHash) {
+			continue
+		}
+		in.Sig, in.Pubkey = sig, pubKey
+		if !ptx.Tx.VerifyInput(inId, ptx.PrevOutputs[inId]) {
+			in.Sig, in.Pubkey = nil, nil
+			return signed, fmt.Errorf("The signer returned an invalid signature for input %d", inId)
+		}
+		signed++
+	}
+	return signed, nil
+}
+
+//merges the signatures of another copy of the same transaction into this one
+func (ptx *PartialTransaction) Combine(other *PartialTransaction) error {
+	if !bytes.Equal(ptx.unsignedHash(), other.unsignedHash()) || len(ptx.PrevOutputs) != len(other.PrevOutputs) {
+		return errors.New("Partial transactions do not spend and create the same outputs")
+	}
+	for inId, in := range other.Tx.Inputs {
+		if len(ptx.Tx.Inputs[inId].Sig) == 0 && len(in.Sig) != 0 {
+			ptx.Tx.Inputs[inId].Sig = in.Sig
+			ptx.Tx.Inputs[inId].Pubkey = in.Pubkey
+		}
+	}
+	return nil
+}
+
+func (ptx *PartialTransaction) unsignedHash() []byte {
+	txCopy := ptx.Tx.TrimmedCopy()
+	return txCopy.Hash()
+}
+
+func (ptx *PartialTransaction) IsComplete() bool {
+	for _, in := range ptx.Tx.Inputs {
+		if len(in.Sig) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//checks every signature and returns the final transaction with its ID set, ready to be mined or sent to peers
+func (ptx *PartialTransaction) Finalize() (*Transaction, error) {
+	if !ptx.IsComplete() {
+		return nil, errors.New("Transaction is not fully signed")
+	}
+	tx := ptx.Tx
+	for inId := range tx.Inputs {
+		if !tx.VerifyInput(inId, ptx.PrevOutputs[inId]) {
+			return nil, fmt.Errorf("Invalid signature for input %d", inId)
+		}
+	}
+	tx.ID = tx.Hash()
+	return &tx, nil
+}
+
+func (ptx PartialTransaction) Serialize() []byte {
+	var encoded bytes.Buffer
+	enc := gob.NewEncoder(&encoded)
+	err := enc.Encode(ptx)
+	Handle(err)
+	return encoded.Bytes()
+}
+
+func DeserializePartialTransaction(data []byte) (*PartialTransaction, error) {
+	var ptx PartialTransaction
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&ptx); err != nil {
+		return nil, err
+	}
+	if len(ptx.PrevOutputs) != len(ptx.Tx.Inputs) {
+		return nil, errors.New("Partial transaction is missing previous outputs")
+	}
+	return &ptx, nil
+}
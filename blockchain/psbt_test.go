@@ -0,0 +1,183 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//NewPartialTransaction that fails the test when the transaction can not be built
+func partialTx(t *testing.T, from, change string, payments []Payment, options TxOptions, UTXO OutputSet) *PartialTransaction {
+	t.Helper()
+	ptx, err := NewPartialTransaction(from, change, payments, options, UTXO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ptx
+}
+
+//an unsigned transaction spending one output of each owner and paying the sum to to
+func twoKeyTransaction(first, second *wallet.Wallet, value int, to string) *PartialTransaction {
+	var inputs []TxInput
+	var prevOutputs []TxOutput
+	for i, owner := range []*wallet.Wallet{first, second} {
+		id := sha256.Sum256([]byte{byte(i), 'k'})
+		inputs = append(inputs, TxInput{id[:], 0, nil, nil, SequenceFinal, nil})
+		prevOutputs = append(prevOutputs, *NewTXOutput(value, string(owner.Address())))
+	}
+	return &PartialTransaction{Transaction{nil, inputs, []TxOutput{*NewTXOutput(2*value, to)}, 0}, prevOutputs}
+}
+
+func TestPartialTransactionSignedBySeparateSigners(t *testing.T) {
+	first, second, to := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	ptx := twoKeyTransaction(first, second, 5, string(to.Address()))
+
+	//each signer gets its own copy, as it would on machines that never see each other
+	firstCopy, err := DeserializePartialTransaction(ptx.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondCopy, err := DeserializePartialTransaction(ptx.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed, err := firstCopy.Sign(first); err != nil || signed != 1 {
+		t.Fatalf("first signer signed %d inputs with %v, expected 1", signed, err)
+	}
+	if signed, err := secondCopy.Sign(second); err != nil || signed != 1 {
+		t.Fatalf("second signer signed %d inputs with %v, expected 1", signed, err)
+	}
+	if _, err := firstCopy.Finalize(); err == nil {
+		t.Fatal("a transaction with one of two signatures was finalized")
+	}
+
+	if err := firstCopy.Combine(secondCopy); err != nil {
+		t.Fatal(err)
+	}
+	if !firstCopy.IsComplete() {
+		t.Fatal("the combined transaction is missing a signature")
+	}
+	tx, err := firstCopy.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.VerifyOutputs(ptx.PrevOutputs) {
+		t.Fatal("the finalized transaction does not verify")
+	}
+}
+
+func TestTransactionWithoutFundsIsAnError(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	funds := testCandidates(from, 5, 5)
+	payments := []Payment{{string(other.Address()), 11}}
+	if _, err := NewPartialTransaction(from, "", payments, TxOptions{}, funds); err != ErrInsufficientFunds {
+		t.Fatalf("an unsigned transaction paying more than there is failed with %v", err)
+	}
+	if _, err := NewTransaction(owner, "", payments, TxOptions{}, funds); err != ErrInsufficientFunds {
+		t.Fatalf("a transaction paying more than there is failed with %v", err)
+	}
+	contract := HTLC{make([]byte, 32), wallet.PublicKeyHash(other.PublicKey), wallet.PublicKeyHash(owner.PublicKey), 42}
+	if _, err := NewHTLCTransaction(owner, "", contract, 11, TxOptions{}, funds); err != ErrInsufficientFunds {
+		t.Fatalf("a contract worth more than there is failed with %v", err)
+	}
+}
+
+func TestPartialTransactionSerializeRoundTrip(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	ptx := partialTx(t, from, "", []Payment{{string(other.Address()), 7}}, TxOptions{LockTime: 42}, testCandidates(from, 5, 5))
+	if _, err := ptx.Sign(owner); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DeserializePartialTransaction(ptx.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Tx.String() != ptx.Tx.String() || len(decoded.PrevOutputs) != len(ptx.PrevOutputs) {
+		t.Fatalf("decoded\n%s\nexpected\n%s", decoded.Tx, ptx.Tx)
+	}
+	for i, prevOut := range decoded.PrevOutputs {
+		if prevOut.Value != ptx.PrevOutputs[i].Value || string(prevOut.LockingHash()) != string(ptx.PrevOutputs[i].LockingHash()) {
+			t.Fatalf("previous output %d did not survive the round trip", i)
+		}
+	}
+	if _, err := decoded.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	//a file without the spent outputs can not be signed offline
+	decoded.PrevOutputs = decoded.PrevOutputs[:1]
+	if _, err := DeserializePartialTransaction(decoded.Serialize()); err == nil {
+		t.Fatal("a partial transaction missing a previous output was decoded")
+	}
+}
+
+func TestCombineRejectsDifferentTransaction(t *testing.T) {
+	first, second, to := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	ptx := twoKeyTransaction(first, second, 5, string(to.Address()))
+	other := twoKeyTransaction(first, second, 5, string(to.Address()))
+	other.Tx.Outputs[0].Value = 9
+	if _, err := other.Sign(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := ptx.Combine(other); err == nil {
+		t.Fatal("signatures of a transaction paying a different amount were combined")
+	}
+	if len(ptx.Tx.Inputs[1].Sig) != 0 {
+		t.Fatal("a rejected combine left a signature behind")
+	}
+}
+
+func TestFinalizeRejectsForgedSignatures(t *testing.T) {
+	first, second, to := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	ptx := twoKeyTransaction(first, second, 5, string(to.Address()))
+	if _, err := ptx.Sign(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ptx.Finalize(); err == nil || !strings.Contains(err.Error(), "not fully signed") {
+		t.Fatalf("an incomplete transaction finalized with %v", err)
+	}
+
+	//the second input signed with the first key, which the output is not locked to
+	forged := *ptx
+	forged.Tx.Inputs = append([]TxInput{}, ptx.Tx.Inputs...)
+	Handle(forged.Tx.SignInput(1, first, forged.PrevOutputs[1]))
+	forged.Tx.Inputs[1].Pubkey = first.PublicKey
+	if _, err := forged.Finalize(); err == nil {
+		t.Fatal("a signature made with the wrong key was finalized")
+	}
+
+	//the right key, but the signature does not match the transaction any more
+	if _, err := ptx.Sign(second); err != nil {
+		t.Fatal(err)
+	}
+	sig := append([]byte{}, ptx.Tx.Inputs[1].Sig...)
+	sig[len(sig)-1] ^= 1
+	ptx.Tx.Inputs[1].Sig = sig
+	if _, err := ptx.Finalize(); err == nil || !strings.Contains(err.Error(), "input 1") {
+		t.Fatalf("a tampered signature finalized with %v", err)
+	}
+}
+
+//a coordinator that understates what an input is worth gets a signature that does not hold for the real output
+func TestSignatureCommitsToSpentValue(t *testing.T) {
+	owner, to := wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	ptx := partialTx(t, from, from, []Payment{{string(to.Address()), 5}}, TxOptions{}, testCandidates(from, 100))
+	actual := ptx.PrevOutputs[0]
+
+	ptx.PrevOutputs[0].Value = 6
+	if _, err := ptx.Sign(owner); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ptx.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if ptx.Tx.VerifyInput(0, actual) {
+		t.Fatal("a signature made for a value of 6 verifies against the output worth 100")
+	}
+}
@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+/*
+a transaction in the mempool can be replaced by one spending the same outputs when it opted in to that
+it opts in when one of its inputs has a sequence up to SequenceReplaceable, the inputs we build get SequenceNoReplace unless TxOptions.Replaceable is set
+both have SequenceLockTimeDisableFlag set, so signalling never puts a relative lock on an input
+*/
+const (
+	SequenceNoReplace   = SequenceFinal - 1
+	SequenceReplaceable = SequenceFinal - 2
+)
+
+func inputSequence(replaceable bool) uint32 {
+	if replaceable {
+		return SequenceReplaceable
+	}
+	return SequenceNoReplace
+}
+
+func (tx *Transaction) SignalsReplacement() bool {
+	if tx.IsCoinbase() {
+		return false
+	}
+	for _, in := range tx.Inputs {
+		if in.Sequence <= SequenceReplaceable {
+			return true
+		}
+	}
+	return false
+}
+
+//inputs minus outputs
+func (ptx *PartialTransaction) Fee() int {
+	fee := 0
+	for _, prevOut := range ptx.PrevOutputs {
+		fee += prevOut.Value
+	}
+	for _, out := range ptx.Tx.Outputs {
+		fee -= out.Value
+	}
+	return fee
+}
+
+/*
+a copy of ptx spending the same inputs that pays fee more to the miner, the fee is taken from the output to change
+change worth less than dustThreshold after that is left out and goes to the miner too
+the copy is unsigned and still signals replaceability, so it can be bumped again if it gets stuck as well
+*/
+func (ptx *PartialTransaction) BumpFee(change string, fee, dustThreshold int) (*PartialTransaction, error) {
+	if fee <= 0 {
+		return nil, errors.New("The fee has to go up by at least 1")
+	}
+	if !ptx.Tx.SignalsReplacement() {
+		return nil, errors.New("Transaction does not signal that it can be replaced")
+	}
+	pubKeyHash := wallet.AddressToPubKeyHash(change)
+	changeOut := -1
+	for i, out := range ptx.Tx.Outputs {
+		if out.IsLockedWithKey(pubKeyHash) && (changeOut < 0 || out.Value > ptx.Tx.Outputs[changeOut].Value) {
+			changeOut = i
+		}
+	}
+	if changeOut < 0 {
+		return nil, fmt.Errorf("Transaction has no output to %s to take the fee from", change)
+	}
+	if ptx.Tx.Outputs[changeOut].Value < fee {
+		return nil, fmt.Errorf("The change output only holds %d", ptx.Tx.Outputs[changeOut].Value)
+	}
+
+	var inputs []TxInput
+	for _, in := range ptx.Tx.Inputs {
+		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil, in.Sequence, nil})
+	}
+	var outputs []TxOutput
+	for i, out := range ptx.Tx.Outputs {
+		if i == changeOut {
+			out.Value -= fee
+			if out.Value < dustThreshold || out.Value == 0 {
+				continue
+			}
+		}
+		outputs = append(outputs, out)
+	}
+	if len(outputs) == 0 {
+		return nil, errors.New("The fee would take every output of the transaction")
+	}
+	prevOutputs := append([]TxOutput{}, ptx.PrevOutputs...)
+	return &PartialTransaction{Transaction{nil, inputs, outputs, ptx.Tx.LockTime}, prevOutputs}, nil
+}
@@ -0,0 +1,64 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+func TestDustThresholdDropsSmallChange(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	candidates := testCandidates(from, 10)
+	payments := []Payment{{string(other.Address()), 7}}
+	selector, _ := GetCoinSelector("keyorder")
+
+	//3 is left, it is change at a threshold of 3 and fee at a threshold of 4
+	ptx := partialTx(t, from, "", payments, TxOptions{Selector: selector, DustThreshold: 3}, candidates)
+	if len(ptx.Tx.Outputs) != 2 || ptx.Fee() != 0 {
+		t.Fatalf("%d outputs paying a fee of %d, expected the change to be kept", len(ptx.Tx.Outputs), ptx.Fee())
+	}
+	ptx = partialTx(t, from, "", payments, TxOptions{Selector: selector, DustThreshold: 4}, candidates)
+	if len(ptx.Tx.Outputs) != 1 || ptx.Fee() != 3 {
+		t.Fatalf("%d outputs paying a fee of %d, expected the change to go to the miner", len(ptx.Tx.Outputs), ptx.Fee())
+	}
+}
+
+func TestBumpFeeDustThreshold(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	selector, _ := GetCoinSelector("keyorder")
+	ptx := partialTx(t, from, "", []Payment{{string(other.Address()), 7}}, TxOptions{Selector: selector, Replaceable: true}, testCandidates(from, 20))
+
+	//the change of 13 is down to 3 after a fee of 10
+	bumped, err := ptx.BumpFee(from, 10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bumped.Tx.Outputs) != 2 || bumped.Fee() != 10 {
+		t.Fatalf("%d outputs paying a fee of %d, expected the change to be kept", len(bumped.Tx.Outputs), bumped.Fee())
+	}
+	bumped, err = ptx.BumpFee(from, 10, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bumped.Tx.Outputs) != 1 || bumped.Fee() != 13 {
+		t.Fatalf("%d outputs paying a fee of %d, expected the change to go to the miner", len(bumped.Tx.Outputs), bumped.Fee())
+	}
+}
+
+func TestReplaceableIsAnOption(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	payments := []Payment{{string(other.Address()), 7}}
+	if ptx := partialTx(t, from, "", payments, TxOptions{}, testCandidates(from, 20)); ptx.Tx.SignalsReplacement() {
+		t.Fatal("a transaction built without Replaceable signals replacement")
+	}
+	ptx := partialTx(t, from, "", payments, TxOptions{Replaceable: true}, testCandidates(from, 20))
+	if !ptx.Tx.SignalsReplacement() {
+		t.Fatal("a transaction built with Replaceable does not signal replacement")
+	}
+	if _, err := ptx.BumpFee(from, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,363 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+/*
+the signer daemon keeps the wallet in its own process, the node and the CLI ask it for signatures over a unix socket
+every connection carries one JSON request and gets one JSON response
+a request to sign carries the whole partial transaction and the daemon works out the digest of every input itself
+so its SignerPolicy decides on where the coins go and how much, and an operator confirming a signature sees the same
+the socket is created in a directory only its owner can enter, so nobody else can ever connect to it
+*/
+const (
+	signerGetPublicKey    = "getpublickey"
+	signerSignTransaction = "signtransaction"
+	signerTimeout         = 2 * time.Minute //long enough for an operator to answer a confirmation prompt
+)
+
+var (
+	ErrSignerDenied  = errors.New("The signer refused to sign")
+	ErrDigestSigning = errors.New("The signer daemon only signs whole transactions")
+)
+
+/*
+a signer that is handed the whole transaction and works out what it signs itself, PartialTransaction.Sign prefers it over SignDigest
+it returns a signature for every input it signed at the index of the input, nil for the others
+*/
+type TransactionSigner interface {
+	wallet.Signer
+	SignTransaction(ptx *PartialTransaction) ([][]byte, error)
+}
+
+type signerRequest struct {
+	Method  string
+	Address string
+	Tx      []byte //serialized partial transaction
+}
+
+type signerResponse struct {
+	PublicKey  []byte
+	Signatures [][]byte
+	Error      string
+}
+
+//what the daemon agrees to sign, coins paid back to addresses of its own wallet are change and never limited
+type SignerPolicy struct {
+	Allowed      map[string]bool //addresses whose keys may sign, all of the wallet's when empty
+	Destinations map[string]bool //addresses outside the wallet that may be paid, any when empty
+	MaxAmount    int             //most a transaction may pay outside the wallet, 0 for no limit
+	MaxSpent     int             //most the inputs it signs may be worth less the change, so fee included, 0 for no limit
+	MaxPerMinute int             //transactions signed in any minute, 0 for no limit
+	//asked before every transaction with a description of it, nil signs without asking
+	Confirm func(address, details string) bool
+}
+
+type SignerServer struct {
+	Wallets *wallet.Wallets
+	Policy  SignerPolicy
+
+	mu     sync.Mutex
+	recent []time.Time
+}
+
+func NewSignerServer(wallets *wallet.Wallets, policy SignerPolicy) *SignerServer {
+	return &SignerServer{Wallets: wallets, Policy: policy}
+}
+
+/*
+listens on the socket at path until the listener fails, a socket left behind by an earlier run is removed
+a missing directory for the socket is created for the owner only, one that others can enter is refused
+*/
+func (ss *SignerServer) ListenAndServe(path string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("Others can access %s, the socket has to be in a directory only its owner can enter (chmod 700)", dir)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	return ss.Serve(listener)
+}
+
+func (ss *SignerServer) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go ss.handleConnection(conn)
+	}
+}
+
+func (ss *SignerServer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(signerTimeout))
+	var request signerRequest
+	var response signerResponse
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		response.Error = "Invalid request: " + err.Error()
+	} else {
+		response = ss.handle(request)
+	}
+	json.NewEncoder(conn).Encode(response)
+}
+
+func (ss *SignerServer) handle(request signerRequest) signerResponse {
+	address, err := wallet.NormalizeAddress(request.Address)
+	if err != nil {
+		return signerResponse{Error: "Address is not Valid: " + err.Error()}
+	}
+	if len(ss.Policy.Allowed) != 0 && !ss.Policy.Allowed[address] {
+		return signerResponse{Error: ErrSignerDenied.Error() + ", the address is not allowed"}
+	}
+	w, ok := ss.Wallets.Lookup(address)
+	if !ok {
+		return signerResponse{Error: "The signer has no key for " + address}
+	}
+
+	switch request.Method {
+	case signerGetPublicKey:
+		pubKey, err := w.GetPublicKey()
+		if err != nil {
+			return signerResponse{Error: err.Error()}
+		}
+		return signerResponse{PublicKey: pubKey}
+	case signerSignTransaction:
+		ptx, err := DeserializePartialTransaction(request.Tx)
+		if err != nil {
+			return signerResponse{Error: "Invalid transaction: " + err.Error()}
+		}
+		signatures, err := ss.signTransaction(address, w, ptx)
+		if err != nil {
+			return signerResponse{Error: err.Error()}
+		}
+		return signerResponse{Signatures: signatures}
+	default:
+		return signerResponse{Error: "Unknown signer method " + request.Method}
+	}
+}
+
+//signs the unsigned inputs of ptx locked to the key of address once the policy and the operator agree
+func (ss *SignerServer) signTransaction(address string, w wallet.Wallet, ptx *PartialTransaction) ([][]byte, error) {
+	var inputs []int
+	for inId, in := range ptx.Tx.Inputs {
+		if len(in.Sig) == 0 && ptx.PrevOutputs[inId].IsLockedWithKey(w.PubKeyHash()) {
+			inputs = append(inputs, inId)
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, errors.New("Transaction has no unsigned input for the key of " + address)
+	}
+	if err := ss.checkOutputs(ptx); err != nil {
+		return nil, err
+	}
+	if err := ss.checkSpent(ptx, inputs); err != nil {
+		return nil, err
+	}
+	if err := ss.approve(address, ss.describe(ptx)); err != nil {
+		return nil, err
+	}
+	signatures := make([][]byte, len(ptx.Tx.Inputs))
+	for _, inId := range inputs {
+		sig, err := w.SignDigest(ptx.Tx.SigHash(inId, ptx.PrevOutputs[inId]))
+		if err != nil {
+			return nil, err
+		}
+		signatures[inId] = sig
+	}
+	return signatures, nil
+}
+
+//the address an output pays, the recipient for a contract
+func outputAddress(out TxOutput) string {
+	if out.HTLC != nil {
+		return string(wallet.PubKeyHashToAddress(out.HTLC.RecipientHash))
+	}
+	return string(wallet.PubKeyHashToAddress(out.PubkeyHash))
+}
+
+//true when out pays a key of our wallet outright, a contract locks the coins away even when we are its recipient
+func (ss *SignerServer) isChange(out TxOutput) bool {
+	w, ours := ss.Wallets.Lookup(outputAddress(out))
+	return ours && !w.WatchOnly && out.HTLC == nil
+}
+
+func (ss *SignerServer) checkOutputs(ptx *PartialTransaction) error {
+	paid := 0
+	for _, out := range ptx.Tx.Outputs {
+		if ss.isChange(out) {
+			continue
+		}
+		if address := outputAddress(out); len(ss.Policy.Destinations) != 0 && !ss.Policy.Destinations[address] {
+			return fmt.Errorf("%s, %s is not an allowed destination", ErrSignerDenied, address)
+		}
+		paid += out.Value
+	}
+	if ss.Policy.MaxAmount > 0 && paid > ss.Policy.MaxAmount {
+		return fmt.Errorf("%s, the transaction pays %d out of the wallet and at most %d is allowed", ErrSignerDenied, paid, ss.Policy.MaxAmount)
+	}
+	return nil
+}
+
+/*
+the values of the inputs are the ones the client sends, they can be trusted because the digests commit to them
+a client that understates them gets signatures no node accepts, one whose inputs can not even pay the outputs is refused here
+*/
+func (ss *SignerServer) checkSpent(ptx *PartialTransaction, inputs []int) error {
+	if fee := ptx.Fee(); fee < 0 {
+		return fmt.Errorf("%s, the outputs are worth %d more than the inputs", ErrSignerDenied, -fee)
+	}
+	spent := 0
+	for _, inId := range inputs {
+		spent += ptx.PrevOutputs[inId].Value
+	}
+	for _, out := range ptx.Tx.Outputs {
+		if ss.isChange(out) {
+			spent -= out.Value
+		}
+	}
+	if ss.Policy.MaxSpent > 0 && spent > ss.Policy.MaxSpent {
+		return fmt.Errorf("%s, the transaction spends %d of the wallet with the fee and at most %d is allowed", ErrSignerDenied, spent, ss.Policy.MaxSpent)
+	}
+	return nil
+}
+
+//what the operator is asked to confirm, the fee is what the inputs are worth less the outputs
+func (ss *SignerServer) describe(ptx *PartialTransaction) string {
+	var details strings.Builder
+	spent := 0
+	for _, prevOut := range ptx.PrevOutputs {
+		spent += prevOut.Value
+	}
+	fmt.Fprintf(&details, "  spends %d inputs worth %d\n", len(ptx.Tx.Inputs), spent)
+	for _, out := range ptx.Tx.Outputs {
+		switch {
+		case out.HTLC != nil:
+			fmt.Fprintf(&details, "  locks %d in a contract for %s, refundable to %s after %s\n", out.Value, outputAddress(out), wallet.PubKeyHashToAddress(out.HTLC.RefundHash), time.Unix(out.HTLC.LockTime, 0))
+		case ss.isChange(out):
+			fmt.Fprintf(&details, "  returns %d to %s of this wallet\n", out.Value, outputAddress(out))
+		default:
+			fmt.Fprintf(&details, "  pays %d to %s\n", out.Value, outputAddress(out))
+		}
+	}
+	fmt.Fprintf(&details, "  fee %d", ptx.Fee())
+	if ptx.Tx.LockTime != 0 {
+		fmt.Fprintf(&details, ", locked until %d", ptx.Tx.LockTime)
+	}
+	if ptx.Tx.SignalsReplacement() {
+		fmt.Fprint(&details, ", replaceable")
+	}
+	return details.String()
+}
+
+//one request is approved at a time so confirmation prompts do not overlap
+func (ss *SignerServer) approve(address, details string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.Policy.MaxPerMinute > 0 {
+		var recent []time.Time
+		for _, t := range ss.recent {
+			if time.Since(t) < time.Minute {
+				recent = append(recent, t)
+			}
+		}
+		ss.recent = recent
+		if len(recent) >= ss.Policy.MaxPerMinute {
+			return fmt.Errorf("%s, more than %d transactions in a minute", ErrSignerDenied, ss.Policy.MaxPerMinute)
+		}
+	}
+	if ss.Policy.Confirm != nil && !ss.Policy.Confirm(address, details) {
+		return fmt.Errorf("%s, the operator declined", ErrSignerDenied)
+	}
+	ss.recent = append(ss.recent, time.Now())
+	return nil
+}
+
+//the client side, a TransactionSigner for one address of the wallet of the daemon listening at Path
+type SocketSigner struct {
+	Path    string
+	Address string
+}
+
+func NewSocketSigner(path, address string) *SocketSigner {
+	return &SocketSigner{path, address}
+}
+
+func (s *SocketSigner) call(request signerRequest) (*signerResponse, error) {
+	conn, err := net.DialTimeout("unix", s.Path, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(signerTimeout))
+	request.Address = s.Address
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, err
+	}
+	var response signerResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return &response, nil
+}
+
+//the key is checked against the address so a daemon with the wrong wallet can not slip in another key
+func (s *SocketSigner) GetPublicKey() ([]byte, error) {
+	response, err := s.call(signerRequest{Method: signerGetPublicKey})
+	if err != nil {
+		return nil, err
+	}
+	pubKeyHash, err := wallet.DecodeAddress(s.Address)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(wallet.PublicKeyHash(response.PublicKey), pubKeyHash) {
+		return nil, errors.New("The signer returned a key that does not belong to " + s.Address)
+	}
+	return response.PublicKey, nil
+}
+
+//the daemon does not sign digests it can not tell the meaning of
+func (s *SocketSigner) SignDigest(digest []byte) ([]byte, error) {
+	return nil, ErrDigestSigning
+}
+
+func (s *SocketSigner) SignTransaction(ptx *PartialTransaction) ([][]byte, error) {
+	response, err := s.call(signerRequest{Method: signerSignTransaction, Tx: ptx.Serialize()})
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Signatures) != len(ptx.Tx.Inputs) {
+		return nil, fmt.Errorf("The signer returned %d signatures for %d inputs", len(response.Signatures), len(ptx.Tx.Inputs))
+	}
+	return response.Signatures, nil
+}
@@ -0,0 +1,168 @@
+package blockchain
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//a signer daemon for the key of owner listening in a temporary directory, and a client for it
+func testSignerd(t *testing.T, owner *wallet.Wallet, policy SignerPolicy) *SocketSigner {
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{string(owner.Address()): owner}}
+	path := filepath.Join(t.TempDir(), "signer.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go NewSignerServer(wallets, policy).Serve(listener)
+	return NewSocketSigner(path, string(owner.Address()))
+}
+
+func TestSignerdSignsWholeTransactions(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	signer := testSignerd(t, owner, SignerPolicy{})
+	ptx := partialTx(t, from, "", []Payment{{string(other.Address()), 7}}, TxOptions{}, testCandidates(from, 5, 5))
+
+	if _, err := signer.SignDigest(ptx.Tx.SigHash(0, ptx.PrevOutputs[0])); !errors.Is(err, ErrDigestSigning) {
+		t.Fatalf("the daemon signed a bare digest with %v", err)
+	}
+	signed, err := ptx.Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed != 2 {
+		t.Fatalf("%d inputs signed, expected 2", signed)
+	}
+	if _, err := ptx.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignerdPolicyChecksOutputs(t *testing.T) {
+	owner, allowed, other := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	policy := SignerPolicy{Destinations: map[string]bool{string(allowed.Address()): true}, MaxAmount: 10}
+	signer := testSignerd(t, owner, policy)
+
+	ptx := partialTx(t, from, from, []Payment{{string(other.Address()), 7}}, TxOptions{}, testCandidates(from, 20))
+	if _, err := ptx.Sign(signer); err == nil || !strings.Contains(err.Error(), "not an allowed destination") {
+		t.Fatalf("payment to a destination outside the policy signed with %v", err)
+	}
+	ptx = partialTx(t, from, from, []Payment{{string(allowed.Address()), 11}}, TxOptions{}, testCandidates(from, 20))
+	if _, err := ptx.Sign(signer); err == nil || !strings.Contains(err.Error(), "at most 10") {
+		t.Fatalf("payment over the limit signed with %v", err)
+	}
+
+	//the change of 10 goes back to the wallet and does not count
+	ptx = partialTx(t, from, from, []Payment{{string(allowed.Address()), 10}}, TxOptions{}, testCandidates(from, 20))
+	if _, err := ptx.Sign(signer); err != nil {
+		t.Fatal(err)
+	}
+	if !ptx.IsComplete() {
+		t.Fatal("allowed payment is not signed")
+	}
+}
+
+//a client hiding what an input is worth to burn the rest as fee is refused, or gets a signature for a value that does not exist
+func TestSignerdRefusesUnderstatedInputs(t *testing.T) {
+	owner, allowed := wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	signer := testSignerd(t, owner, SignerPolicy{MaxAmount: 10, MaxSpent: 10})
+	burning := func(claimed int) *PartialTransaction {
+		ptx := partialTx(t, from, from, []Payment{{string(allowed.Address()), 5}}, TxOptions{}, testCandidates(from, 100))
+		ptx.Tx.Outputs = []TxOutput{*NewTXOutput(5, string(allowed.Address()))}
+		ptx.PrevOutputs[0].Value = claimed
+		return ptx
+	}
+
+	if _, err := burning(100).Sign(signer); err == nil || !strings.Contains(err.Error(), "spends 100") {
+		t.Fatalf("a fee of 95 signed with %v", err)
+	}
+	if _, err := burning(4).Sign(signer); err == nil || !strings.Contains(err.Error(), "worth 1 more than the inputs") {
+		t.Fatalf("inputs worth less than the outputs signed with %v", err)
+	}
+
+	ptx := burning(6)
+	if _, err := ptx.Sign(signer); err != nil {
+		t.Fatal(err)
+	}
+	if ptx.Tx.VerifyInput(0, *NewTXOutput(100, from)) {
+		t.Fatal("the signature for an input worth 6 holds for the output worth 100")
+	}
+}
+
+func TestSignerdConfirmSeesTheTransaction(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	from := string(owner.Address())
+	var seen string
+	policy := SignerPolicy{Confirm: func(address, details string) bool {
+		seen = details
+		return false
+	}}
+	signer := testSignerd(t, owner, policy)
+	ptx := partialTx(t, from, from, []Payment{{string(other.Address()), 7}}, TxOptions{}, testCandidates(from, 20))
+
+	if _, err := ptx.Sign(signer); err == nil || !strings.Contains(err.Error(), "declined") {
+		t.Fatalf("declined transaction signed with %v", err)
+	}
+	for _, line := range []string{"pays 7 to " + string(other.Address()), "returns 13 to " + from, "worth 20", "fee 0"} {
+		if !strings.Contains(seen, line) {
+			t.Fatalf("confirmation %q does not show %q", seen, line)
+		}
+	}
+	if ptx.Tx.Inputs[0].Sig != nil {
+		t.Fatal("declined transaction got a signature")
+	}
+}
+
+//ListenAndServe only returns when it fails, so the socket is polled for
+func waitForFile(path string) (os.FileInfo, error) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		info, err := os.Stat(path)
+		if err == nil || time.Now().After(deadline) {
+			return info, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSignerdRefusesSocketOthersCanReach(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "open")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := NewSignerServer(&wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}, SignerPolicy{})
+	if err := server.ListenAndServe(filepath.Join(dir, "signer.sock")); err == nil || !strings.Contains(err.Error(), "Others can access") {
+		t.Fatalf("socket in an open directory gave %v", err)
+	}
+
+	//a missing directory is created for the owner only
+	path := filepath.Join(t.TempDir(), "private", "signer.sock")
+	go server.ListenAndServe(path)
+	info, err := waitForFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		t.Fatalf("%s is not a socket", path)
+	}
+	dirInfo, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := dirInfo.Mode().Perm(); perm != 0700 {
+		t.Fatalf("socket directory created with %o", perm)
+	}
+}
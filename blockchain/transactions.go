@@ -2,25 +2,27 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
 	"strings"
 
 	"github.com/RavjotSandhu/GoBlockchain/wallet"
 )
 
-//Transaction struct -ID,Inputs,Outputs
+//coins the coinbase of a block creates, on top of it the coinbase can take the fees of the block's transactions
+const Subsidy = 20
+
+//Transaction struct -ID,Inputs,Outputs,LockTime
 type Transaction struct {
-	ID      []byte
-	Inputs  []TxInput
-	Outputs []TxOutput
+	ID       []byte
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	LockTime int64 //block height or unix time before which the transaction can not be mined, 0 means no lock
 }
 
 func CoinbaseTx(to, data string) *Transaction {
@@ -31,10 +33,10 @@ func CoinbaseTx(to, data string) *Transaction {
 		Handle(err)
 		data = fmt.Sprintf("%x", randData)
 	}
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(20, to) //100 is reward to the address for mining the block
+	txin := TxInput{[]byte{}, -1, nil, []byte(data), SequenceFinal, nil}
+	txout := NewTXOutput(Subsidy, to) //reward to the address for mining the block
 
-	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
+	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}, 0}
 	tx.ID = tx.Hash()
 	return &tx
 }
@@ -59,12 +61,26 @@ func (tx Transaction) Serialize() []byte {
 	return encoded.Bytes()
 }
 
+func DeserializeTransaction(data []byte) Transaction {
+	transaction, err := DecodeTransaction(data)
+	Handle(err)
+	return transaction
+}
+
+//like DeserializeTransaction but for data from a peer, which may not be a transaction at all
+func DecodeTransaction(data []byte) (Transaction, error) {
+	var transaction Transaction
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&transaction)
+	return transaction, err
+}
+
 //allows us to determine wether the transaction is coinbase or not
 func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+func (tx *Transaction) Sign(signer wallet.Signer, prevTXs map[string]Transaction) {
 	if tx.IsCoinbase() {
 		return
 	}
@@ -73,32 +89,43 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 			log.Panic("ERROR: Previous transaction is not correct")
 		}
 	}
-	txCopy := tx.TrimmedCopy()
 
-	for inId, in := range txCopy.Inputs {
+	for inId, in := range tx.Inputs {
 		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Sig = nil
-		txCopy.Inputs[inId].Pubkey = prevTX.Outputs[in.Out].PubkeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].Pubkey = nil
+		Handle(tx.SignInput(inId, signer, prevTX.Outputs[in.Out]))
+	}
+}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-		Handle(err)
-		signature := append(r.Bytes(), s.Bytes()...)
-		tx.Inputs[inId].Sig = signature
+/*
+hash that input inId commits to: the trimmed transaction with the locking hash and the value of the output being spent put in place of that input's public key
+with the value in it a signer that is shown less than the input is worth makes a signature no node accepts, so it can trust the fee it works out
+*/
+func (tx *Transaction) SigHash(inId int, prevOut TxOutput) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.Inputs[inId].Pubkey = append(append([]byte{}, prevOut.LockingHash()...), ToByte(int64(prevOut.Value))...)
+	return txCopy.Hash()
+}
+
+//signs a single input, so inputs locked to different keys can be signed by different parties, the key can be of any scheme
+func (tx *Transaction) SignInput(inId int, signer wallet.Signer, prevOut TxOutput) error {
+	signature, err := signer.SignDigest(tx.SigHash(inId, prevOut))
+	if err != nil {
+		return err
 	}
+	tx.Inputs[inId].Sig = signature
+	return nil
 }
 
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil})
+		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil, in.Sequence, nil})
 	}
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubkeyHash})
+		outputs = append(outputs, TxOutput{out.Value, out.PubkeyHash, out.HTLC})
 	}
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime}
 	return txCopy
 }
 
@@ -112,80 +139,132 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-	for inId, in := range tx.Inputs {
+	var prevOuts []TxOutput
+	for _, in := range tx.Inputs {
 		prevTx := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Sig = nil
-		txCopy.Inputs[inId].Pubkey = prevTx.Outputs[in.Out].PubkeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].Pubkey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(in.Sig)
-		r.SetBytes(in.Sig[:(sigLen / 2)])
-		s.SetBytes(in.Sig[(sigLen / 2):])
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.Pubkey)
-		x.SetBytes(in.Pubkey[:(keyLen / 2)])
-		y.SetBytes(in.Pubkey[(keyLen / 2):])
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
+		prevOuts = append(prevOuts, prevTx.Outputs[in.Out])
+	}
+	return tx.VerifyOutputs(prevOuts)
+}
+
+//same as Verify when the outputs the inputs spend are already known, prevOuts[i] is the output spent by input i
+func (tx *Transaction) VerifyOutputs(prevOuts []TxOutput) bool {
+	if len(prevOuts) != len(tx.Inputs) {
+		return false
+	}
+	//the signatures are checked together so schemes that can verify a batch faster get to do so
+	var checks []wallet.SignatureCheck
+	for inId := range tx.Inputs {
+		check, ok := tx.signatureCheck(inId, prevOuts[inId])
+		if !ok {
 			return false
 		}
+		checks = append(checks, check)
 	}
-	return true
+	return wallet.VerifyBatch(checks)
+}
+
+//checks that input inId carries the key the spent output is locked to and a valid signature made with it
+func (tx *Transaction) VerifyInput(inId int, prevOut TxOutput) bool {
+	check, ok := tx.signatureCheck(inId, prevOut)
+	return ok && wallet.VerifySignature(check.PubKey, check.Hash, check.Sig)
+}
+
+//the signature input inId has to carry, false when the input can not spend prevOut whatever its signature is
+func (tx *Transaction) signatureCheck(inId int, prevOut TxOutput) (wallet.SignatureCheck, bool) {
+	in := tx.Inputs[inId]
+	if len(in.Sig) == 0 || len(in.Pubkey) == 0 {
+		return wallet.SignatureCheck{}, false
+	}
+	if prevOut.HTLC != nil {
+		if !prevOut.HTLC.CanBeSpentBy(tx, in) {
+			return wallet.SignatureCheck{}, false
+		}
+	} else if !in.UsesKey(prevOut.PubkeyHash) {
+		return wallet.SignatureCheck{}, false
+	}
+	return wallet.SignatureCheck{PubKey: in.Pubkey, Hash: tx.SigHash(inId, prevOut), Sig: in.Sig}, true
 }
 
 func (tx Transaction) String() string {
 	var lines []string
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
+	}
 	for i, input := range tx.Inputs {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
 		lines = append(lines, fmt.Sprintf("       Sig: %x", input.Sig))
 		lines = append(lines, fmt.Sprintf("       Pubkey:    %x", input.Pubkey))
+		lines = append(lines, fmt.Sprintf("       Sequence:  %d", input.Sequence))
+		if input.Secret != nil {
+			lines = append(lines, fmt.Sprintf("       Secret:    %x", input.Secret))
+		}
 	}
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
+		if output.HTLC != nil {
+			lines = append(lines, fmt.Sprintf("       HTLC:   %x", output.HTLC.Hash()))
+			continue
+		}
 		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubkeyHash))
 	}
 	return strings.Join(lines, "\n")
 }
 
-func NewTransaction(from, to string, amt int, UTXO *UTXOSet) *Transaction {
-	var inputs []TxInput
-	var outputs []TxOutput
+//one payment of a transaction, a transaction can pay any number of addresses at once
+type Payment struct {
+	Address string
+	Amount  int
+}
 
-	wallets, err := wallet.CreateWallets()
-	Handle(err)
-	w := wallets.GetWallet(from)
-	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
-	accumualted, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amt)
-	if accumualted < amt {
-		log.Panic("Error: not enough funds")
+/*
+turns payments into outputs, every amount has to be positive and the total must not overflow
+an address paid twice is most likely a line copied by mistake in a payout file, so that is refused too, whichever form the address is written in
+*/
+func PaymentOutputs(payments []Payment) ([]TxOutput, error) {
+	var outputs []TxOutput
+	total := 0
+	if len(payments) == 0 {
+		return nil, errors.New("Transaction has no payments")
 	}
-
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		Handle(err)
-		for _, out := range outs {
-			input := TxInput{txID, out, nil, w.PublicKey}
-			inputs = append(inputs, input)
+	paid := make(map[string]bool)
+	for _, payment := range payments {
+		pubKeyHash, err := wallet.DecodeAddress(payment.Address)
+		if err != nil {
+			return nil, fmt.Errorf("Address %s is not Valid: %s", payment.Address, err)
 		}
+		if paid[string(pubKeyHash)] {
+			return nil, fmt.Errorf("Address %s is paid more than once", payment.Address)
+		}
+		paid[string(pubKeyHash)] = true
+		if payment.Amount <= 0 {
+			return nil, fmt.Errorf("Amount %d to %s is not positive", payment.Amount, payment.Address)
+		}
+		if total+payment.Amount < total {
+			return nil, errors.New("Total amount of the payments is too large")
+		}
+		total += payment.Amount
+		outputs = append(outputs, *NewTXOutput(payment.Amount, payment.Address))
 	}
+	return outputs, nil
+}
 
-	outputs = append(outputs, *NewTXOutput(amt, to)) //first output is transaction
-	if accumualted > amt {
-		outputs = append(outputs, *NewTXOutput(accumualted-amt, from))
-	} //second output if there is any leftover token in sender account
-
-	tx := Transaction{nil, inputs, outputs}
-	tx.ID = tx.Hash()
-	UTXO.Block_chain.SignTransaction(&tx, w.PrivateKey)
-	return &tx
+//spends the outputs of the signer's address and returns the change to it, a wallet signer has to be unlocked
+func NewTransaction(signer wallet.Signer, change string, payments []Payment, options TxOptions, UTXO OutputSet) (*Transaction, error) {
+	from, err := wallet.SignerAddress(signer)
+	if err != nil {
+		return nil, err
+	}
+	ptx, err := NewPartialTransaction(from, change, payments, options, UTXO)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ptx.Sign(signer); err != nil {
+		return nil, err
+	}
+	return ptx.Finalize()
 }
@@ -0,0 +1,45 @@
+package blockchain
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+func TestPaymentOutputs(t *testing.T) {
+	first, second := wallet.MakeWallet(), wallet.MakeWallet()
+	outputs, err := PaymentOutputs([]Payment{{string(first.Address()), 3}, {string(second.Address()), 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 2 || outputs[0].Value != 3 || !outputs[0].IsLockedWithKey(first.PubKeyHash()) || outputs[1].Value != 4 || !outputs[1].IsLockedWithKey(second.PubKeyHash()) {
+		t.Fatal("the outputs do not pay the payments in order")
+	}
+
+	//the same key as a base58 address is still the same payee
+	old := string(wallet.PubKeyHashToBase58Address(first.PubKeyHash()))
+	funds := testCandidates(string(first.Address()), 100)
+	for _, test := range []struct {
+		name     string
+		payments []Payment
+		reason   string
+	}{
+		{"no payments", nil, "no payments"},
+		{"zero amount", []Payment{{string(first.Address()), 0}}, "not positive"},
+		{"negative amount", []Payment{{string(first.Address()), 5}, {string(second.Address()), -1}}, "not positive"},
+		{"overflowing total", []Payment{{string(first.Address()), math.MaxInt64}, {string(second.Address()), 1}}, "too large"},
+		{"invalid address", []Payment{{"gb1notanaddress", 1}}, "not Valid"},
+		{"duplicate address", []Payment{{string(first.Address()), 1}, {string(second.Address()), 2}, {string(first.Address()), 1}}, "more than once"},
+		{"duplicate in another form", []Payment{{string(first.Address()), 1}, {old, 2}}, "more than once"},
+	} {
+		if _, err := PaymentOutputs(test.payments); err == nil || !strings.Contains(err.Error(), test.reason) {
+			t.Fatalf("%s gave %v, expected %q", test.name, err, test.reason)
+		}
+		//a transaction paying them gets the same error back
+		if _, err := NewTransaction(first, "", test.payments, TxOptions{}, funds); err == nil || !strings.Contains(err.Error(), test.reason) {
+			t.Fatalf("a transaction with %s failed with %v, expected %q", test.name, err, test.reason)
+		}
+	}
+}
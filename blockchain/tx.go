@@ -12,6 +12,7 @@ type TxOutput struct {
 	Value      int    //value in tokens which is assigned and locked inside of this output
 	PubkeyHash []byte /* to lock the Value;it is derived using script but we don't have anyhthing
 	like that, so the arbitrary key that would represent it is user's address*/
+	HTLC *HTLC //set instead of PubkeyHash when the output is a hash time locked contract (see htlc.go)
 }
 
 /*identify transaction outputs and then sort them by an unspent outputs with this new structure we create a new serialize and deserialize function
@@ -19,6 +20,15 @@ so that we can take the  actual structure, decode it into bytes and then re-enco
 */
 type TxOutputs struct {
 	Outputs []TxOutput
+	Indexes []int //position of every output in its transaction, spent outputs are removed from the set so the position can not be taken from the order
+}
+
+//position of Outputs[i] in its transaction, sets stored before Indexes existed never had anything removed so there it is just i
+func (outs TxOutputs) Index(i int) int {
+	if outs.Indexes == nil {
+		return i
+	}
+	return outs.Indexes[i]
 }
 
 //TxInput struct -ID,Out,Sig
@@ -27,7 +37,9 @@ type TxInput struct {
 	Out int    //references the index where output appears
 	Sig []byte /*script which provides the data which is used in TxOutput's Pubkey
 	because we donot have the script logic in place the sig is just going to be the user's account*/
-	Pubkey []byte
+	Pubkey   []byte
+	Sequence uint32 //relative lock, the output can only be spent a number of blocks or seconds after it was mined (see locktime.go)
+	Secret   []byte //preimage of the secret hash when redeeming a hash time locked contract
 }
 
 func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
@@ -37,19 +49,25 @@ func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
 
 //locking the transaction output
 func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := wallet.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubkeyHash = pubKeyHash
+	out.PubkeyHash = wallet.AddressToPubKeyHash(string(address))
 }
 
 //checks if the output has been locked with public key hash
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubkeyHash, pubKeyHash) == 0
+	return out.HTLC == nil && bytes.Compare(out.PubkeyHash, pubKeyHash) == 0
+}
+
+//what a signature spending this output commits to, the public key hash for normal outputs and the contract hash for contracts
+func (out *TxOutput) LockingHash() []byte {
+	if out.HTLC != nil {
+		return out.HTLC.Hash()
+	}
+	return out.PubkeyHash
 }
 
 //locking the transaction outputs that we create and also because when we pass in an address from trhe command line its a string, so we need we convert that to a slice of bytes
 func NewTXOutput(value int, address string) *TxOutput {
-	txo := &TxOutput{value, nil}
+	txo := &TxOutput{value, nil, nil}
 	txo.Lock([]byte(address))
 	return txo
 }
@@ -3,14 +3,16 @@ package blockchain
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"log"
 
 	"github.com/dgraph-io/badger"
 )
 
 var (
-	utxoPrefix   = []byte("utxo-")
-	prefixLength = len(utxoPrefix)
+	utxoPrefix    = []byte("utxo-")
+	prefixLength  = len(utxoPrefix)
+	txIndexPrefix = []byte("txblock-") //the transaction index, transaction ID to the hash of the block of our chain holding it
 )
 
 //allows to access the database and then we can create the new layer inside of that database which will have UTXOs
@@ -66,10 +68,32 @@ func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 	})
 }
 
+func indexTransactions(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		if err := txn.Set(append(append([]byte{}, txIndexPrefix...), tx.ID...), block.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 //clear outs th database with all the prefixes attaached to it and then rebuild the set inside ofthe database
+//the transaction index is rebuilt along with it, so it only holds the transactions of our chain
 func (u UTXOSet) Reindex() {
 	db := u.Block_chain.Database
 	u.DeleteByPrefix(utxoPrefix)
+	u.DeleteByPrefix(txIndexPrefix)
+	iter := u.Block_chain.Iterator()
+	for {
+		block := iter.Next()
+		err := db.Update(func(txn *badger.Txn) error {
+			return indexTransactions(txn, block)
+		})
+		Handle(err)
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
 	UTXO := u.Block_chain.FindUTXO()
 	err := db.Update(func(txn *badger.Txn) error {
 		for txId, outs := range UTXO {
@@ -113,10 +137,10 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amt int) (int, map[stri
 			txID := hex.EncodeToString(k)
 			outs := DeserializeOutputs(v)
 
-			for outIdx, out := range outs.Outputs {
+			for i, out := range outs.Outputs {
 				if out.IsLockedWithKey(pubKeyHash) && accumulated < amt {
 					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
+					unspentOuts[txID] = append(unspentOuts[txID], outs.Index(i))
 				}
 			}
 		}
@@ -126,6 +150,123 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amt int) (int, map[stri
 	return accumulated, unspentOuts
 }
 
+//every unspent output locked to pubKeyHash, in database order
+func (u UTXOSet) ListSpendableOutputs(pubKeyHash []byte) []SpendableOutput {
+	var spendable []SpendableOutput
+	db := u.Block_chain.Database
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			item := it.Item()
+			txID := bytes.TrimPrefix(item.KeyCopy(nil), utxoPrefix)
+			var outs TxOutputs
+			err1 := item.Value(func(val []byte) error {
+				outs = DeserializeOutputs(val)
+				return nil
+			})
+			Handle(err1)
+
+			for i, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					spendable = append(spendable, SpendableOutput{txID, outs.Index(i), out})
+				}
+			}
+		}
+		return nil
+	})
+	Handle(err)
+	return spendable
+}
+
+//same as FindSpendableOutputs but the selector decides which outputs are spent, feePerInput is what spending one of them costs
+func (u UTXOSet) SelectSpendableOutputs(pubKeyHash []byte, amt, feePerInput int, selector CoinSelector) (int, map[string][]int, error) {
+	return selectOutputs(u, pubKeyHash, amt, feePerInput, selector)
+}
+
+func selectOutputs(set OutputSet, pubKeyHash []byte, amt, feePerInput int, selector CoinSelector) (int, map[string][]int, error) {
+	unspentOuts := make(map[string][]int)
+	accumulated := 0
+	selected, err := selector.Select(set.ListSpendableOutputs(pubKeyHash), amt, feePerInput)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, spendable := range selected {
+		txID := hex.EncodeToString(spendable.TxID)
+		accumulated += spendable.Output.Value
+		unspentOuts[txID] = append(unspentOuts[txID], spendable.Out)
+	}
+	return accumulated, unspentOuts, nil
+}
+
+//the unspent output out of transaction txID, false when it does not exist or has been spent
+func (u UTXOSet) FindOutput(txID []byte, out int) (TxOutput, bool) {
+	var outs TxOutputs
+	err := u.Block_chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append(append([]byte{}, utxoPrefix...), txID...))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			outs = DeserializeOutputs(val)
+			return nil
+		})
+	})
+	if err != nil {
+		return TxOutput{}, false
+	}
+	for i, output := range outs.Outputs {
+		if outs.Index(i) == out {
+			return output, true
+		}
+	}
+	return TxOutput{}, false
+}
+
+//the outputs a new transaction can be funded from, the UTXO set or the UTXO set together with the mempool
+type OutputSet interface {
+	ListSpendableOutputs(pubKeyHash []byte) []SpendableOutput
+	FindOutput(txID []byte, out int) (TxOutput, bool)
+}
+
+//what PendingSet needs to know about the transactions waiting for a block, the mempool implements it
+type PendingPool interface {
+	Spender(txID []byte, out int) ([]byte, bool)
+	PendingOutputs(pubKeyHash []byte) []SpendableOutput
+	PendingOutput(txID []byte, out int) (TxOutput, bool)
+}
+
+/*
+the UTXO set as it will be once the pending transactions are mined
+outputs a pending transaction spends are left out and the outputs of pending transactions are added, so a new transaction can spend change that is not confirmed yet
+*/
+type PendingSet struct {
+	UTXO UTXOSet
+	Pool PendingPool
+}
+
+func (p PendingSet) ListSpendableOutputs(pubKeyHash []byte) []SpendableOutput {
+	var spendable []SpendableOutput
+	for _, candidate := range append(p.UTXO.ListSpendableOutputs(pubKeyHash), p.Pool.PendingOutputs(pubKeyHash)...) {
+		if _, spent := p.Pool.Spender(candidate.TxID, candidate.Out); !spent {
+			spendable = append(spendable, candidate)
+		}
+	}
+	return spendable
+}
+
+func (p PendingSet) FindOutput(txID []byte, out int) (TxOutput, bool) {
+	if _, spent := p.Pool.Spender(txID, out); spent {
+		return TxOutput{}, false
+	}
+	if output, ok := p.UTXO.FindOutput(txID, out); ok {
+		return output, true
+	}
+	return p.Pool.PendingOutput(txID, out)
+}
+
 //goes through persistence layer and find the balance for a user based on their public key hash
 // so it goes through and find all the outputs attached to that user, passes them back which we can use to find how many tokens are assigned that user
 func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
@@ -177,54 +318,155 @@ func (u UTXOSet) CountTransactions() int {
 	return counter
 }
 
-//updatex the UTXOset inside of our persistence layer
+//updatex the UTXOset inside of our persistence layer, and adds the transactions of block to the transaction index
 func (u *UTXOSet) Update(block *Block) {
-	var v []byte
-	db := u.Block_chain.Database
-	err := db.Update(func(txn *badger.Txn) error {
+	err := u.Block_chain.Database.Update(func(txn *badger.Txn) error {
+		return connectOutputs(txn, block)
+	})
+	Handle(err)
+}
+
+/*
+moves the UTXO set and the transaction index from the old last block to the new one after a reorganization
+disconnected and connected are the branches chain.Branches gives, the old branch is undone from its top and the new one applied from the fork point
+it is all one database transaction, on an error nothing is written and the set still belongs to the old last block
+*/
+func (u *UTXOSet) Reorganize(disconnected, connected []*Block) error {
+	//the outputs the undone blocks spent, looked up while the transaction index still holds the old branch
+	restored := make(map[string]TxOutput)
+	for _, block := range disconnected {
 		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					Handle(err)
-					err1 := item.Value(func(val []byte) error {
-						v = append([]byte{}, val...)
-						return nil
-					})
-					Handle(err1)
-					outs := DeserializeOutputs(v)
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
-
-					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
-					}
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, in := range tx.Inputs {
+				prevTX, err := u.Block_chain.FindTransaction(in.ID)
+				if err != nil || in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+					return fmt.Errorf("Output %s spent by block %x is not on our chain", hex.EncodeToString(in.ID), block.Hash)
 				}
+				restored[viewOutpoint(in.ID, in.Out)] = prevTX.Outputs[in.Out]
 			}
-
-			newOutputs := TxOutputs{}
-			for _, out := range tx.Outputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+		}
+	}
+	return u.Block_chain.Database.Update(func(txn *badger.Txn) error {
+		for _, block := range disconnected {
+			if err := disconnectOutputs(txn, block, restored); err != nil {
+				return err
 			}
-
-			txID := append(utxoPrefix, tx.ID...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
+		}
+		for _, block := range connected {
+			if err := connectOutputs(txn, block); err != nil {
+				return err
 			}
 		}
+		return nil
+	})
+}
 
+//the unspent outputs of transaction txID, none when they are all spent
+func unspentOutputs(txn *badger.Txn, txID []byte) (TxOutputs, error) {
+	item, err := txn.Get(append(append([]byte{}, utxoPrefix...), txID...))
+	if err == badger.ErrKeyNotFound {
+		return TxOutputs{}, nil
+	}
+	if err != nil {
+		return TxOutputs{}, err
+	}
+	var outs TxOutputs
+	err = item.Value(func(val []byte) error {
+		outs = DeserializeOutputs(val)
 		return nil
 	})
-	Handle(err)
+	return outs, err
+}
+
+//stores the unspent outputs of transaction txID, the entry goes away with the last of them
+func putOutputs(txn *badger.Txn, txID []byte, outs TxOutputs) error {
+	key := append(append([]byte{}, utxoPrefix...), txID...)
+	if len(outs.Outputs) == 0 {
+		return txn.Delete(key)
+	}
+	return txn.Set(key, outs.Serialize())
+}
+
+//spends the outputs the transactions of block spend and adds the ones they create
+func connectOutputs(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				outs, err := unspentOutputs(txn, in.ID)
+				if err != nil {
+					return err
+				}
+				updatedOuts := TxOutputs{}
+				for i, out := range outs.Outputs {
+					if outs.Index(i) != in.Out {
+						updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+						updatedOuts.Indexes = append(updatedOuts.Indexes, outs.Index(i))
+					}
+				}
+				if len(updatedOuts.Outputs) == len(outs.Outputs) {
+					return fmt.Errorf("Output %s:%d spent by block %x is not unspent", hex.EncodeToString(in.ID), in.Out, block.Hash)
+				}
+				if err := putOutputs(txn, in.ID, updatedOuts); err != nil {
+					return err
+				}
+			}
+		}
+
+		newOutputs := TxOutputs{}
+		for outIdx, out := range tx.Outputs {
+			newOutputs.Outputs = append(newOutputs.Outputs, out)
+			newOutputs.Indexes = append(newOutputs.Indexes, outIdx)
+		}
+		if err := putOutputs(txn, tx.ID, newOutputs); err != nil {
+			return err
+		}
+	}
+	return indexTransactions(txn, block)
+}
+
+//undoes block, the outputs of its transactions go away and the ones they spent, found in restored, come back
+func disconnectOutputs(txn *badger.Txn, block *Block, restored map[string]TxOutput) error {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		if err := putOutputs(txn, tx.ID, TxOutputs{}); err != nil {
+			return err
+		}
+		if err := txn.Delete(append(append([]byte{}, txIndexPrefix...), tx.ID...)); err != nil {
+			return err
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			outs, err := unspentOutputs(txn, in.ID)
+			if err != nil {
+				return err
+			}
+			//put back at its place, the outputs of a transaction are kept in the order they have in it
+			updatedOuts := TxOutputs{}
+			placed := false
+			for j, out := range outs.Outputs {
+				if outs.Index(j) == in.Out {
+					return fmt.Errorf("Output %s:%d spent by block %x is unspent", hex.EncodeToString(in.ID), in.Out, block.Hash)
+				}
+				if !placed && outs.Index(j) > in.Out {
+					updatedOuts.Outputs = append(updatedOuts.Outputs, restored[viewOutpoint(in.ID, in.Out)])
+					updatedOuts.Indexes = append(updatedOuts.Indexes, in.Out)
+					placed = true
+				}
+				updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+				updatedOuts.Indexes = append(updatedOuts.Indexes, outs.Index(j))
+			}
+			if !placed {
+				updatedOuts.Outputs = append(updatedOuts.Outputs, restored[viewOutpoint(in.ID, in.Out)])
+				updatedOuts.Indexes = append(updatedOuts.Indexes, in.Out)
+			}
+			if err := putOutputs(txn, in.ID, updatedOuts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
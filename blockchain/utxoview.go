@@ -0,0 +1,151 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+/*
+the unspent outputs as they were right after some block, used to check the transactions of a block on top of it
+the UTXO set in the database belongs to our last block, for a block on another branch the blocks of our chain down to the fork point are undone and the blocks of the other branch applied
+all of that happens in memory, nothing is written
+*/
+type utxoView struct {
+	chain *Blockchain
+	added map[string]TxOutput //outputs created on top of the UTXO set, by outpoint
+	spent map[string]bool     //outputs of the UTXO set that are spent
+	mined map[string]*Block   //block of the transactions of the blocks applied, nil for those of the blocks undone
+}
+
+func viewOutpoint(txID []byte, out int) string {
+	return fmt.Sprintf("%x:%d", txID, out)
+}
+
+//the unspent outputs right after the block with hash
+func (chain *Blockchain) viewAt(hash []byte) (*utxoView, error) {
+	view := &utxoView{chain, make(map[string]TxOutput), make(map[string]bool), make(map[string]*Block)}
+	if bytes.Equal(hash, chain.LastHash) {
+		return view, nil
+	}
+	disconnected, connected, err := chain.Branches(chain.LastHash, hash)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range disconnected {
+		if err := view.disconnect(block); err != nil {
+			return nil, err
+		}
+	}
+	for _, block := range connected {
+		view.connect(block)
+	}
+	return view, nil
+}
+
+/*
+the blocks between the fork point of the branches ending in from and to
+disconnected goes from from down to the fork point, connected from the fork point up to to
+after a reorganization from is the old last block and to the new one
+*/
+func (chain *Blockchain) Branches(from, to []byte) ([]*Block, []*Block, error) {
+	a, err := chain.GetBlock(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := chain.GetBlock(to)
+	if err != nil {
+		return nil, nil, err
+	}
+	var disconnected, connected []*Block
+	for !bytes.Equal(a.Hash, b.Hash) {
+		if a.Height >= b.Height {
+			block := a
+			disconnected = append(disconnected, &block)
+			if a, err = chain.GetBlock(a.PrevHash); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			block := b
+			connected = append([]*Block{&block}, connected...)
+			if b, err = chain.GetBlock(b.PrevHash); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return disconnected, connected, nil
+}
+
+//the unspent output out of transaction txID, false when it does not exist or has been spent
+func (view *utxoView) FindOutput(txID []byte, out int) (TxOutput, bool) {
+	point := viewOutpoint(txID, out)
+	if output, ok := view.added[point]; ok {
+		return output, true
+	}
+	if view.spent[point] {
+		return TxOutput{}, false
+	}
+	return UTXOSet{view.chain}.FindOutput(txID, out)
+}
+
+func (view *utxoView) spend(txID []byte, out int) {
+	point := viewOutpoint(txID, out)
+	delete(view.added, point)
+	view.spent[point] = true
+}
+
+func (view *utxoView) addOutputs(tx *Transaction) {
+	for outIdx, out := range tx.Outputs {
+		point := viewOutpoint(tx.ID, outIdx)
+		delete(view.spent, point)
+		view.added[point] = out
+	}
+}
+
+//the block on the view's branch that holds the transaction
+func (view *utxoView) minedIn(txID []byte) (*Block, error) {
+	if block, ok := view.mined[hex.EncodeToString(txID)]; ok {
+		if block == nil {
+			return nil, errors.New("Transaction does not exist")
+		}
+		return block, nil
+	}
+	return view.chain.FindTransactionBlock(txID)
+}
+
+func (view *utxoView) connect(block *Block) {
+	for _, tx := range block.Transactions {
+		view.mined[hex.EncodeToString(tx.ID)] = block
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				view.spend(in.ID, in.Out)
+			}
+		}
+		view.addOutputs(tx)
+	}
+}
+
+//undoes block, its outputs go away and the outputs it spent come back
+func (view *utxoView) disconnect(block *Block) error {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		view.mined[hex.EncodeToString(tx.ID)] = nil
+		for outIdx := range tx.Outputs {
+			view.spend(tx.ID, outIdx)
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			prevTX, err := view.chain.FindTransaction(in.ID)
+			if err != nil || in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+				return fmt.Errorf("Output %s spent by block %x is not on our chain", hex.EncodeToString(in.ID), block.Hash)
+			}
+			point := viewOutpoint(in.ID, in.Out)
+			delete(view.spent, point)
+			view.added[point] = prevTX.Outputs[in.Out]
+		}
+	}
+	return nil
+}
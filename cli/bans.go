@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/banlist"
+)
+
+//the ban list of the node with nodeID, a node that is running picks up the changes made to it within a few seconds
+func loadBanList(nodeID string) *banlist.BanList {
+	bans, err := banlist.Load(banlist.File(nodeID))
+	if err != nil {
+		log.Panic(err)
+	}
+	return bans
+}
+
+func (cli *CommandLine) listBanned(nodeID string) {
+	bans := loadBanList(nodeID).List()
+	if len(bans) == 0 {
+		fmt.Println("No peers are banned")
+		return
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "PEER\tUNTIL\tREASON")
+	for _, ban := range bans {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", ban.Addr, ban.Until.Format("2006-01-02 15:04:05"), ban.Reason)
+	}
+	table.Flush()
+}
+
+/*
+bans are by IP, a peer given by its host name has every IP of that host banned or lifted
+a node bans a misbehaving peer by its address, lifting the ban of peer lifts that one when there is one
+*/
+func (cli *CommandLine) setBan(nodeID, peer string, seconds int64, remove bool) {
+	bans := loadBanList(nodeID)
+	if remove {
+		if removed, err := bans.Unban(peer); err != nil {
+			log.Panic(err)
+		} else if removed {
+			fmt.Printf("Lifted the ban of %s\n", peer)
+			return
+		}
+	}
+	ips, err := banlist.PeerIPs(peer)
+	if err != nil {
+		log.Panic(err)
+	}
+	if remove {
+		for _, ip := range ips {
+			removed, err := bans.Unban(ip)
+			if err != nil {
+				log.Panic(err)
+			}
+			if !removed {
+				fmt.Printf("%s is not banned\n", ip)
+				continue
+			}
+			fmt.Printf("Lifted the ban of %s\n", ip)
+		}
+		return
+	}
+	duration := time.Duration(seconds) * time.Second
+	for _, ip := range ips {
+		if err := bans.Ban(ip, duration, "set by the administrator"); err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Banned %s until %s\n", ip, time.Now().Add(duration).Format("2006-01-02 15:04:05"))
+	}
+}
+
+func (cli *CommandLine) clearBanned(nodeID string) {
+	if err := loadBanList(nodeID).Clear(); err != nil {
+		log.Panic(err)
+	}
+	fmt.Println("Lifted every ban")
+}
@@ -1,14 +1,22 @@
 package cli
 
 import (
+	"bytes"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/RavjotSandhu/GoBlockchain/banlist"
 	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+	"github.com/RavjotSandhu/GoBlockchain/mempool"
+	"github.com/RavjotSandhu/GoBlockchain/network"
 	"github.com/RavjotSandhu/GoBlockchain/wallet"
 )
 
@@ -16,13 +24,64 @@ type CommandLine struct{}
 
 func (cli *CommandLine) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
+	fmt.Println(" getbalance [-address ADDRESS] - get the balance for an address, or of every address in the wallet without -address")
 	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
 	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT - Send amount of coins")
-	fmt.Println(" createwallet - Creates a new Wallet")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT [-locktime LOCKTIME] [-signer SIGNER] - Send amount of coins")
+	fmt.Println(" send -from FROM -to TO:AMOUNT [-to TO:AMOUNT...] [-file PAYOUTS] [-locktime LOCKTIME] - Pay several addresses in one transaction")
+	fmt.Println("   send and createrawtx take -coinselect keyorder|largest|smallest|bnb|random to pick which outputs are spent")
+	fmt.Println("   and -feerate N to pay a fee of N per 1000 bytes, the outputs are picked by what they are worth after the fee for spending them")
+	fmt.Println("   change goes to a new address of the wallet, createrawtx takes -change ADDRESS to pick it and both take -dust N to leave smaller change as fee")
+	fmt.Println("   the change address is printed, spend its coins with -from that address")
+	fmt.Println("   both take -rbf to signal that the transaction can be replaced by one paying a higher fee, send only with -node as it mines right away otherwise")
+	fmt.Println("   and -node NODE_ID to spend outputs of the transactions in the mempool of the stopped node as well")
+	fmt.Println("   send -node puts the transaction into that mempool instead of mining it, the node relays it to its peers once it runs")
+	fmt.Println("   PAYOUTS is a csv file of address,amount lines or a .json file of {\"address\", \"amount\"} objects")
+	fmt.Println(" createwallet [-account N] [-scheme ecdsa|ed25519|schnorr] - Creates a new Wallet, derived from the seed when the wallet has one and the scheme is ecdsa")
 	fmt.Println(" listaddresses - Lists the addresses in our wallet file")
-	fmt.Println(" reindexutxo - Rebuilds the UTXO set")
+	fmt.Println(" listtransactions [-address ADDRESS] [-count N] [-json] - Lists the transactions that paid to or spent from the wallet")
+	fmt.Println(" setlabel -address ADDRESS | -tx TXID -label LABEL - Attaches a label to an address or transaction, an empty label removes it")
+	fmt.Println(" createhdwallet [-words N] - Gives the wallet a new seed and prints its mnemonic")
+	fmt.Println(" restorewallet -mnemonic WORDS [-accounts N] [-gap N] - Restores the seed and finds the addresses used on the chain")
+	fmt.Println(" exportkey -address ADDRESS [-format wif|pem] [-out FILE] - Prints or writes the private key of an address")
+	fmt.Println(" importkey -key KEY | -file FILE [-rescan=false] - Adds an exported private key to the wallet and rescans the chain for it")
+	fmt.Println(" importaddress -address ADDRESS | -pubkey HEX [-rescan=false] - Watches an address without its private key")
+	fmt.Println(" signmessage -address ADDRESS -message MESSAGE - Signs a message with the key of an address to prove it is ours")
+	fmt.Println(" verifymessage -address ADDRESS -signature SIGNATURE -message MESSAGE - Checks that a message was signed by the key of an address")
+	fmt.Println(" encryptwallet - Encrypts the private keys in our wallet file with a passphrase")
+	fmt.Println(" changepassphrase - Changes the passphrase of an encrypted wallet file")
+	fmt.Println("   the passphrase is read from WALLET_PASSPHRASE (and WALLET_NEW_PASSPHRASE for a new one) or asked for on the terminal")
+	fmt.Println(" reindexutxo - Rebuilds the UTXO set and the transaction index")
+	fmt.Println("   addresses are bech32 with the prefix of the network picked by BLOCKCHAIN_NETWORK: mainnet (gb, the default), testnet (tgb) or regtest (gbrt)")
+	fmt.Println("   the older base58 addresses are accepted as well")
+	fmt.Println(" initiate -from FROM -to TO -amount AMOUNT [-timeout SECONDS] [-feerate N] - Starts an atomic swap by locking coins in a new contract")
+	fmt.Println(" participate -from FROM -to TO -amount AMOUNT -secrethash HASH [-timeout SECONDS] [-feerate N] - Locks coins in a contract for the other side of a swap")
+	fmt.Println(" redeem -address ADDRESS -contract TXID:OUT -secret SECRET - Claims the coins of a contract with its secret")
+	fmt.Println(" refund -address ADDRESS -contract TXID:OUT - Takes the coins of a contract back once it has timed out")
+	fmt.Println(" auditcontract -contract TXID:OUT - Prints the terms of a contract and the secret once it has been redeemed")
+	fmt.Println("   each side of a swap runs its own chain, use a separate working directory for each of them")
+	fmt.Println("   initiate and participate take -node NODE_ID like send to fund the contract from the mempool of the node and put it there")
+	fmt.Println("   LOCKTIME is a block height, or a unix time when it is 500000000 or more, before which the transaction can not be mined")
+	fmt.Println(" createrawtx -from FROM -to TO:AMOUNT [-to TO:AMOUNT...] [-file PAYOUTS] -out FILE [-locktime LOCKTIME] - Writes an unsigned transaction to FILE")
+	fmt.Println(" signrawtx -in FILE [-out FILE] [-signer SIGNER [-address ADDRESS]] - Signs the inputs of a raw transaction that belong to our wallet, or to the signer's key")
+	fmt.Println(" combinetx -in FILE,FILE... -out FILE - Merges the signatures of several copies of a raw transaction")
+	fmt.Println(" sendrawtx -in FILE -miner ADDRESS | -node NODE_ID | -relay HOST:PORT - Finalizes a fully signed raw transaction and mines it, rewarding ADDRESS")
+	fmt.Println("   or puts it into the mempool of the stopped node NODE_ID, or sends it to the running node at HOST:PORT which relays it to its peers")
+	fmt.Println(" bumpfee -txid TXID -in FILE | -node NODE_ID -fee FEE [-out FILE] [-change ADDRESS] [-dust N] [-signer SIGNER [-address ADDRESS]] - Rebuilds the transaction TXID paying FEE more from its change and signs it again")
+	fmt.Println("   the transaction is read from the raw transaction FILE or found in the mempool of the stopped node, with -node the replacement takes its place there")
+	fmt.Println(" signerd -socket PATH [-allow ADDRESS,ADDRESS...] [-destinations ADDRESS,ADDRESS...] [-maxamount N] [-maxspent N] [-maxrate N] [-confirm] - Runs a signer daemon for the keys of the wallet file")
+	fmt.Println("   PATH has to be in a directory only its owner can enter, a missing one is created, the daemon signs whole transactions and checks where they pay")
+	fmt.Println("   SIGNER is unix:PATH for a signer daemon or file:KEYFILE for a key written by exportkey, send takes -signer as well")
+	fmt.Println(" startnode -port PORT [-miner ADDRESS] [-seeds HOST:PORT,HOST:PORT...] - Runs a node on PORT with the chain of the working directory, mining to ADDRESS when it is set")
+	fmt.Println("   the node connects to the seeds first, localhost:3000 by default, .tmp/seeds_PORT.txt replaces them when it exists")
+	fmt.Println(" listbanned -node NODE_ID - Lists the peers the node with NODE_ID refuses to talk to")
+	fmt.Println(" setban -node NODE_ID -peer IP|HOST[:PORT] [-time SECONDS] [-remove] - Bans the IP of a peer of the node, or lifts its ban with -remove")
+	fmt.Println(" clearbanned -node NODE_ID - Lifts every ban of the node")
+	fmt.Println("   NODE_ID is the port the node listens on, a running node picks up the changes within a few seconds")
+	fmt.Println("   peers are banned by IP, banning a host bans every node on it")
+	fmt.Println(" getmempoolinfo -node NODE_ID - Prints the size and fees of the mempool of the node")
+	fmt.Println(" getrawmempool -node NODE_ID [-verbose] - Lists the transactions in the mempool of the node, the best paying first")
+	fmt.Println("   both read the mempool file the node writes when it shuts down, and need the node to be stopped like every command using the chain")
 }
 
 //it will allow us to validate any argument that we pass through command line
@@ -58,60 +117,495 @@ func (cli *CommandLine) printChain() {
 
 //this method allows to create blockchain
 func (cli *CommandLine) createBlockchain(address string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not Valid")
-	}
+	validateAddress(address)
 	chain := blockchain.InitBlockchain(address)
 	defer chain.Database.Close()
+	UTXOSet := blockchain.UTXOSet{chain}
+	UTXOSet.Reindex()
 	fmt.Println("Finished!")
 }
 
 func (cli *CommandLine) getBalance(address string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not Valid")
-	}
+	validateAddress(address)
 	chain := blockchain.ContinueBlockChain(address)
 	UTXOSet := blockchain.UTXOSet{chain}
 	defer chain.Database.Close()
 	bal := 0
-	pubKeyHash := wallet.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
+	UTXOs := UTXOSet.FindUTXO(wallet.AddressToPubKeyHash(address))
 	for _, out := range UTXOs {
 		bal += out.Value
 	}
 	fmt.Printf("Balance of %s: %d\n", address, bal)
 }
 
-func (cli *CommandLine) send(from, to string, amt int) {
-	if !wallet.ValidateAddress(to) {
-		log.Panic("Address is not Valid")
+//balances of every address in the wallet, watch-only ones are counted separately since they can not be spent from here
+func (cli *CommandLine) getWalletBalance() {
+	wallets := loadWallets(false)
+	chain := blockchain.ContinueBlockChain("")
+	UTXOSet := blockchain.UTXOSet{chain}
+	defer chain.Database.Close()
+	total, watched := 0, 0
+	for _, address := range wallets.GetAllAddresses() {
+		w := wallets.Wallets[address]
+		bal := 0
+		for _, out := range UTXOSet.FindUTXO(w.PubKeyHash()) {
+			bal += out.Value
+		}
+		if w.WatchOnly {
+			fmt.Printf("Balance of %s: %d (watch-only)\n", address, bal)
+			watched += bal
+			continue
+		}
+		fmt.Printf("Balance of %s: %d\n", address, bal)
+		total += bal
 	}
-	if !wallet.ValidateAddress(from) {
-		log.Panic("Address is not Valid")
+	fmt.Printf("Total: %d\n", total)
+	if watched != 0 {
+		fmt.Printf("Watch-only total: %d\n", watched)
+	}
+}
+
+//with a node the transaction can spend what is in the pool of the node and goes into that pool instead of being mined
+func (cli *CommandLine) send(from string, payments []blockchain.Payment, options blockchain.TxOptions, signerSpec, nodeID string) {
+	validateAddress(from)
+	signer, wallets := loadSigner(signerSpec, from)
+	//the signer daemon only takes its own keys for change, a new one from our wallet file would be a payment out of its wallet
+	change := from
+	if !strings.HasPrefix(signerSpec, "unix:") {
+		change = changeAddress(wallets, from)
 	}
 	chain := blockchain.ContinueBlockChain(from)
 	UTXOSet := blockchain.UTXOSet{chain}
 	defer chain.Database.Close()
-	tx := blockchain.NewTransaction(from, to, amt, &UTXOSet)
+	outputs, pool := fundingSet(chain, nodeID)
+	tx, err := blockchain.NewTransaction(signer, change, payments, options, outputs)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !tx.IsFinal(chain.GetBestHeight()+1, chain.MedianTimePast(chain.LastHash)) {
+		fmt.Printf("Transaction is locked until %d, use createrawtx to hold on to it and sendrawtx once it can be mined\n", options.LockTime)
+		runtime.Goexit()
+	}
+	if pool != nil {
+		queueTx(pool, nodeID, tx)
+		printChange(tx, from, change)
+		return
+	}
 	cbTx := blockchain.CoinbaseTx(from, "")
-	block := chain.AddBlock([]*blockchain.Transaction{cbTx, tx})
+	block := chain.MineBlock([]*blockchain.Transaction{cbTx, tx})
 	UTXOSet.Update(block)
 	fmt.Println("Success!")
+	printChange(tx, from, change)
+}
+
+//says which address the change of tx went to, coins on a new change address are only spent by a send from that address
+func printChange(tx *blockchain.Transaction, from, change string) {
+	if normalizeAddress(change) == normalizeAddress(from) {
+		return
+	}
+	pubKeyHash := wallet.AddressToPubKeyHash(change)
+	for _, out := range tx.Outputs {
+		if out.IsLockedWithKey(pubKeyHash) {
+			fmt.Printf("Change of %d goes to %s, spend it with -from %s\n", out.Value, change, change)
+			return
+		}
+	}
 }
 
-func (cli *CommandLine) createWallet() {
-	wallets, _ := wallet.CreateWallets()
-	address := wallets.AddWallet()
-	wallets.SaveFile()
+//raw transactions are stored hex encoded so they can be copied to and from an offline machine as text
+func writePartialTx(path string, ptx *blockchain.PartialTransaction) {
+	err := ioutil.WriteFile(path, []byte(hex.EncodeToString(ptx.Serialize())), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func readPartialTx(path string) *blockchain.PartialTransaction {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	data, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		log.Panic(err)
+	}
+	ptx, err := blockchain.DeserializePartialTransaction(data)
+	if err != nil {
+		log.Panic(err)
+	}
+	return ptx
+}
+
+//without a change address the change goes to a new one of our wallet when from is ours, and back to from otherwise
+func (cli *CommandLine) createRawTx(from, change string, payments []blockchain.Payment, options blockchain.TxOptions, out, nodeID string) {
+	validateAddress(from)
+	if change == "" {
+		change = changeAddress(loadWallets(false), from)
+	} else {
+		validateAddress(change)
+	}
+	chain := blockchain.ContinueBlockChain(from)
+	defer chain.Database.Close()
+	outputs, _ := fundingSet(chain, nodeID)
+	ptx, err := blockchain.NewPartialTransaction(from, change, payments, options, outputs)
+	if err != nil {
+		log.Panic(err)
+	}
+	writePartialTx(out, ptx)
+	fmt.Printf("Unsigned transaction with %d inputs written to %s\n", len(ptx.Tx.Inputs), out)
+	printChange(&ptx.Tx, from, change)
+}
+
+//only needs the wallet file, so it can run on a machine without the blockchain, with a signer only the inputs of its key are signed
+func (cli *CommandLine) signRawTx(in, out, signerSpec, address string) {
+	ptx := readPartialTx(in)
+	signed := signPartialTx(ptx, signerSpec, address)
+	writePartialTx(out, ptx)
+	fmt.Printf("Signed %d inputs, complete: %s\n", signed, strconv.FormatBool(ptx.IsComplete()))
+}
+
+//signs the inputs of ptx the signer or the keys of our wallet file can sign, returns how many
+func signPartialTx(ptx *blockchain.PartialTransaction, signerSpec, address string) int {
+	if signerSpec != "" {
+		signer, _ := loadSigner(signerSpec, address)
+		signed, err := ptx.Sign(signer)
+		if err != nil {
+			log.Panic(err)
+		}
+		return signed
+	}
+	wallets := loadWallets(true)
+	signed := 0
+	for _, address := range wallets.GetAllAddresses() {
+		w := wallets.GetWallet(address)
+		if w.WatchOnly {
+			continue
+		}
+		n, err := ptx.Sign(w)
+		if err != nil {
+			log.Panic(err)
+		}
+		signed += n
+	}
+	return signed
+}
+
+func (cli *CommandLine) combineTx(in []string, out string) {
+	ptx := readPartialTx(in[0])
+	for _, path := range in[1:] {
+		if err := ptx.Combine(readPartialTx(path)); err != nil {
+			log.Panic(err)
+		}
+	}
+	writePartialTx(out, ptx)
+	fmt.Printf("Combined %d transactions, complete: %s\n", len(in), strconv.FormatBool(ptx.IsComplete()))
+}
+
+/*
+hands the transaction to the running node at relay, which checks it and passes it on to its peers
+or puts it into the mempool file of the node with nodeID while it is stopped, or mines it right away rewarding miner
+*/
+func (cli *CommandLine) sendRawTx(in, miner, nodeID, relay string) {
+	ptx := readPartialTx(in)
+	tx, err := ptx.Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+	if relay != "" {
+		if err := network.SendTx(relay, tx); err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Sent transaction %x to %s\n", tx.ID, relay)
+		return
+	}
+	chain := blockchain.ContinueBlockChain("")
+	if nodeID != "" {
+		defer chain.Database.Close()
+		queueTx(loadMempool(chain, nodeID), nodeID, tx)
+		return
+	}
+	validateAddress(miner)
+	UTXOSet := blockchain.UTXOSet{chain}
+	defer chain.Database.Close()
+	cbTx := blockchain.CoinbaseTx(miner, "")
+	block := chain.MineBlock([]*blockchain.Transaction{cbTx, tx})
+	UTXOSet.Update(block)
+	fmt.Printf("Success! Transaction %x\n", tx.ID)
+}
+
+/*
+rebuilds the transaction txID with the same inputs and fee more taken from its change and signs it again
+it is read from the raw transaction in, or taken from the mempool of the node with nodeID together with the outputs it spends
+the change is the output to an internal address of our wallet unless change names the address to take the fee from
+the replacement is written to out when it is set, and with a node it replaces the original in the mempool of the node
+that only works when the original was made with -rbf
+*/
+func (cli *CommandLine) bumpFee(txID, in, out string, fee, dust int, change, signerSpec, address, nodeID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+	chain := blockchain.ContinueBlockChain("")
+	defer chain.Database.Close()
+	if _, err := chain.FindTransaction(id); err == nil {
+		log.Panic("Transaction is already mined and can not be replaced")
+	}
+	var pool *mempool.Mempool
+	if nodeID != "" {
+		pool = loadMempool(chain, nodeID)
+	}
+	var ptx *blockchain.PartialTransaction
+	if in != "" {
+		ptx = readPartialTx(in)
+		tx, err := ptx.Finalize()
+		if err != nil {
+			log.Panic(err)
+		}
+		if !bytes.Equal(tx.ID, id) {
+			log.Panic(fmt.Sprintf("%s holds transaction %x, not %s", in, tx.ID, txID))
+		}
+	} else {
+		entry, ok := pool.Entry(id)
+		if !ok {
+			log.Panic(fmt.Sprintf("Transaction %s is not in the mempool of node %s", txID, nodeID))
+		}
+		ptx = &blockchain.PartialTransaction{*entry.Tx, entry.PrevOuts}
+	}
+
+	if change == "" {
+		wallets := loadWallets(false)
+		for _, a := range wallets.GetAllAddresses() {
+			w := wallets.Wallets[a]
+			for _, output := range ptx.Tx.Outputs {
+				if w.Internal && output.IsLockedWithKey(w.PubKeyHash()) {
+					change = a
+				}
+			}
+		}
+		if change == "" {
+			log.Panic("Transaction has no change output to our wallet, pick the output to take the fee from with -change")
+		}
+	} else {
+		validateAddress(change)
+		change = normalizeAddress(change)
+	}
+	bumped, err := ptx.BumpFee(change, fee, dust)
+	if err != nil {
+		log.Panic(err)
+	}
+	signPartialTx(bumped, signerSpec, address)
+	if !bumped.IsComplete() {
+		if out == "" {
+			log.Panic("Replacement still needs signatures from signrawtx, write it to a file with -out")
+		}
+		writePartialTx(out, bumped)
+		fmt.Printf("Replacement paying a fee of %d instead of %d written to %s, it still needs signatures from signrawtx\n", bumped.Fee(), ptx.Fee(), out)
+		return
+	}
+	replacement, err := bumped.Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Replacement %x pays a fee of %d instead of %d\n", replacement.ID, bumped.Fee(), ptx.Fee())
+	if out != "" {
+		writePartialTx(out, bumped)
+		fmt.Printf("Written to %s, send it with sendrawtx\n", out)
+	}
+	if pool != nil {
+		queueTx(pool, nodeID, replacement)
+	}
+}
+
+//contracts are referenced by the transaction that created them and the index of the contract output
+func parseOutpoint(outpoint string) ([]byte, int) {
+	parts := strings.Split(outpoint, ":")
+	if len(parts) != 2 {
+		log.Panic("Contract must be given as TXID:OUT")
+	}
+	txID, err := hex.DecodeString(parts[0])
+	if err != nil {
+		log.Panic(err)
+	}
+	out, err := strconv.Atoi(parts[1])
+	if err != nil {
+		log.Panic(err)
+	}
+	return txID, out
+}
+
+func (cli *CommandLine) createContract(from, to string, amt int, secretHash []byte, timeout int64, feeRate int, nodeID string) *blockchain.Transaction {
+	validateAddress(to)
+	validateAddress(from)
+	w, wallets := loadWallet(from)
+	change := changeAddress(wallets, from)
+	chain := blockchain.ContinueBlockChain(from)
+	UTXOSet := blockchain.UTXOSet{chain}
+	defer chain.Database.Close()
+	contract := blockchain.HTLC{
+		SecretHash:    secretHash,
+		RecipientHash: wallet.AddressToPubKeyHash(to),
+		RefundHash:    wallet.AddressToPubKeyHash(from),
+		LockTime:      time.Now().Unix() + timeout,
+	}
+	outputs, pool := fundingSet(chain, nodeID)
+	tx, err := blockchain.NewHTLCTransaction(w, change, contract, amt, blockchain.TxOptions{FeeRate: feeRate, DustThreshold: blockchain.DefaultDustThreshold}, outputs)
+	if err != nil {
+		log.Panic(err)
+	}
+	if pool != nil {
+		queueTx(pool, nodeID, tx)
+	} else {
+		cbTx := blockchain.CoinbaseTx(from, "")
+		block := chain.MineBlock([]*blockchain.Transaction{cbTx, tx})
+		UTXOSet.Update(block)
+	}
+	printChange(tx, from, change)
+	fmt.Printf("Contract:    %x:%d\n", tx.ID, tx.ContractOutput())
+	fmt.Printf("Secret hash: %x\n", secretHash)
+	fmt.Printf("Refundable after %s\n", time.Unix(contract.LockTime, 0))
+	return tx
+}
+
+func (cli *CommandLine) initiate(from, to string, amt int, timeout int64, feeRate int, nodeID string) {
+	secret, secretHash := blockchain.NewSecret()
+	cli.createContract(from, to, amt, secretHash, timeout, feeRate, nodeID)
+	fmt.Printf("Secret:      %x\n", secret)
+	fmt.Println("Keep the secret private until the other side has created its contract")
+}
+
+func (cli *CommandLine) participate(from, to string, amt int, secretHash string, timeout int64, feeRate int, nodeID string) {
+	hash, err := hex.DecodeString(secretHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	//a hash of another length can never be matched by a secret, the coins would only come back with the refund
+	if len(hash) != blockchain.SecretLength {
+		log.Panicf("Secret hash is %d bytes, it has to be %d", len(hash), blockchain.SecretLength)
+	}
+	cli.createContract(from, to, amt, hash, timeout, feeRate, nodeID)
+}
+
+//redeems the contract when a secret is given and refunds it otherwise
+func (cli *CommandLine) spendContract(address, contract string, secret []byte) {
+	validateAddress(address)
+	txID, out := parseOutpoint(contract)
+	w, _ := loadWallet(address)
+	chain := blockchain.ContinueBlockChain(address)
+	UTXOSet := blockchain.UTXOSet{chain}
+	defer chain.Database.Close()
+	contractTx, err := chain.FindTransaction(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if out < 0 || out >= len(contractTx.Outputs) {
+		log.Panic("Contract output does not exist")
+	}
+	tx, err := blockchain.NewHTLCSpend(txID, out, contractTx.Outputs[out], w, secret)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !tx.IsFinal(chain.GetBestHeight()+1, chain.MedianTimePast(chain.LastHash)) {
+		fmt.Printf("Contract can not be refunded before %s\n", time.Unix(tx.LockTime, 0))
+		runtime.Goexit()
+	}
+	cbTx := blockchain.CoinbaseTx(address, "")
+	block := chain.MineBlock([]*blockchain.Transaction{cbTx, tx})
+	UTXOSet.Update(block)
+	fmt.Printf("Success! Transaction %x\n", tx.ID)
+}
+
+func (cli *CommandLine) redeem(address, contract, secret string) {
+	s, err := hex.DecodeString(secret)
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.spendContract(address, contract, s)
+}
+
+func (cli *CommandLine) refund(address, contract string) {
+	cli.spendContract(address, contract, nil)
+}
+
+func (cli *CommandLine) auditContract(contract string) {
+	txID, out := parseOutpoint(contract)
+	chain := blockchain.ContinueBlockChain("")
+	defer chain.Database.Close()
+	contractTx, err := chain.FindTransaction(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if out < 0 || out >= len(contractTx.Outputs) || contractTx.Outputs[out].HTLC == nil {
+		log.Panic("Output is not a contract")
+	}
+	output := contractTx.Outputs[out]
+	fmt.Printf("Amount:      %d\n", output.Value)
+	fmt.Printf("Recipient:   %s\n", wallet.PubKeyHashToAddress(output.HTLC.RecipientHash))
+	fmt.Printf("Refund to:   %s\n", wallet.PubKeyHashToAddress(output.HTLC.RefundHash))
+	fmt.Printf("Secret hash: %x\n", output.HTLC.SecretHash)
+	fmt.Printf("Lock time:   %s\n", time.Unix(output.HTLC.LockTime, 0))
+
+	spend, secret, err := chain.FindContractSpend(txID, out)
+	if err != nil {
+		fmt.Println("Status:      unspent")
+		return
+	}
+	if secret != nil {
+		fmt.Printf("Status:      redeemed by %x\n", spend.ID)
+		fmt.Printf("Secret:      %x\n", secret)
+	} else {
+		fmt.Printf("Status:      refunded by %x\n", spend.ID)
+	}
+}
+
+func (cli *CommandLine) createWallet(account int, schemeName string) {
+	scheme, err := wallet.GetScheme(schemeName)
+	if err != nil {
+		log.Panic(err)
+	}
+	if scheme != wallet.SchemeECDSA && account != 0 {
+		log.Panic("Only ECDSA keys can be derived from the seed")
+	}
+	wallets := loadWallets(true)
+	var address string
+	if scheme != wallet.SchemeECDSA {
+		address, err = wallets.AddKey(scheme)
+	} else if account != 0 {
+		address, err = wallets.NewHDAddress(uint32(account), wallet.ReceiveChain)
+	} else {
+		address, err = wallets.AddWallet()
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
 	fmt.Printf("New address is: %s\n", address)
 }
 
+//every address is followed by what we know about it: its path in the key tree, whether it is a change or watch-only address and its label
 func (cli *CommandLine) listAddresses() {
-	wallets, _ := wallet.CreateWallets()
+	wallets := loadWallets(false)
 	addresses := wallets.GetAllAddresses()
 	for _, address := range addresses {
-		fmt.Println(address)
+		w := wallets.Wallets[address]
+		line := address
+		if w.Path != nil {
+			line += " " + w.Path.String()
+		}
+		if w.Scheme != 0 && w.Scheme != wallet.SchemeECDSA {
+			line += " " + wallet.Schemes[w.Scheme].Name()
+		}
+		if w.WatchOnly {
+			line += " watch-only"
+		}
+		if w.Internal {
+			line += " change"
+		}
+		if label := wallets.Label(address); label != "" {
+			line += fmt.Sprintf(" %q", label)
+		}
+		fmt.Println(line)
 	}
 }
 
@@ -124,9 +618,27 @@ func (cli *CommandLine) reindexUTXO() {
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
 
+//runs the node until it is interrupted, the chain stays locked by it meanwhile
+func (cli *CommandLine) startNode(nodeID, minerAddress string, seeds []string) {
+	fmt.Printf("Starting Node %s\n", nodeID)
+	if len(minerAddress) > 0 {
+		validateAddress(minerAddress)
+		fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
+	}
+	if len(seeds) > 0 {
+		network.Seeds = seeds
+	}
+	network.StartServer(nodeID, minerAddress)
+}
+
 //in this run() method for our command line struct just call all other methods.This is the method which we call in the main function to add the command line utility
 func (cli *CommandLine) Run() {
 	cli.validateArgs()
+	if name, ok := os.LookupEnv("BLOCKCHAIN_NETWORK"); ok {
+		if err := wallet.SetNetwork(name); err != nil {
+			log.Panic(err)
+		}
+	}
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
@@ -135,12 +647,145 @@ func (cli *CommandLine) Run() {
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	setLabelCmd := flag.NewFlagSet("setlabel", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	createHDWalletCmd := flag.NewFlagSet("createhdwallet", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
+	exportKeyCmd := flag.NewFlagSet("exportkey", flag.ExitOnError)
+	importKeyCmd := flag.NewFlagSet("importkey", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	signMessageCmd := flag.NewFlagSet("signmessage", flag.ExitOnError)
+	verifyMessageCmd := flag.NewFlagSet("verifymessage", flag.ExitOnError)
+	changePassphraseCmd := flag.NewFlagSet("changepassphrase", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtx", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtx", flag.ExitOnError)
+	combineTxCmd := flag.NewFlagSet("combinetx", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtx", flag.ExitOnError)
+	bumpFeeCmd := flag.NewFlagSet("bumpfee", flag.ExitOnError)
+	signerdCmd := flag.NewFlagSet("signerd", flag.ExitOnError)
+	initiateCmd := flag.NewFlagSet("initiate", flag.ExitOnError)
+	participateCmd := flag.NewFlagSet("participate", flag.ExitOnError)
+	redeemCmd := flag.NewFlagSet("redeem", flag.ExitOnError)
+	refundCmd := flag.NewFlagSet("refund", flag.ExitOnError)
+	auditContractCmd := flag.NewFlagSet("auditcontract", flag.ExitOnError)
+	listBannedCmd := flag.NewFlagSet("listbanned", flag.ExitOnError)
+	setBanCmd := flag.NewFlagSet("setban", flag.ExitOnError)
+	clearBannedCmd := flag.NewFlagSet("clearbanned", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	getMempoolInfoCmd := flag.NewFlagSet("getmempoolinfo", flag.ExitOnError)
+	getRawMempoolCmd := flag.NewFlagSet("getrawmempool", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
-	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	createWalletAccount := createWalletCmd.Int("account", 0, "Account of the seed to derive the address in")
+	createWalletScheme := createWalletCmd.String("scheme", "ecdsa", "Signature scheme of the key: ecdsa, ed25519 or schnorr")
+	createHDWalletWords := createHDWalletCmd.Int("words", 12, "Number of words of the mnemonic: 12, 15, 18, 21 or 24")
+	restoreWalletMnemonic := restoreWalletCmd.String("mnemonic", "", "The words of the mnemonic")
+	restoreWalletAccounts := restoreWalletCmd.Int("accounts", 1, "Number of accounts to look for used addresses in")
+	restoreWalletGap := restoreWalletCmd.Int("gap", 20, "Number of unused addresses in a row after which the search stops")
+	exportKeyAddress := exportKeyCmd.String("address", "", "The address to export the private key of")
+	exportKeyFormat := exportKeyCmd.String("format", "wif", "Format of the key: wif or pem")
+	exportKeyOut := exportKeyCmd.String("out", "", "File to write the key to instead of printing it")
+	importKeyKey := importKeyCmd.String("key", "", "The exported private key")
+	importKeyFile := importKeyCmd.String("file", "", "File with the exported private key")
+	importKeyRescan := importKeyCmd.Bool("rescan", true, "Scan the chain for the key's transactions and print its balance")
+	importAddressAddress := importAddressCmd.String("address", "", "The address to watch")
+	importAddressPubKey := importAddressCmd.String("pubkey", "", "Hex encoded public key to watch instead of an address")
+	importAddressRescan := importAddressCmd.Bool("rescan", true, "Scan the chain for the address's transactions and print its balance")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "Only list the transactions of this address")
+	listTransactionsCount := listTransactionsCmd.Int("count", 0, "Only list the newest N transactions")
+	listTransactionsJSON := listTransactionsCmd.Bool("json", false, "Print the transactions as JSON")
+	setLabelAddress := setLabelCmd.String("address", "", "The address to label")
+	setLabelTx := setLabelCmd.String("tx", "", "The id of the transaction to label")
+	setLabelLabel := setLabelCmd.String("label", "", "The label, leave it empty to remove the label")
+	signMessageAddress := signMessageCmd.String("address", "", "The address whose key signs the message")
+	signMessageMessage := signMessageCmd.String("message", "", "The message to sign")
+	verifyMessageAddress := verifyMessageCmd.String("address", "", "The address that signed the message")
+	verifyMessageSignature := verifyMessageCmd.String("signature", "", "The signature printed by signmessage")
+	verifyMessageMessage := verifyMessageCmd.String("message", "", "The message that was signed")
+	var sendTo paymentList
+	sendCmd.Var(&sendTo, "to", "Destination wallet address, or ADDR:AMOUNT, can be repeated")
+	sendAmount := sendCmd.Int("amount", 0, "Amount to send to a single -to address")
+	sendFile := sendCmd.String("file", "", "CSV or JSON file with the payouts to make")
+	sendLockTime := sendCmd.Int64("locktime", 0, "Block height or unix time before which the transaction can not be mined")
+	sendDust := sendCmd.Int("dust", blockchain.DefaultDustThreshold, "Change below this amount is left to the miner as fee")
+	sendCoinSelect := sendCmd.String("coinselect", "keyorder", "Coin selection strategy: keyorder, largest, smallest, bnb or random")
+	sendFeeRate := sendCmd.Int("feerate", 0, "Fee to pay per 1000 bytes of the transaction")
+	sendSigner := sendCmd.String("signer", "", "Where the key of FROM is: unix:SOCKET or file:KEYFILE, the wallet file by default")
+	sendRBF := sendCmd.Bool("rbf", false, "Signal that the transaction can be replaced by one paying a higher fee")
+	sendNode := sendCmd.String("node", "", "Spend from the mempool of this node as well and put the transaction into it instead of mining it")
+	createRawTxFrom := createRawTxCmd.String("from", "", "Source wallet address")
+	var createRawTxTo paymentList
+	createRawTxCmd.Var(&createRawTxTo, "to", "Destination wallet address, or ADDR:AMOUNT, can be repeated")
+	createRawTxAmount := createRawTxCmd.Int("amount", 0, "Amount to send to a single -to address")
+	createRawTxFile := createRawTxCmd.String("file", "", "CSV or JSON file with the payouts to make")
+	createRawTxOut := createRawTxCmd.String("out", "", "File to write the unsigned transaction to")
+	createRawTxLockTime := createRawTxCmd.Int64("locktime", 0, "Block height or unix time before which the transaction can not be mined")
+	createRawTxChange := createRawTxCmd.String("change", "", "Address to send the change to, a new address of our wallet by default")
+	createRawTxDust := createRawTxCmd.Int("dust", blockchain.DefaultDustThreshold, "Change below this amount is left to the miner as fee")
+	createRawTxCoinSelect := createRawTxCmd.String("coinselect", "keyorder", "Coin selection strategy: keyorder, largest, smallest, bnb or random")
+	createRawTxFeeRate := createRawTxCmd.Int("feerate", 0, "Fee to pay per 1000 bytes of the transaction")
+	createRawTxRBF := createRawTxCmd.Bool("rbf", false, "Signal that the transaction can be replaced by one paying a higher fee")
+	createRawTxNode := createRawTxCmd.String("node", "", "Spend the outputs of the transactions in the mempool of this node as well")
+	signRawTxIn := signRawTxCmd.String("in", "", "File with the raw transaction to sign")
+	signRawTxOut := signRawTxCmd.String("out", "", "File to write the signed transaction to, defaults to the input file")
+	signRawTxSigner := signRawTxCmd.String("signer", "", "Sign with unix:SOCKET or file:KEYFILE instead of the wallet file")
+	signRawTxAddress := signRawTxCmd.String("address", "", "Address the signer daemon signs for")
+	signerdSocket := signerdCmd.String("socket", "", "Path of the unix socket to listen on")
+	signerdAllow := signerdCmd.String("allow", "", "Comma separated addresses to sign for, all of the wallet's by default")
+	signerdDestinations := signerdCmd.String("destinations", "", "Comma separated addresses outside the wallet that may be paid, any by default")
+	signerdMaxAmount := signerdCmd.Int("maxamount", 0, "Most a transaction may pay outside the wallet, 0 for no limit")
+	signerdMaxSpent := signerdCmd.Int("maxspent", 0, "Most the inputs of a transaction may be worth less its change, the fee included, 0 for no limit")
+	signerdMaxRate := signerdCmd.Int("maxrate", 0, "Most transactions signed in a minute, 0 for no limit")
+	signerdConfirm := signerdCmd.Bool("confirm", false, "Show every transaction on the terminal and ask before signing it")
+	combineTxIn := combineTxCmd.String("in", "", "Comma separated files with copies of the same raw transaction")
+	combineTxOut := combineTxCmd.String("out", "", "File to write the combined transaction to")
+	sendRawTxIn := sendRawTxCmd.String("in", "", "File with the fully signed raw transaction")
+	sendRawTxMiner := sendRawTxCmd.String("miner", "", "Mine the transaction and send the block reward to this address")
+	sendRawTxNode := sendRawTxCmd.String("node", "", "Put the transaction into the mempool of this node instead of mining it")
+	sendRawTxRelay := sendRawTxCmd.String("relay", "", "Send the transaction to the running node at HOST:PORT instead of mining it")
+	bumpFeeTxID := bumpFeeCmd.String("txid", "", "ID of the transaction to replace")
+	bumpFeeIn := bumpFeeCmd.String("in", "", "File with the signed raw transaction, it is looked up in the mempool of -node without it")
+	bumpFeeOut := bumpFeeCmd.String("out", "", "File to write the replacement to, defaults to the input file")
+	bumpFeeNode := bumpFeeCmd.String("node", "", "Node whose mempool holds the transaction, the replacement takes its place there")
+	bumpFeeFee := bumpFeeCmd.Int("fee", 0, "How much more fee the replacement pays")
+	bumpFeeChange := bumpFeeCmd.String("change", "", "Address of the output to take the fee from, the change of our wallet by default")
+	bumpFeeDust := bumpFeeCmd.Int("dust", blockchain.DefaultDustThreshold, "Change below this amount after the fee is left to the miner as well")
+	bumpFeeSigner := bumpFeeCmd.String("signer", "", "Sign with unix:SOCKET or file:KEYFILE instead of the wallet file")
+	bumpFeeAddress := bumpFeeCmd.String("address", "", "Address the signer daemon signs for")
+	initiateFrom := initiateCmd.String("from", "", "Source wallet address, also receives the refund")
+	initiateTo := initiateCmd.String("to", "", "Address of the other side of the swap")
+	initiateAmount := initiateCmd.Int("amount", 0, "Amount to lock in the contract")
+	initiateTimeout := initiateCmd.Int64("timeout", 48*60*60, "Seconds until the contract can be refunded")
+	initiateFeeRate := initiateCmd.Int("feerate", 0, "Fee to pay per 1000 bytes of the transaction")
+	initiateNode := initiateCmd.String("node", "", "Spend from the mempool of this node as well and put the contract into it instead of mining it")
+	participateFrom := participateCmd.String("from", "", "Source wallet address, also receives the refund")
+	participateTo := participateCmd.String("to", "", "Address of the initiator of the swap")
+	participateAmount := participateCmd.Int("amount", 0, "Amount to lock in the contract")
+	participateSecretHash := participateCmd.String("secrethash", "", "Secret hash of the initiator's contract")
+	participateTimeout := participateCmd.Int64("timeout", 24*60*60, "Seconds until the contract can be refunded, must be shorter than the initiator's")
+	participateFeeRate := participateCmd.Int("feerate", 0, "Fee to pay per 1000 bytes of the transaction")
+	participateNode := participateCmd.String("node", "", "Spend from the mempool of this node as well and put the contract into it instead of mining it")
+	redeemAddress := redeemCmd.String("address", "", "Recipient address of the contract")
+	redeemContract := redeemCmd.String("contract", "", "Contract to redeem as TXID:OUT")
+	redeemSecret := redeemCmd.String("secret", "", "Secret of the contract")
+	refundAddress := refundCmd.String("address", "", "Refund address of the contract")
+	refundContract := refundCmd.String("contract", "", "Contract to refund as TXID:OUT")
+	auditContractContract := auditContractCmd.String("contract", "", "Contract to audit as TXID:OUT")
+	listBannedNode := listBannedCmd.String("node", "", "ID of the node, the port it listens on")
+	setBanNode := setBanCmd.String("node", "", "ID of the node, the port it listens on")
+	setBanPeer := setBanCmd.String("peer", "", "IP or host of the peer, a port is ignored")
+	setBanTime := setBanCmd.Int64("time", int64(banlist.DefaultBanTime/time.Second), "Seconds the ban lasts")
+	setBanRemove := setBanCmd.Bool("remove", false, "Lift the ban of the peer instead")
+	clearBannedNode := clearBannedCmd.String("node", "", "ID of the node, the port it listens on")
+	startNodePort := startNodeCmd.Int("port", 0, "Port the node listens on, it is the ID of the node as well")
+	startNodeMiner := startNodeCmd.String("miner", "", "Mine the transactions the node receives and send the rewards to this address")
+	startNodeSeeds := startNodeCmd.String("seeds", "", "Comma separated addresses of the nodes to connect to first")
+	getMempoolInfoNode := getMempoolInfoCmd.String("node", "", "ID of the node, the port it listens on")
+	getRawMempoolNode := getRawMempoolCmd.String("node", "", "ID of the node, the port it listens on")
+	getRawMempoolVerbose := getRawMempoolCmd.Bool("verbose", false, "Print the fee, size, age and unconfirmed parents of every transaction")
 
 	//we are going to call it on the first argument of the original call to the program
 	//we can parse all of the arguments which come after the first argument in our argument list then we can handle the error
@@ -170,16 +815,156 @@ func (cli *CommandLine) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "setlabel":
+		err := setLabelCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "createwallet":
 		err := createWalletCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "createhdwallet":
+		err := createHDWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "encryptwallet":
+		err := encryptWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportkey":
+		err := exportKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importkey":
+		err := importKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importaddress":
+		err := importAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmessage":
+		err := signMessageCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifymessage":
+		err := verifyMessageCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "changepassphrase":
+		err := changePassphraseCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "send":
 		err := sendCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "createrawtx":
+		err := createRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signrawtx":
+		err := signRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signerd":
+		err := signerdCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "combinetx":
+		err := combineTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendrawtx":
+		err := sendRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "bumpfee":
+		err := bumpFeeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "initiate":
+		err := initiateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "participate":
+		err := participateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "redeem":
+		err := redeemCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "refund":
+		err := refundCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "auditcontract":
+		err := auditContractCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listbanned":
+		err := listBannedCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "setban":
+		err := setBanCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "clearbanned":
+		err := clearBannedCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "startnode":
+		err := startNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getmempoolinfo":
+		err := getMempoolInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getrawmempool":
+		err := getRawMempoolCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		//when user types in nothing or types somethiong else
 		cli.printUsage()
@@ -188,10 +973,10 @@ func (cli *CommandLine) Run() {
 
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			runtime.Goexit()
+			cli.getWalletBalance()
+		} else {
+			cli.getBalance(*getBalanceAddress)
 		}
-		cli.getBalance(*getBalanceAddress)
 	}
 	//if the parsed flags dont give us an error they give a boolean value which we can check by calling the parsed method on flag
 	if createBlockchainCmd.Parsed() {
@@ -206,19 +991,239 @@ func (cli *CommandLine) Run() {
 		cli.printChain()
 	}
 	if createWalletCmd.Parsed() {
-		cli.createWallet()
+		if *createWalletAccount < 0 {
+			createWalletCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createWallet(*createWalletAccount, *createWalletScheme)
+	}
+	if createHDWalletCmd.Parsed() {
+		cli.createHDWallet(*createHDWalletWords)
+	}
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletMnemonic == "" || *restoreWalletAccounts <= 0 || *restoreWalletGap <= 0 {
+			restoreWalletCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.restoreWallet(*restoreWalletMnemonic, *restoreWalletAccounts, *restoreWalletGap)
 	}
 	if listAddressesCmd.Parsed() {
 		cli.listAddresses()
 	}
+	if listTransactionsCmd.Parsed() {
+		cli.listTransactions(*listTransactionsAddress, *listTransactionsCount, *listTransactionsJSON)
+	}
+	if setLabelCmd.Parsed() {
+		if (*setLabelAddress == "") == (*setLabelTx == "") {
+			setLabelCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.setLabel(*setLabelAddress, *setLabelTx, *setLabelLabel)
+	}
 	if reindexUTXOCmd.Parsed() {
 		cli.reindexUTXO()
 	}
+	if encryptWalletCmd.Parsed() {
+		cli.encryptWallet()
+	}
+	if exportKeyCmd.Parsed() {
+		if *exportKeyAddress == "" {
+			exportKeyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.exportKey(*exportKeyAddress, *exportKeyFormat, *exportKeyOut)
+	}
+	if importKeyCmd.Parsed() {
+		if (*importKeyKey == "") == (*importKeyFile == "") {
+			importKeyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.importKey(*importKeyKey, *importKeyFile, *importKeyRescan)
+	}
+	if importAddressCmd.Parsed() {
+		if (*importAddressAddress == "") == (*importAddressPubKey == "") {
+			importAddressCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.importAddress(*importAddressAddress, *importAddressPubKey, *importAddressRescan)
+	}
+	if signMessageCmd.Parsed() {
+		if *signMessageAddress == "" {
+			signMessageCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.signMessage(*signMessageAddress, *signMessageMessage)
+	}
+	if verifyMessageCmd.Parsed() {
+		if *verifyMessageAddress == "" || *verifyMessageSignature == "" {
+			verifyMessageCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.verifyMessage(*verifyMessageAddress, *verifyMessageSignature, *verifyMessageMessage)
+	}
+	if changePassphraseCmd.Parsed() {
+		cli.changePassphrase()
+	}
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || (len(sendTo) == 0 && *sendFile == "") || *sendFeeRate < 0 {
 			sendCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.send(*sendFrom, *sendTo, *sendAmount)
+		payments, err := collectPayments(sendTo, *sendAmount, *sendFile)
+		if err != nil {
+			log.Panic(err)
+		}
+		selector, err := blockchain.GetCoinSelector(*sendCoinSelect)
+		if err != nil {
+			log.Panic(err)
+		}
+		if *sendRBF && *sendNode == "" {
+			fmt.Println("A transaction mined right away can not be replaced, use -rbf with -node")
+			runtime.Goexit()
+		}
+		cli.send(*sendFrom, payments, blockchain.TxOptions{LockTime: *sendLockTime, Selector: selector, FeeRate: *sendFeeRate, DustThreshold: *sendDust, Replaceable: *sendRBF}, *sendSigner, *sendNode)
+	}
+	if createRawTxCmd.Parsed() {
+		if *createRawTxFrom == "" || (len(createRawTxTo) == 0 && *createRawTxFile == "") || *createRawTxOut == "" || *createRawTxFeeRate < 0 {
+			createRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		payments, err := collectPayments(createRawTxTo, *createRawTxAmount, *createRawTxFile)
+		if err != nil {
+			log.Panic(err)
+		}
+		selector, err := blockchain.GetCoinSelector(*createRawTxCoinSelect)
+		if err != nil {
+			log.Panic(err)
+		}
+		cli.createRawTx(*createRawTxFrom, *createRawTxChange, payments, blockchain.TxOptions{LockTime: *createRawTxLockTime, Selector: selector, FeeRate: *createRawTxFeeRate, DustThreshold: *createRawTxDust, Replaceable: *createRawTxRBF}, *createRawTxOut, *createRawTxNode)
+	}
+	if signRawTxCmd.Parsed() {
+		if *signRawTxIn == "" {
+			signRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		if *signRawTxOut == "" {
+			*signRawTxOut = *signRawTxIn
+		}
+		cli.signRawTx(*signRawTxIn, *signRawTxOut, *signRawTxSigner, *signRawTxAddress)
+	}
+	if signerdCmd.Parsed() {
+		if *signerdSocket == "" || *signerdMaxAmount < 0 || *signerdMaxSpent < 0 || *signerdMaxRate < 0 {
+			signerdCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.signerd(*signerdSocket, *signerdAllow, *signerdDestinations, *signerdMaxAmount, *signerdMaxSpent, *signerdMaxRate, *signerdConfirm)
+	}
+	if combineTxCmd.Parsed() {
+		if *combineTxIn == "" || *combineTxOut == "" {
+			combineTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.combineTx(strings.Split(*combineTxIn, ","), *combineTxOut)
+	}
+	if sendRawTxCmd.Parsed() {
+		modes := 0
+		for _, mode := range []string{*sendRawTxMiner, *sendRawTxNode, *sendRawTxRelay} {
+			if mode != "" {
+				modes++
+			}
+		}
+		if *sendRawTxIn == "" || modes != 1 {
+			sendRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.sendRawTx(*sendRawTxIn, *sendRawTxMiner, *sendRawTxNode, *sendRawTxRelay)
+	}
+	if bumpFeeCmd.Parsed() {
+		if *bumpFeeTxID == "" || (*bumpFeeIn == "" && *bumpFeeNode == "") || *bumpFeeFee <= 0 || *bumpFeeDust < 0 {
+			bumpFeeCmd.Usage()
+			runtime.Goexit()
+		}
+		if *bumpFeeOut == "" {
+			*bumpFeeOut = *bumpFeeIn
+		}
+		cli.bumpFee(*bumpFeeTxID, *bumpFeeIn, *bumpFeeOut, *bumpFeeFee, *bumpFeeDust, *bumpFeeChange, *bumpFeeSigner, *bumpFeeAddress, *bumpFeeNode)
+	}
+	if initiateCmd.Parsed() {
+		if *initiateFrom == "" || *initiateTo == "" || *initiateAmount <= 0 || *initiateTimeout <= 0 || *initiateFeeRate < 0 {
+			initiateCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.initiate(*initiateFrom, *initiateTo, *initiateAmount, *initiateTimeout, *initiateFeeRate, *initiateNode)
+	}
+	if participateCmd.Parsed() {
+		if *participateFrom == "" || *participateTo == "" || *participateAmount <= 0 || *participateSecretHash == "" || *participateTimeout <= 0 || *participateFeeRate < 0 {
+			participateCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.participate(*participateFrom, *participateTo, *participateAmount, *participateSecretHash, *participateTimeout, *participateFeeRate, *participateNode)
+	}
+	if redeemCmd.Parsed() {
+		if *redeemAddress == "" || *redeemContract == "" || *redeemSecret == "" {
+			redeemCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.redeem(*redeemAddress, *redeemContract, *redeemSecret)
+	}
+	if refundCmd.Parsed() {
+		if *refundAddress == "" || *refundContract == "" {
+			refundCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.refund(*refundAddress, *refundContract)
+	}
+	if auditContractCmd.Parsed() {
+		if *auditContractContract == "" {
+			auditContractCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.auditContract(*auditContractContract)
+	}
+	if listBannedCmd.Parsed() {
+		if *listBannedNode == "" {
+			listBannedCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.listBanned(*listBannedNode)
+	}
+	if setBanCmd.Parsed() {
+		if *setBanNode == "" || *setBanPeer == "" || *setBanTime <= 0 {
+			setBanCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.setBan(*setBanNode, *setBanPeer, *setBanTime, *setBanRemove)
+	}
+	if clearBannedCmd.Parsed() {
+		if *clearBannedNode == "" {
+			clearBannedCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.clearBanned(*clearBannedNode)
+	}
+	if startNodeCmd.Parsed() {
+		if *startNodePort <= 0 || *startNodePort > 65535 {
+			startNodeCmd.Usage()
+			runtime.Goexit()
+		}
+		var seeds []string
+		if *startNodeSeeds != "" {
+			seeds = strings.Split(*startNodeSeeds, ",")
+		}
+		cli.startNode(strconv.Itoa(*startNodePort), *startNodeMiner, seeds)
+	}
+	if getMempoolInfoCmd.Parsed() {
+		if *getMempoolInfoNode == "" {
+			getMempoolInfoCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getMempoolInfo(*getMempoolInfoNode)
+	}
+	if getRawMempoolCmd.Parsed() {
+		if *getRawMempoolNode == "" {
+			getRawMempoolCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getRawMempool(*getRawMempoolNode, *getRawMempoolVerbose)
 	}
 }
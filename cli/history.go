@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//one line of listtransactions, the json field names are what scripts reading -json rely on
+type historyEntry struct {
+	TxID           string   `json:"txid"`
+	Category       string   `json:"category"`
+	Amount         int      `json:"amount"`
+	Fee            int      `json:"fee"`
+	Height         int      `json:"height"`
+	Confirmations  int      `json:"confirmations"`
+	Time           int64    `json:"time"`
+	Addresses      []string `json:"addresses"`
+	Counterparties []string `json:"counterparties"`
+	Label          string   `json:"label,omitempty"`
+	WatchOnly      bool     `json:"watchonly,omitempty"`
+}
+
+/*
+receive and send are payments from and to others, self is a transaction between our own addresses
+generate is a block reward, watch-only marks transactions that only touched addresses we can not spend from
+*/
+func category(wtx blockchain.WalletTransaction) string {
+	switch {
+	case wtx.Coinbase:
+		return "generate"
+	case wtx.Sent > 0 && len(wtx.Counterparties) == 0:
+		return "self"
+	case wtx.Sent > 0:
+		return "send"
+	}
+	return "receive"
+}
+
+//the label of an address in place of the address itself, so the table shows who was paid
+func labelled(wallets *wallet.Wallets, addresses []string) []string {
+	var named []string
+	for _, address := range addresses {
+		if label := wallets.Label(address); label != "" {
+			address = label
+		}
+		named = append(named, address)
+	}
+	return named
+}
+
+//address limits the list to transactions touching that address of the wallet, count to the newest ones
+func (cli *CommandLine) listTransactions(address string, count int, asJSON bool) {
+	if address != "" {
+		address = normalizeAddress(address)
+	}
+	wallets := loadWallets(false)
+	owned := make(map[string]bool)
+	for _, a := range wallets.GetAllAddresses() {
+		if address != "" && a != address {
+			continue
+		}
+		owned[hex.EncodeToString(wallets.Wallets[a].PubKeyHash())] = true
+	}
+	if len(owned) == 0 {
+		log.Panic("Address is not in the wallet file")
+	}
+
+	chain := blockchain.ContinueBlockChain("")
+	history := chain.WalletHistory(owned)
+	chain.Database.Close()
+	if count > 0 && len(history) > count {
+		history = history[len(history)-count:]
+	}
+
+	entries := []historyEntry{}
+	for _, wtx := range history {
+		txID := hex.EncodeToString(wtx.TxID)
+		watchOnly := true
+		for _, a := range wtx.Addresses {
+			watchOnly = watchOnly && wallets.Wallets[a].WatchOnly
+		}
+		entries = append(entries, historyEntry{
+			TxID:           txID,
+			Category:       category(wtx),
+			Amount:         wtx.Amount(),
+			Fee:            wtx.Fee,
+			Height:         wtx.Height,
+			Confirmations:  wtx.Confirmations,
+			Time:           wtx.Timestamp,
+			Addresses:      wtx.Addresses,
+			Counterparties: wtx.Counterparties,
+			Label:          wallets.Label(txID),
+			WatchOnly:      watchOnly,
+		})
+	}
+
+	if asJSON {
+		out, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "HEIGHT\tCONF\tTIME\tCATEGORY\tAMOUNT\tFEE\tTXID\tCOUNTERPARTIES\tLABEL")
+	for _, entry := range entries {
+		if entry.WatchOnly {
+			entry.Category += " (watch-only)"
+		}
+		fmt.Fprintf(table, "%d\t%d\t%s\t%s\t%+d\t%d\t%s\t%s\t%s\n",
+			entry.Height, entry.Confirmations, time.Unix(entry.Time, 0).Format("2006-01-02 15:04"), entry.Category,
+			entry.Amount, entry.Fee, entry.TxID, strings.Join(labelled(wallets, entry.Counterparties), ","), entry.Label)
+	}
+	table.Flush()
+}
+
+//labels either address or the transaction txID, an empty label removes the one that was set
+func (cli *CommandLine) setLabel(address, txID, label string) {
+	key := address
+	if address != "" {
+		key = normalizeAddress(address)
+	}
+	if txID != "" {
+		id, err := hex.DecodeString(txID)
+		if err != nil {
+			log.Panic(err)
+		}
+		key = hex.EncodeToString(id)
+	}
+	wallets := loadWallets(false)
+	wallets.SetLabel(key, label)
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+	if label == "" {
+		fmt.Printf("Label of %s removed\n", key)
+		return
+	}
+	fmt.Printf("Labelled %s as %q\n", key, label)
+}
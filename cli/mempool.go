@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+	"github.com/RavjotSandhu/GoBlockchain/mempool"
+)
+
+/*
+the mempool of the node with nodeID as it was when the node last wrote it to its file, it does so when it shuts down
+the transactions in the file are checked against our chain again, so the ones it made invalid in the meantime are left out
+*/
+func loadMempool(chain *blockchain.Blockchain, nodeID string) *mempool.Mempool {
+	pool := mempool.New(chain, mempool.DefaultConfig)
+	if _, _, err := pool.Load(mempool.File(nodeID)); err != nil {
+		log.Panic(err)
+	}
+	return pool
+}
+
+/*
+the outputs a new transaction can spend, without a node the UTXO set of our chain
+with a node the pool of the node counts as well, its outputs can be spent and the outputs it spends can not, see blockchain.PendingSet
+*/
+func fundingSet(chain *blockchain.Blockchain, nodeID string) (blockchain.OutputSet, *mempool.Mempool) {
+	UTXOSet := blockchain.UTXOSet{chain}
+	if nodeID == "" {
+		return &UTXOSet, nil
+	}
+	pool := loadMempool(chain, nodeID)
+	return blockchain.PendingSet{UTXOSet, pool}, pool
+}
+
+//puts tx into the mempool file of the node, the node announces it to its peers once it runs
+func queueTx(pool *mempool.Mempool, nodeID string, tx *blockchain.Transaction) {
+	if err := pool.Accept(tx); err != nil {
+		log.Panic(err)
+	}
+	if err := pool.Dump(mempool.File(nodeID)); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Transaction %x is in the mempool of node %s, the node relays it once it runs\n", tx.ID, nodeID)
+}
+
+func (cli *CommandLine) getMempoolInfo(nodeID string) {
+	chain := blockchain.ContinueBlockChain("")
+	defer chain.Database.Close()
+	info := loadMempool(chain, nodeID).Info()
+	fmt.Printf("Transactions: %d\n", info.Count)
+	fmt.Printf("Size:         %d of %d bytes\n", info.Size, info.MaxSize)
+	fmt.Printf("Total fee:    %d\n", info.TotalFee)
+	fmt.Printf("Min fee rate: %d per 1000 bytes\n", info.MinFeeRate)
+}
+
+//the IDs of the transactions in the mempool, the best paying first, verbose adds what each of them pays and waits for
+func (cli *CommandLine) getRawMempool(nodeID string, verbose bool) {
+	chain := blockchain.ContinueBlockChain("")
+	defer chain.Database.Close()
+	pool := loadMempool(chain, nodeID)
+	entries := pool.Entries()
+	if !verbose {
+		for _, entry := range entries {
+			fmt.Printf("%x\n", entry.Tx.ID)
+		}
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("Mempool is empty")
+		return
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TXID\tFEE\tSIZE\tFEERATE\tTIME\tRBF\tDEPENDS ON")
+	for _, entry := range entries {
+		var depends []string
+		for _, ancestor := range pool.Ancestors(entry.Tx.ID) {
+			depends = append(depends, hex.EncodeToString(ancestor.Tx.ID)[:16])
+		}
+		fmt.Fprintf(table, "%x\t%d\t%d\t%d\t%s\t%t\t%s\n", entry.Tx.ID, entry.Fee, entry.Size, entry.FeeRate(), entry.Time.Format("2006-01-02 15:04:05"), entry.Tx.SignalsReplacement(), strings.Join(depends, ","))
+	}
+	table.Flush()
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+)
+
+//the -to flag can be repeated, every value is ADDR:AMOUNT or just ADDR when the amount comes from -amount
+type paymentList []blockchain.Payment
+
+func (p *paymentList) String() string {
+	var parts []string
+	for _, payment := range *p {
+		parts = append(parts, fmt.Sprintf("%s:%d", payment.Address, payment.Amount))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p *paymentList) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	payment := blockchain.Payment{Address: parts[0]}
+	if len(parts) == 2 {
+		amt, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid amount in %s", value)
+		}
+		payment.Amount = amt
+	}
+	*p = append(*p, payment)
+	return nil
+}
+
+//fills in the -amount of a plain -to ADDR so the old single recipient form keeps working
+func (p paymentList) withAmount(amt int) ([]blockchain.Payment, error) {
+	payments := append([]blockchain.Payment{}, p...)
+	for i, payment := range payments {
+		if payment.Amount != 0 {
+			continue
+		}
+		if len(payments) > 1 || amt <= 0 {
+			return nil, fmt.Errorf("no amount given for %s, use -to ADDR:AMOUNT", payment.Address)
+		}
+		payments[i].Amount = amt
+	}
+	return payments, nil
+}
+
+/*
+reads payouts from a file, a .json file holds a list of {"address": ..., "amount": ...} objects
+anything else is read as csv with one address,amount pair per line
+*/
+func readPaymentsFile(path string) ([]blockchain.Payment, error) {
+	var payments []blockchain.Payment
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var entries []struct {
+			Address string `json:"address"`
+			Amount  int    `json:"amount"`
+		}
+		if err := json.Unmarshal(content, &entries); err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			payments = append(payments, blockchain.Payment{Address: entry.Address, Amount: entry.Amount})
+		}
+		return payments, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		amt, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			//a header line is allowed
+			if i == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("invalid amount on line %d of %s", i+1, path)
+		}
+		payments = append(payments, blockchain.Payment{Address: strings.TrimSpace(record[0]), Amount: amt})
+	}
+	return payments, nil
+}
+
+//collects the payments of send and createrawtx from the -to flags, -amount and -file
+func collectPayments(to paymentList, amt int, file string) ([]blockchain.Payment, error) {
+	payments, err := to.withAmount(amt)
+	if err != nil {
+		return nil, err
+	}
+	if file != "" {
+		filePayments, err := readPaymentsFile(file)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, filePayments...)
+	}
+	if _, err := blockchain.PaymentOutputs(payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+/*
+spec picks where the key of address is: unix:PATH asks the signer daemon listening at PATH, file:PATH reads it from a key file exportkey wrote
+without a spec the key comes from our wallet file like it always did, a key file does not need the address as it can only hold one key
+*/
+func loadSigner(spec, address string) (wallet.Signer, *wallet.Wallets) {
+	if spec == "" {
+		return loadWallet(address)
+	}
+	var signer wallet.Signer
+	switch {
+	case strings.HasPrefix(spec, "unix:") && address == "":
+		log.Panic("The signer daemon needs the address to sign for")
+	case strings.HasPrefix(spec, "unix:"):
+		signer = blockchain.NewSocketSigner(strings.TrimPrefix(spec, "unix:"), normalizeAddress(address))
+	case strings.HasPrefix(spec, "file:"):
+		signer = wallet.NewFileSigner(strings.TrimPrefix(spec, "file:"))
+	default:
+		log.Panic("Unknown signer " + spec + ", use unix:SOCKET or file:KEYFILE")
+	}
+	signerAddress, err := wallet.SignerAddress(signer)
+	if err != nil {
+		log.Panic(err)
+	}
+	if address != "" && signerAddress != normalizeAddress(address) {
+		log.Panic("The signer holds the key of " + signerAddress + ", not " + address)
+	}
+	return signer, loadWallets(false)
+}
+
+/*
+runs the signer daemon, the wallet stays unlocked in this process and the node only gets signatures
+allow limits the addresses it signs for, destinations the addresses outside the wallet it pays and maxAmount how much
+maxSpent limits what leaves the wallet with the fee, the inputs are worth what the client says as the signatures commit to it
+maxRate limits the transactions per minute and confirm shows every transaction on the terminal before signing it
+*/
+func (cli *CommandLine) signerd(socket, allow, destinations string, maxAmount, maxSpent, maxRate int, confirm bool) {
+	wallets := loadWallets(false)
+	if wallets.IsLocked() {
+		passphrase := readPassphrase("Wallet passphrase: ", "WALLET_PASSPHRASE")
+		if err := wallets.Unlock(passphrase, 0); err != nil {
+			log.Panic(err)
+		}
+	}
+	policy := blockchain.SignerPolicy{Allowed: addressSet(allow), Destinations: addressSet(destinations), MaxAmount: maxAmount, MaxSpent: maxSpent, MaxPerMinute: maxRate}
+	if confirm {
+		policy.Confirm = func(address, details string) bool {
+			fmt.Printf("Transaction to sign with the key of %s:\n%s\nSign? [y/N] ", address, details)
+			line, _ := stdin.ReadString('\n')
+			return strings.ToLower(strings.TrimSpace(line)) == "y"
+		}
+	}
+
+	//the socket is removed on the way out so the next run and clients do not find a dead one
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		wallets.Lock()
+		os.Remove(socket)
+		os.Exit(0)
+	}()
+
+	fmt.Printf("Signer listening on %s\n", socket)
+	if err := blockchain.NewSignerServer(wallets, policy).ListenAndServe(socket); err != nil {
+		log.Panic(err)
+	}
+}
+
+//a comma separated list of addresses as a set, empty when the list is
+func addressSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	if list != "" {
+		for _, address := range strings.Split(list, ",") {
+			set[normalizeAddress(strings.TrimSpace(address))] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,337 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//how long the keys of an encrypted wallet stay decrypted after we unlocked it
+const walletUnlockTimeout = 5 * time.Minute
+
+var stdin = bufio.NewReader(os.Stdin)
+
+/*
+the passphrase is taken from the WALLET_PASSPHRASE environment variable so scripts can run without a prompt, otherwise it is read from the terminal
+a new passphrase for encryptwallet and changepassphrase comes from WALLET_NEW_PASSPHRASE
+*/
+func readPassphrase(prompt, envVar string) string {
+	if passphrase, ok := os.LookupEnv(envVar); ok {
+		return passphrase
+	}
+	fmt.Print(prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		log.Panic(err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func readNewPassphrase() string {
+	if passphrase, ok := os.LookupEnv("WALLET_NEW_PASSPHRASE"); ok && passphrase != "" {
+		return passphrase
+	}
+	passphrase := readPassphrase("New wallet passphrase: ", "WALLET_NEW_PASSPHRASE")
+	if passphrase == "" {
+		log.Panic("The passphrase can not be empty")
+	}
+	if readPassphrase("Repeat the passphrase: ", "WALLET_NEW_PASSPHRASE") != passphrase {
+		log.Panic("The passphrases do not match")
+	}
+	return passphrase
+}
+
+//loads the wallet file, when unlock is set an encrypted wallet is unlocked so its keys can sign
+func loadWallets(unlock bool) *wallet.Wallets {
+	wallets, err := wallet.CreateWallets()
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	if unlock && wallets.IsLocked() {
+		unlockWallets(wallets)
+	}
+	return wallets
+}
+
+func unlockWallets(wallets *wallet.Wallets) {
+	passphrase := readPassphrase("Wallet passphrase: ", "WALLET_PASSPHRASE")
+	if err := wallets.Unlock(passphrase, walletUnlockTimeout); err != nil {
+		log.Panic(err)
+	}
+}
+
+//panics with the reason the address is not valid, for a typo in a bech32 address that includes where it is
+func validateAddress(address string) {
+	if _, err := wallet.DecodeAddress(address); err != nil {
+		log.Panic("Address is not Valid: ", err)
+	}
+}
+
+//the address as the wallet file knows it, base58 addresses are turned into their bech32 form
+func normalizeAddress(address string) string {
+	normalized, err := wallet.NormalizeAddress(address)
+	if err != nil {
+		log.Panic(err)
+	}
+	return normalized
+}
+
+//the unlocked wallet for address, it has to be in our wallet file
+func loadWallet(address string) (wallet.Wallet, *wallet.Wallets) {
+	address = normalizeAddress(address)
+	wallets := loadWallets(true)
+	if _, ok := wallets.Wallets[address]; !ok {
+		fmt.Printf("%s is not in the wallet file\n", address)
+		runtime.Goexit()
+	}
+	return wallets.GetWallet(address), wallets
+}
+
+/*
+a new internal address for the change of a transaction from address, it is saved before the transaction is built so change can never go to a key that is not in the wallet file
+addresses we can not sign for (watch-only ones or ones that are not in our wallet file) get their change back
+*/
+func changeAddress(wallets *wallet.Wallets, from string) string {
+	if w, ok := wallets.Wallets[normalizeAddress(from)]; !ok || w.WatchOnly {
+		return from
+	}
+	if wallets.IsLocked() {
+		unlockWallets(wallets)
+	}
+	address, err := wallets.NewChangeAddress(normalizeAddress(from))
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+	return address
+}
+
+func (cli *CommandLine) encryptWallet() {
+	wallets := loadWallets(false)
+	if wallets.IsEncrypted() {
+		log.Panic(wallet.ErrWalletEncrypted)
+	}
+	if err := wallets.Encrypt(readNewPassphrase()); err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+	fmt.Println("Wallet encrypted, the passphrase is needed from now on to sign transactions and create addresses")
+}
+
+func (cli *CommandLine) changePassphrase() {
+	wallets := loadWallets(false)
+	if !wallets.IsEncrypted() {
+		log.Panic(wallet.ErrWalletUnencrypted)
+	}
+	oldPassphrase := readPassphrase("Current wallet passphrase: ", "WALLET_PASSPHRASE")
+	if err := wallets.ChangePassphrase(oldPassphrase, readNewPassphrase()); err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+	fmt.Println("Passphrase changed")
+}
+
+//creates a new seed for the wallet and shows the words it can be restored from
+func (cli *CommandLine) createHDWallet(words int) {
+	wallets := loadWallets(true)
+	mnemonic, err := wallet.NewMnemonic(words)
+	if err != nil {
+		log.Panic(err)
+	}
+	seed, err := wallet.MnemonicToSeed(mnemonic, "")
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SetSeed(seed); err != nil {
+		log.Panic(err)
+	}
+	address, err := wallets.NewHDAddress(0, wallet.ReceiveChain)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+	fmt.Println("Write down these words, they are the only backup needed to restore every address of this wallet:")
+	fmt.Println(mnemonic)
+	fmt.Printf("New address is: %s\n", address)
+}
+
+//sets the seed from the words and looks through the chain for the addresses that were used
+func (cli *CommandLine) restoreWallet(mnemonic string, accounts, gap int) {
+	wallets := loadWallets(true)
+	seed, err := wallet.MnemonicToSeed(mnemonic, "")
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SetSeed(seed); err != nil {
+		log.Panic(err)
+	}
+
+	found := 0
+	if blockchain.DBexists() {
+		chain := blockchain.ContinueBlockChain("")
+		used := chain.UsedPubKeyHashes()
+		chain.Database.Close()
+		for account := 0; account < accounts; account++ {
+			n, err := wallets.ScanHD(uint32(account), gap, func(pubKeyHash []byte) bool {
+				return used[hex.EncodeToString(pubKeyHash)]
+			})
+			if err != nil {
+				log.Panic(err)
+			}
+			found += n
+		}
+	}
+	if found == 0 {
+		if _, err := wallets.NewHDAddress(0, wallet.ReceiveChain); err != nil {
+			log.Panic(err)
+		}
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Wallet restored, found %d used addresses\n", found)
+}
+
+func (cli *CommandLine) signMessage(address, message string) {
+	w, _ := loadWallet(address)
+	signature, err := w.SignMessage(message)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(signature)
+}
+
+func (cli *CommandLine) verifyMessage(address, signature, message string) {
+	if err := wallet.VerifyMessage(address, signature, message); err != nil {
+		fmt.Printf("Signature is not valid: %s\n", err)
+		runtime.Goexit()
+	}
+	fmt.Println("Signature is valid")
+}
+
+func (cli *CommandLine) exportKey(address, format, out string) {
+	w, _ := loadWallet(address)
+	var exported []byte
+	switch format {
+	case "wif":
+		wif, err := w.ExportWIF()
+		if err != nil {
+			log.Panic(err)
+		}
+		exported = []byte(wif + "\n")
+	case "pem":
+		pem, err := w.ExportPEM()
+		if err != nil {
+			log.Panic(err)
+		}
+		exported = pem
+	default:
+		log.Panic("Unknown key format " + format + ", use wif or pem")
+	}
+	if out == "" {
+		fmt.Print(string(exported))
+		return
+	}
+	if err := ioutil.WriteFile(out, exported, 0600); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Private key of %s written to %s\n", address, out)
+}
+
+//the key is read from -key or from a file, in either of the formats exportkey writes
+func (cli *CommandLine) importKey(key, file string, rescan bool) {
+	data := []byte(key)
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Panic(err)
+		}
+		data = content
+	}
+	w, err := wallet.ParsePrivateKey(data)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets := loadWallets(true)
+	address, err := wallets.ImportKey(w)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Imported address: %s\n", address)
+	if rescan {
+		rescanAddress(address)
+	}
+}
+
+//scans the chain for what an imported address received and spent before it was added, the balance comes from the UTXO set which holds every output anyway
+func rescanAddress(address string) {
+	if !blockchain.DBexists() {
+		return
+	}
+	chain := blockchain.ContinueBlockChain("")
+	defer chain.Database.Close()
+	pubKeyHash := wallet.AddressToPubKeyHash(address)
+	history := chain.WalletHistory(map[string]bool{hex.EncodeToString(pubKeyHash): true})
+	received, sent := 0, 0
+	for _, wtx := range history {
+		received += wtx.Received
+		sent += wtx.Sent
+	}
+	UTXOSet := blockchain.UTXOSet{chain}
+	bal := 0
+	for _, out := range UTXOSet.FindUTXO(pubKeyHash) {
+		bal += out.Value
+	}
+	fmt.Printf("Rescan found %d transactions of %s, received %d and sent %d\n", len(history), address, received, sent)
+	fmt.Printf("Balance of %s: %d\n", address, bal)
+}
+
+//adds an address without its private key, pubKey is hex and used instead of address when it is given
+func (cli *CommandLine) importAddress(address, pubKey string, rescan bool) {
+	var w *wallet.Wallet
+	var err error
+	if pubKey != "" {
+		key, decodeErr := hex.DecodeString(pubKey)
+		if decodeErr != nil {
+			log.Panic(decodeErr)
+		}
+		w, err = wallet.NewWatchOnlyPubKey(key)
+	} else {
+		w, err = wallet.NewWatchOnlyAddress(address)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets := loadWallets(false)
+	address, err = wallets.AddWatchOnly(w)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Watching address: %s\n", address)
+	if rescan {
+		rescanAddress(address)
+	}
+}
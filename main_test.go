@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var addressPattern = regexp.MustCompile(`gb1[a-z0-9]+`)
+
+//output of a node that is still running, written and read from different goroutines
+type nodeOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (out *nodeOutput) Write(p []byte) (int, error) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	return out.buf.Write(p)
+}
+
+func (out *nodeOutput) String() string {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	return out.buf.String()
+}
+
+//builds the command line into a temporary directory
+func buildCLI(t *testing.T) string {
+	bin := filepath.Join(t.TempDir(), "blockchain")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %s\n%s", err, out)
+	}
+	return bin
+}
+
+func runCLI(t *testing.T, bin, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+//runs a command that is expected to be refused, main exits with 0 even after a panic so only its output tells
+func refusedCLI(bin, dir string, args ...string) string {
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = dir
+	out, _ := cmd.CombinedOutput()
+	return string(out)
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func copyDir(t *testing.T, from, to string) {
+	err := filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(from, path)
+		target := filepath.Join(to, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, info.Mode())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func blockCount(t *testing.T, bin, dir string) int {
+	count := 0
+	for _, line := range strings.Split(runCLI(t, bin, dir, "printchain"), "\n") {
+		if strings.HasPrefix(line, "Hash: ") {
+			count++
+		}
+	}
+	return count
+}
+
+//three nodes on loopback that start from the same genesis, the two behind catch up with the first through its seed address
+func TestNodesSyncOverLoopback(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts several nodes")
+	}
+	bin := buildCLI(t)
+	first := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(first, ".tmp", "blocks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	from := addressPattern.FindString(runCLI(t, bin, first, "createwallet"))
+	to := addressPattern.FindString(runCLI(t, bin, first, "createwallet"))
+	runCLI(t, bin, first, "createblockchain", "-address", from)
+	dirs := []string{first, t.TempDir(), t.TempDir()}
+	for _, dir := range dirs[1:] {
+		copyDir(t, first, dir)
+	}
+	//only the first node has the blocks on top of genesis
+	runCLI(t, bin, first, "send", "-from", from, "-to", to, "-amount", "1")
+	runCLI(t, bin, first, "send", "-from", from, "-to", to, "-amount", "1")
+	expected := blockCount(t, bin, first)
+
+	seed := "localhost:" + strconv.Itoa(freePort(t))
+	var nodes []*exec.Cmd
+	var outputs []*nodeOutput
+	for i, dir := range dirs {
+		port := strings.TrimPrefix(seed, "localhost:")
+		if i > 0 {
+			port = strconv.Itoa(freePort(t))
+		}
+		output := &nodeOutput{}
+		node := exec.Command(bin, "startnode", "-port", port, "-seeds", seed)
+		node.Dir = dir
+		node.Stdout, node.Stderr = output, output
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+		outputs = append(outputs, output)
+		if i == 0 {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+	stop := func() {
+		for _, node := range nodes {
+			node.Process.Signal(os.Interrupt)
+			node.Wait()
+		}
+	}
+	defer stop()
+
+	//a node that caught up has added every block the first one mined
+	deadline := time.Now().Add(30 * time.Second)
+	for _, output := range outputs[1:] {
+		for strings.Count(output.String(), "Added block") < expected-1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("node did not catch up in time:\n%s", output)
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+	}
+	stop()
+	nodes = nil
+	for _, dir := range dirs {
+		if count := blockCount(t, bin, dir); count != expected {
+			t.Errorf("node in %s has %d blocks, expected %d", dir, count, expected)
+		}
+	}
+}
+
+//transactions queued for a stopped node can be spent from before they are mined, the node's mempool file holds the whole chain of them
+func TestSpendFromPendingTransactions(t *testing.T) {
+	bin := buildCLI(t)
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".tmp", "blocks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	from := addressPattern.FindString(runCLI(t, bin, dir, "createwallet"))
+	to := addressPattern.FindString(runCLI(t, bin, dir, "createwallet"))
+	runCLI(t, bin, dir, "createblockchain", "-address", from)
+	runCLI(t, bin, dir, "reindexutxo")
+
+	node := strconv.Itoa(freePort(t))
+	runCLI(t, bin, dir, "send", "-from", from, "-to", to, "-amount", "6", "-node", node)
+	//only the queued payment funds to, the chain has nothing of it yet
+	runCLI(t, bin, dir, "createrawtx", "-from", to, "-to", from+":2", "-node", node, "-out", "child")
+	runCLI(t, bin, dir, "signrawtx", "-in", "child")
+	runCLI(t, bin, dir, "sendrawtx", "-in", "child", "-node", node)
+
+	pending := 0
+	for _, line := range strings.Split(runCLI(t, bin, dir, "getrawmempool", "-node", node), "\n") {
+		if len(line) == 64 {
+			pending++
+		}
+	}
+	if pending != 2 {
+		t.Fatalf("the mempool of the node holds %d transactions, expected the payment and the one spending it", pending)
+	}
+	if count := blockCount(t, bin, dir); count != 1 {
+		t.Fatalf("the chain has %d blocks, queued transactions were mined", count)
+	}
+}
+
+//a replaceable transaction waiting in the mempool of a stopped node is bumped by its ID alone and the replacement takes its place
+func TestBumpFeeOfPendingTransaction(t *testing.T) {
+	bin := buildCLI(t)
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".tmp", "blocks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	from := addressPattern.FindString(runCLI(t, bin, dir, "createwallet"))
+	to := addressPattern.FindString(runCLI(t, bin, dir, "createwallet"))
+	runCLI(t, bin, dir, "createblockchain", "-address", from)
+	runCLI(t, bin, dir, "reindexutxo")
+
+	node := strconv.Itoa(freePort(t))
+	txID := regexp.MustCompile(`Transaction ([0-9a-f]{64})`)
+	original := txID.FindStringSubmatch(runCLI(t, bin, dir, "send", "-from", from, "-to", to, "-amount", "3", "-rbf", "-node", node))[1]
+	replacement := regexp.MustCompile(`Replacement ([0-9a-f]{64})`).FindStringSubmatch(runCLI(t, bin, dir, "bumpfee", "-txid", original, "-fee", "2", "-node", node))[1]
+
+	pending := regexp.MustCompile(`(?m)^[0-9a-f]{64}$`).FindAllString(runCLI(t, bin, dir, "getrawmempool", "-node", node), -1)
+	if len(pending) != 1 || pending[0] != replacement {
+		t.Fatalf("the mempool of the node holds %v, expected only the replacement %s", pending, replacement)
+	}
+}
+
+//an atomic swap between two chains in their own directories, the secret the initiator reveals on the second chain redeems the first
+func TestAtomicSwapBetweenTwoChains(t *testing.T) {
+	bin := buildCLI(t)
+	first, second := t.TempDir(), t.TempDir()
+	for _, dir := range []string{first, second} {
+		if err := os.MkdirAll(filepath.Join(dir, ".tmp", "blocks"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//the initiator has the coins of the first chain, the participant those of the second
+	initiatorFirst := addressPattern.FindString(runCLI(t, bin, first, "createwallet"))
+	participantFirst := addressPattern.FindString(runCLI(t, bin, first, "createwallet"))
+	initiatorSecond := addressPattern.FindString(runCLI(t, bin, second, "createwallet"))
+	participantSecond := addressPattern.FindString(runCLI(t, bin, second, "createwallet"))
+	runCLI(t, bin, first, "createblockchain", "-address", initiatorFirst)
+	runCLI(t, bin, second, "createblockchain", "-address", participantSecond)
+	runCLI(t, bin, first, "reindexutxo")
+	runCLI(t, bin, second, "reindexutxo")
+
+	contract := regexp.MustCompile(`Contract:\s+([0-9a-f]{64}:\d+)`)
+	secretHash := regexp.MustCompile(`Secret hash:\s+([0-9a-f]{64})`)
+	secretLine := regexp.MustCompile(`Secret:\s+([0-9a-f]{64})`)
+	initiated := runCLI(t, bin, first, "initiate", "-from", initiatorFirst, "-to", participantFirst, "-amount", "5")
+	firstContract := contract.FindStringSubmatch(initiated)[1]
+	hash := secretHash.FindStringSubmatch(initiated)[1]
+	secret := secretLine.FindStringSubmatch(initiated)[1]
+
+	//a secret hash no secret can match is refused before anything is locked
+	short := exec.Command(bin, "participate", "-from", participantSecond, "-to", initiatorSecond, "-amount", "7", "-secrethash", hash[:62])
+	short.Dir = second
+	if out, _ := short.CombinedOutput(); !strings.Contains(string(out), "has to be 32") || contract.Match(out) {
+		t.Fatalf("participate with a 31 byte secret hash created a contract:\n%s", out)
+	}
+
+	participated := runCLI(t, bin, second, "participate", "-from", participantSecond, "-to", initiatorSecond, "-amount", "7", "-secrethash", hash)
+	secondContract := contract.FindStringSubmatch(participated)[1]
+	runCLI(t, bin, second, "redeem", "-address", initiatorSecond, "-contract", secondContract, "-secret", secret)
+
+	//the participant learns the secret from the redeemed contract on the second chain
+	audit := runCLI(t, bin, second, "auditcontract", "-contract", secondContract)
+	revealed := secretLine.FindStringSubmatch(audit)
+	if revealed == nil || revealed[1] != secret {
+		t.Fatalf("the redeemed contract does not reveal the secret:\n%s", audit)
+	}
+	runCLI(t, bin, first, "redeem", "-address", participantFirst, "-contract", firstContract, "-secret", revealed[1])
+	if audit := runCLI(t, bin, first, "auditcontract", "-contract", firstContract); !strings.Contains(audit, "redeemed by") {
+		t.Fatalf("the contract on the first chain is not redeemed:\n%s", audit)
+	}
+}
+
+//an address whose key is on another machine is followed for its balance, but nothing can be signed for it
+func TestWatchOnlyAddressShowsBalanceButDoesNotSign(t *testing.T) {
+	bin := buildCLI(t)
+	hot, cold := t.TempDir(), t.TempDir()
+	for _, dir := range []string{hot, cold} {
+		if err := os.MkdirAll(filepath.Join(dir, ".tmp", "blocks"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	watched := addressPattern.FindString(runCLI(t, bin, cold, "createwallet"))
+	owner := addressPattern.FindString(runCLI(t, bin, hot, "createwallet"))
+	runCLI(t, bin, hot, "createblockchain", "-address", watched)
+	runCLI(t, bin, hot, "reindexutxo")
+	runCLI(t, bin, hot, "importaddress", "-address", watched)
+
+	if !regexp.MustCompile(regexp.QuoteMeta(watched) + `.* watch-only`).MatchString(runCLI(t, bin, hot, "listaddresses")) {
+		t.Fatalf("%s is not listed as watch-only", watched)
+	}
+	balances := runCLI(t, bin, hot, "getbalance")
+	for _, expected := range []string{"Balance of " + watched + ": 20 (watch-only)", "Total: 0", "Watch-only total: 20"} {
+		if !strings.Contains(balances, expected) {
+			t.Fatalf("the wallet balance does not show %q\n%s", expected, balances)
+		}
+	}
+	if balance := runCLI(t, bin, hot, "getbalance", "-address", watched); !strings.Contains(balance, "Balance of "+watched+": 20") {
+		t.Fatalf("the balance of the watched address is wrong\n%s", balance)
+	}
+
+	if out := refusedCLI(bin, hot, "send", "-from", watched, "-to", owner, "-amount", "1"); !strings.Contains(out, "watch-only") {
+		t.Fatalf("sending from a watch-only address did not fail for it being watch-only\n%s", out)
+	}
+	if out := refusedCLI(bin, hot, "signmessage", "-address", watched, "-message", "mine"); !strings.Contains(out, "watch-only") {
+		t.Fatalf("signing a message with a watch-only address did not fail for it being watch-only\n%s", out)
+	}
+	if count := blockCount(t, bin, hot); count != 1 {
+		t.Fatalf("the chain has %d blocks, the refused send was mined", count)
+	}
+}
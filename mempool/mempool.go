@@ -0,0 +1,687 @@
+package mempool
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+)
+
+/*
+the memory pool holds the transactions a node has accepted but that are not in a block yet
+a transaction is only accepted when it could go into the next block: its signatures are valid, the outputs it spends are unspent and no other transaction in the pool spends them
+every spent output is indexed by the transaction spending it, that is how conflicts are found and how a block removes the transactions it made invalid
+the pool has a size limit, when it is full the transactions paying the lowest fee per byte are evicted first
+a transaction can spend the outputs of another one in the pool, the parent and all its unconfirmed ancestors have to be mined first
+so a child paying a high fee gets a cheap parent mined along with it, and whatever removes a parent from the pool removes its descendants too
+a transaction that signals replaceability can be replaced by one spending the same outputs that pays more, see checkReplacement
+*/
+type Config struct {
+	MaxSize        int           //bytes of serialized transactions the pool holds at most
+	Expiry         time.Duration //transactions older than this are dropped, 0 keeps them until they are mined
+	MaxAncestors   int           //pool transactions in the chain a transaction is at the end of, itself included, 0 means no limit
+	MaxDescendants int           //pool transactions depending on one transaction, itself included, 0 means no limit
+	MaxReplaced    int           //pool transactions a replacement can evict, descendants included, 0 means no limit
+}
+
+var DefaultConfig = Config{
+	MaxSize:        5 * 1024 * 1024,
+	Expiry:         72 * time.Hour,
+	MaxAncestors:   25,
+	MaxDescendants: 25,
+	MaxReplaced:    100,
+}
+
+var (
+	ErrAlreadyKnown = errors.New("Transaction is already in the mempool")
+	ErrCoinbase     = errors.New("A coinbase transaction can only be in a block")
+	ErrMempoolFull  = errors.New("Mempool is full and the transaction pays a too low fee rate")
+	ErrIDMismatch   = errors.New("Transaction ID does not match its contents")
+	ErrBadSignature = errors.New("Transaction has an invalid signature")
+)
+
+//an input spends an output that is neither in the UTXO set nor in the pool, the transaction it comes from may still be on its way to us
+type MissingOutputError struct {
+	Outpoint string
+}
+
+func (e MissingOutputError) Error() string {
+	return fmt.Sprintf("Output %s does not exist or is already spent", e.Outpoint)
+}
+
+//one accepted transaction with what the pool needs to know about it
+type Entry struct {
+	Tx       *blockchain.Transaction
+	Fee      int //inputs minus outputs, what the miner of the transaction gets
+	Size     int //bytes of the serialized transaction
+	Time     time.Time
+	Height   int //best height of the chain when the transaction was accepted
+	PrevOuts []blockchain.TxOutput
+
+	parents  map[string]bool //pool transactions whose outputs this one spends
+	children map[string]bool //pool transactions spending outputs of this one
+}
+
+//fee per 1000 bytes
+func (e *Entry) FeeRate() int {
+	return e.Fee * 1000 / e.Size
+}
+
+//true when a pays a lower fee per byte than b, compared without rounding
+func lowerFeeRate(a, b *Entry) bool {
+	return a.Fee*b.Size < b.Fee*a.Size
+}
+
+type Mempool struct {
+	mu      sync.Mutex
+	chain   *blockchain.Blockchain
+	config  Config
+	entries map[string]*Entry
+	spends  map[string]string //outpoint to the ID of the pool transaction spending it
+	size    int
+}
+
+func New(chain *blockchain.Blockchain, config Config) *Mempool {
+	return &Mempool{
+		chain:   chain,
+		config:  config,
+		entries: make(map[string]*Entry),
+		spends:  make(map[string]string),
+	}
+}
+
+func outpoint(txID []byte, out int) string {
+	return fmt.Sprintf("%x:%d", txID, out)
+}
+
+//validates tx against the chain and the pool and adds it, the error says why a transaction was refused
+func (mp *Mempool) Accept(tx *blockchain.Transaction) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.expire(time.Now())
+
+	entry, replaced, err := mp.check(tx)
+	if err != nil {
+		return err
+	}
+	if err := mp.makeRoom(entry, replaced); err != nil {
+		return err
+	}
+	mp.add(entry)
+	return nil
+}
+
+/*
+every check a transaction has to pass before it is added
+returns the entry it would get and the pool transactions it replaces, they leave the pool when it is added
+*/
+func (mp *Mempool) check(tx *blockchain.Transaction) (*Entry, map[string]bool, error) {
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := mp.entries[id]; ok {
+		return nil, nil, ErrAlreadyKnown
+	}
+	if tx.IsCoinbase() {
+		return nil, nil, ErrCoinbase
+	}
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return nil, nil, errors.New("Transaction has no inputs or no outputs")
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return nil, nil, ErrIDMismatch
+	}
+
+	outputs := 0
+	for _, out := range tx.Outputs {
+		if out.Value <= 0 || outputs+out.Value < outputs {
+			return nil, nil, errors.New("Transaction has an output with an invalid value")
+		}
+		outputs += out.Value
+	}
+
+	UTXOSet := blockchain.UTXOSet{mp.chain}
+	seen := make(map[string]bool)
+	parents := make(map[string]bool)
+	conflicts := make(map[string]bool)
+	var prevOuts []blockchain.TxOutput
+	inputs := 0
+	for _, in := range tx.Inputs {
+		point := outpoint(in.ID, in.Out)
+		if seen[point] {
+			return nil, nil, fmt.Errorf("Transaction spends %s twice", point)
+		}
+		seen[point] = true
+		if spender, ok := mp.spends[point]; ok {
+			conflicts[spender] = true
+		}
+		prevOut, ok := UTXOSet.FindOutput(in.ID, in.Out)
+		if parent, inPool := mp.entries[hex.EncodeToString(in.ID)]; !ok && inPool && in.Out >= 0 && in.Out < len(parent.Tx.Outputs) {
+			prevOut, ok = parent.Tx.Outputs[in.Out], true
+			parents[hex.EncodeToString(in.ID)] = true
+		}
+		if !ok {
+			return nil, nil, MissingOutputError{point}
+		}
+		prevOuts = append(prevOuts, prevOut)
+		inputs += prevOut.Value
+	}
+	if inputs < outputs {
+		return nil, nil, fmt.Errorf("Transaction spends %d but its inputs are only worth %d", outputs, inputs)
+	}
+	if !tx.VerifyOutputs(prevOuts) {
+		return nil, nil, ErrBadSignature
+	}
+	height := mp.chain.GetBestHeight()
+	if err := mp.chain.CheckLocksUnconfirmed(tx, height+1, mp.chain.LastHash, parents); err != nil {
+		return nil, nil, err
+	}
+
+	ancestors := mp.ancestors(parents)
+	if mp.config.MaxAncestors > 0 && len(ancestors)+1 > mp.config.MaxAncestors {
+		return nil, nil, fmt.Errorf("Transaction would have %d unconfirmed ancestors, at most %d are allowed", len(ancestors), mp.config.MaxAncestors-1)
+	}
+	for ancestor := range ancestors {
+		if mp.config.MaxDescendants > 0 && len(mp.descendants(ancestor))+1 >= mp.config.MaxDescendants {
+			return nil, nil, fmt.Errorf("Unconfirmed ancestor %s already has the most descendants allowed", ancestor)
+		}
+	}
+
+	entry := &Entry{
+		Tx:       tx,
+		Fee:      inputs - outputs,
+		Size:     len(tx.Serialize()),
+		Time:     time.Now(),
+		Height:   height,
+		PrevOuts: prevOuts,
+		parents:  parents,
+		children: make(map[string]bool),
+	}
+	replaced, err := mp.checkReplacement(entry, conflicts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entry, replaced, nil
+}
+
+/*
+entry replaces the pool transactions it conflicts with, and their descendants along with them, when
+every one of them signals replaceability itself or through an unconfirmed ancestor,
+entry pays a higher fee rate than each of them and a higher fee than all the transactions it evicts together,
+and entry does not spend an output of one of the transactions it evicts
+*/
+func (mp *Mempool) checkReplacement(entry *Entry, conflicts map[string]bool) (map[string]bool, error) {
+	replaced := make(map[string]bool)
+	for id := range conflicts {
+		if !mp.replaceable(id) {
+			return nil, fmt.Errorf("Transaction conflicts with %s in the mempool which does not signal that it can be replaced", id)
+		}
+		if conflict := mp.entries[id]; !lowerFeeRate(conflict, entry) {
+			return nil, fmt.Errorf("Replacement pays a fee rate of %d, it has to be higher than the %d of %s", entry.FeeRate(), conflict.FeeRate(), id)
+		}
+		replaced[id] = true
+		for descendant := range mp.descendants(id) {
+			replaced[descendant] = true
+		}
+	}
+	if mp.config.MaxReplaced > 0 && len(replaced) > mp.config.MaxReplaced {
+		return nil, fmt.Errorf("Replacement would evict %d transactions, at most %d are allowed", len(replaced), mp.config.MaxReplaced)
+	}
+	for ancestor := range mp.ancestors(entry.parents) {
+		if replaced[ancestor] {
+			return nil, fmt.Errorf("Replacement spends an output of %s which it would evict", ancestor)
+		}
+	}
+	if evicted := mp.packageOf(replaced); len(replaced) > 0 && entry.Fee <= evicted.Fee {
+		return nil, fmt.Errorf("Replacement pays a fee of %d, it has to be higher than the %d of the transactions it evicts", entry.Fee, evicted.Fee)
+	}
+	return replaced, nil
+}
+
+//true when id or one of its unconfirmed ancestors signals replaceability
+func (mp *Mempool) replaceable(id string) bool {
+	for ancestor := range mp.ancestors(map[string]bool{id: true}) {
+		if mp.entries[ancestor].Tx.SignalsReplacement() {
+			return true
+		}
+	}
+	return false
+}
+
+//the pool transactions the ones in ids depend on, directly or through others, not including ids themselves unless one depends on another
+func (mp *Mempool) ancestors(ids map[string]bool) map[string]bool {
+	found := make(map[string]bool)
+	queue := make([]string, 0, len(ids))
+	for id := range ids {
+		queue = append(queue, id)
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		entry, ok := mp.entries[id]
+		if !ok || found[id] {
+			continue
+		}
+		found[id] = true
+		for parent := range entry.parents {
+			queue = append(queue, parent)
+		}
+	}
+	return found
+}
+
+//the pool transactions depending on id, directly or through others, id itself is not included
+func (mp *Mempool) descendants(id string) map[string]bool {
+	found := make(map[string]bool)
+	queue := []string{id}
+	for len(queue) > 0 {
+		entry, ok := mp.entries[queue[0]]
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for child := range entry.children {
+			if !found[child] {
+				found[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return found
+}
+
+//fee and size of a set of pool transactions taken together, so the fee rate of a package compares like the one of a single transaction
+func (mp *Mempool) packageOf(ids map[string]bool) *Entry {
+	pkg := &Entry{}
+	for id := range ids {
+		if entry, ok := mp.entries[id]; ok {
+			pkg.Fee += entry.Fee
+			pkg.Size += entry.Size
+		}
+	}
+	return pkg
+}
+
+/*
+evicts the transactions entry replaces, and then transactions with a lower fee rate than entry until it fits, nothing is evicted when it would not fit anyway
+a transaction leaves together with its descendants, so it is the fee rate of that package that has to be lower
+the ancestors of entry are never evicted as entry could not be added without them
+*/
+func (mp *Mempool) makeRoom(entry *Entry, replaced map[string]bool) error {
+	keep := mp.ancestors(entry.parents)
+	evict := make(map[string]bool)
+	freed := 0
+	for id := range replaced {
+		evict[id] = true
+		freed += mp.entries[id].Size
+	}
+	for _, candidate := range mp.sorted() {
+		if mp.config.MaxSize <= 0 || mp.size-freed+entry.Size <= mp.config.MaxSize {
+			break
+		}
+		id := hex.EncodeToString(candidate.Tx.ID)
+		if evict[id] || keep[id] {
+			continue
+		}
+		pkg := mp.descendants(id)
+		pkg[id] = true
+		for member := range pkg {
+			if evict[member] || keep[member] {
+				delete(pkg, member)
+			}
+		}
+		if !lowerFeeRate(mp.packageOf(pkg), entry) {
+			return ErrMempoolFull
+		}
+		for member := range pkg {
+			evict[member] = true
+			freed += mp.entries[member].Size
+		}
+	}
+	if mp.config.MaxSize > 0 && mp.size-freed+entry.Size > mp.config.MaxSize {
+		return ErrMempoolFull
+	}
+	for id := range evict {
+		mp.remove(id)
+	}
+	return nil
+}
+
+func (mp *Mempool) add(entry *Entry) {
+	id := hex.EncodeToString(entry.Tx.ID)
+	mp.entries[id] = entry
+	for _, in := range entry.Tx.Inputs {
+		mp.spends[outpoint(in.ID, in.Out)] = id
+	}
+	for parent := range entry.parents {
+		mp.entries[parent].children[id] = true
+	}
+	mp.size += entry.Size
+}
+
+//removes id and every transaction depending on it, returns how many left the pool
+func (mp *Mempool) remove(id string) int {
+	if _, ok := mp.entries[id]; !ok {
+		return 0
+	}
+	removed := 0
+	for child := range mp.entries[id].children {
+		removed += mp.remove(child)
+	}
+	mp.unlink(id)
+	return removed + 1
+}
+
+//removes only id, its children stay and no longer have it as a parent, that is what happens when id gets mined
+func (mp *Mempool) unlink(id string) {
+	entry, ok := mp.entries[id]
+	if !ok {
+		return
+	}
+	for _, in := range entry.Tx.Inputs {
+		delete(mp.spends, outpoint(in.ID, in.Out))
+	}
+	for parent := range entry.parents {
+		if parentEntry, ok := mp.entries[parent]; ok {
+			delete(parentEntry.children, id)
+		}
+	}
+	for child := range entry.children {
+		delete(mp.entries[child].parents, id)
+	}
+	delete(mp.entries, id)
+	mp.size -= entry.Size
+}
+
+//entries from the lowest fee rate to the highest, older ones first when the rates are the same
+func (mp *Mempool) sorted() []*Entry {
+	var entries []*Entry
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if lowerFeeRate(entries[i], entries[j]) || lowerFeeRate(entries[j], entries[i]) {
+			return lowerFeeRate(entries[i], entries[j])
+		}
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries
+}
+
+func (mp *Mempool) expire(now time.Time) int {
+	if mp.config.Expiry <= 0 {
+		return 0
+	}
+	expired := 0
+	for id, entry := range mp.entries {
+		if now.Sub(entry.Time) > mp.config.Expiry {
+			expired += mp.remove(id)
+		}
+	}
+	return expired
+}
+
+//drops the transactions that have been in the pool for longer than the expiry, returns how many
+func (mp *Mempool) Expire() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.expire(time.Now())
+}
+
+/*
+called once a block is added to the chain
+its transactions leave the pool, and so does every pool transaction that spends an output one of them spent as it can never be mined now
+children of the mined transactions stay, their parents are confirmed now
+*/
+func (mp *Mempool) BlockConnected(block *blockchain.Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, tx := range block.Transactions {
+		mp.unlink(hex.EncodeToString(tx.ID))
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if spender, ok := mp.spends[outpoint(in.ID, in.Out)]; ok {
+				mp.remove(spender)
+			}
+		}
+	}
+}
+
+/*
+called once the chain moved to another branch and the UTXO set belongs to the new one, disconnected are the blocks that left it with the old last block first
+the pool is built again on the new branch: the transactions of the blocks that left go in first, oldest block first, then the ones that were in the pool
+every one of them is checked again, so whatever the new branch mined, spends an output that no longer exists or conflicts with the new branch drops out
+returns how many transactions are in the pool afterwards and how many were dropped
+*/
+func (mp *Mempool) Reorganized(disconnected []*blockchain.Block) (int, int) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	var candidates []*Entry
+	now := time.Now()
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions {
+			if !tx.IsCoinbase() {
+				candidates = append(candidates, &Entry{Tx: tx, Time: now})
+			}
+		}
+	}
+	var ids []string
+	for id := range mp.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	picked := make(map[string]bool)
+	for _, id := range ids {
+		for _, tx := range mp.parentsFirst(id, picked) {
+			candidates = append(candidates, mp.entries[hex.EncodeToString(tx.ID)])
+		}
+	}
+
+	mp.entries = make(map[string]*Entry)
+	mp.spends = make(map[string]string)
+	mp.size = 0
+	dropped := 0
+	for _, candidate := range candidates {
+		entry, replaced, err := mp.check(candidate.Tx)
+		if err != nil || len(replaced) > 0 {
+			dropped++
+			continue
+		}
+		entry.Time = candidate.Time
+		if err := mp.makeRoom(entry, nil); err != nil {
+			dropped++
+			continue
+		}
+		mp.add(entry)
+	}
+	return len(mp.entries), dropped
+}
+
+func (mp *Mempool) Has(id []byte) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	_, ok := mp.entries[hex.EncodeToString(id)]
+	return ok
+}
+
+func (mp *Mempool) Get(id []byte) (*blockchain.Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	entry, ok := mp.entries[hex.EncodeToString(id)]
+	if !ok {
+		return nil, false
+	}
+	return entry.Tx, true
+}
+
+func (mp *Mempool) Entry(id []byte) (Entry, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	entry, ok := mp.entries[hex.EncodeToString(id)]
+	if !ok {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+//the ID of the pool transaction spending txID:out
+func (mp *Mempool) Spender(txID []byte, out int) ([]byte, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	spender, ok := mp.spends[outpoint(txID, out)]
+	if !ok {
+		return nil, false
+	}
+	id, _ := hex.DecodeString(spender)
+	return id, true
+}
+
+//copies of every entry, the best paying first
+func (mp *Mempool) Entries() []Entry {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	sorted := mp.sorted()
+	entries := make([]Entry, len(sorted))
+	for i, entry := range sorted {
+		entries[len(sorted)-1-i] = *entry
+	}
+	return entries
+}
+
+/*
+the transactions to put into the next block, the best paying first and every transaction after the pool transactions it spends from
+they are picked by the fee rate of a transaction together with its ancestors not picked yet, so a child paying a high fee pulls its parents in with it
+*/
+func (mp *Mempool) Transactions() []*blockchain.Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	var txs []*blockchain.Transaction
+	picked := make(map[string]bool)
+	for len(picked) < len(mp.entries) {
+		var best *Entry
+		var bestPkg map[string]bool
+		for _, candidate := range mp.sorted() {
+			id := hex.EncodeToString(candidate.Tx.ID)
+			if picked[id] {
+				continue
+			}
+			pkg := mp.ancestors(map[string]bool{id: true})
+			for member := range pkg {
+				if picked[member] {
+					delete(pkg, member)
+				}
+			}
+			//sorted puts older ones first on the same rate, so only a strictly better package replaces the best
+			if best == nil || lowerFeeRate(mp.packageOf(bestPkg), mp.packageOf(pkg)) {
+				best, bestPkg = candidate, pkg
+			}
+		}
+		txs = append(txs, mp.parentsFirst(hex.EncodeToString(best.Tx.ID), picked)...)
+	}
+	return txs
+}
+
+//id after its ancestors that are not in picked yet, marks them all as picked
+func (mp *Mempool) parentsFirst(id string, picked map[string]bool) []*blockchain.Transaction {
+	if picked[id] {
+		return nil
+	}
+	picked[id] = true
+	entry := mp.entries[id]
+	var parents []string
+	for parent := range entry.parents {
+		parents = append(parents, parent)
+	}
+	sort.Strings(parents)
+	var txs []*blockchain.Transaction
+	for _, parent := range parents {
+		txs = append(txs, mp.parentsFirst(parent, picked)...)
+	}
+	return append(txs, entry.Tx)
+}
+
+//the pool transactions id depends on, the ones to be mined first at the front
+func (mp *Mempool) Ancestors(id []byte) []Entry {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	key := hex.EncodeToString(id)
+	if _, ok := mp.entries[key]; !ok {
+		return nil
+	}
+	var ancestors []Entry
+	for _, tx := range mp.parentsFirst(key, make(map[string]bool)) {
+		if txID := hex.EncodeToString(tx.ID); txID != key {
+			ancestors = append(ancestors, *mp.entries[txID])
+		}
+	}
+	return ancestors
+}
+
+//the pool transactions that depend on id and leave the pool when it does
+func (mp *Mempool) Descendants(id []byte) []Entry {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	var descendants []Entry
+	for child := range mp.descendants(hex.EncodeToString(id)) {
+		descendants = append(descendants, *mp.entries[child])
+	}
+	return descendants
+}
+
+//the outputs of pool transactions locked to pubKeyHash, whether another pool transaction spends them or not
+func (mp *Mempool) PendingOutputs(pubKeyHash []byte) []blockchain.SpendableOutput {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	var spendable []blockchain.SpendableOutput
+	for _, entry := range mp.sorted() {
+		for i, out := range entry.Tx.Outputs {
+			if out.IsLockedWithKey(pubKeyHash) {
+				spendable = append(spendable, blockchain.SpendableOutput{entry.Tx.ID, i, out})
+			}
+		}
+	}
+	return spendable
+}
+
+//output out of pool transaction txID
+func (mp *Mempool) PendingOutput(txID []byte, out int) (blockchain.TxOutput, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	entry, ok := mp.entries[hex.EncodeToString(txID)]
+	if !ok || out < 0 || out >= len(entry.Tx.Outputs) {
+		return blockchain.TxOutput{}, false
+	}
+	return entry.Tx.Outputs[out], true
+}
+
+func (mp *Mempool) Count() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return len(mp.entries)
+}
+
+type Info struct {
+	Count      int
+	Size       int
+	MaxSize    int
+	TotalFee   int
+	MinFeeRate int //fee per 1000 bytes of the cheapest transaction in the pool
+}
+
+func (mp *Mempool) Info() Info {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	info := Info{Count: len(mp.entries), Size: mp.size, MaxSize: mp.config.MaxSize}
+	for _, entry := range mp.entries {
+		info.TotalFee += entry.Fee
+	}
+	if sorted := mp.sorted(); len(sorted) > 0 {
+		info.MinFeeRate = sorted[0].FeeRate()
+	}
+	return info
+}
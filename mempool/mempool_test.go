@@ -0,0 +1,343 @@
+package mempool
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//a new chain in a temporary directory whose genesis pays owner, the working directory is restored when the test ends
+func newTestChain(t *testing.T, owner *wallet.Wallet) *blockchain.Blockchain {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".tmp", "blocks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	chain := blockchain.InitBlockchain(string(owner.Address()))
+	t.Cleanup(func() {
+		chain.Database.Close()
+		os.Chdir(cwd)
+	})
+	blockchain.UTXOSet{chain}.Reindex()
+	return chain
+}
+
+//a transaction of w spending output out of prev and paying all of it to to
+func spendTx(w *wallet.Wallet, prev *blockchain.Transaction, out int, to string) *blockchain.Transaction {
+	return payTx(w, prev, out, to, 0)
+}
+
+//like spendTx but leaving fee of the output to the miner
+func payTx(w *wallet.Wallet, prev *blockchain.Transaction, out int, to string, fee int) *blockchain.Transaction {
+	in := blockchain.TxInput{prev.ID, out, nil, w.PublicKey, blockchain.SequenceFinal, nil}
+	tx := blockchain.Transaction{nil, []blockchain.TxInput{in}, []blockchain.TxOutput{*blockchain.NewTXOutput(prev.Outputs[out].Value-fee, to)}, 0}
+	blockchain.Handle(tx.SignInput(0, w, prev.Outputs[out]))
+	tx.ID = tx.Hash()
+	return &tx
+}
+
+//a pool on a chain that mined the genesis output of owner split into n outputs of owner, returned is the transaction splitting it
+func fundedPool(t *testing.T, owner *wallet.Wallet, config Config, n int) (*Mempool, *blockchain.Transaction) {
+	chain := newTestChain(t, owner)
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := genesis.Transactions[0]
+	in := blockchain.TxInput{coinbase.ID, 0, nil, owner.PublicKey, blockchain.SequenceFinal, nil}
+	split := blockchain.Transaction{nil, []blockchain.TxInput{in}, nil, 0}
+	for i := 0; i < n; i++ {
+		split.Outputs = append(split.Outputs, *blockchain.NewTXOutput(coinbase.Outputs[0].Value/n, string(owner.Address())))
+	}
+	blockchain.Handle(split.SignInput(0, owner, coinbase.Outputs[0]))
+	split.ID = split.Hash()
+	mined := chain.MineBlock([]*blockchain.Transaction{blockchain.CoinbaseTx(string(owner.Address()), ""), &split})
+	UTXOSet := blockchain.UTXOSet{chain}
+	UTXOSet.Update(mined)
+	mp := New(chain, config)
+	mp.BlockConnected(mined)
+	return mp, &split
+}
+
+//a block on top of parent with a timestamp a second after it, so blocks of a side branch can be made right away
+func sideBlock(parent *blockchain.Block, owner *wallet.Wallet, txs ...*blockchain.Transaction) *blockchain.Block {
+	txs = append([]*blockchain.Transaction{blockchain.CoinbaseTx(string(owner.Address()), "")}, txs...)
+	block := &blockchain.Block{parent.Timestamp + 1, []byte{}, txs, parent.Hash, 0, parent.Height + 1}
+	nonce, hash := blockchain.NewProof(block).Run()
+	block.Nonce, block.Hash = nonce, hash
+	return block
+}
+
+/*
+our chain mines tx1 on top of genesis and the pool holds tx2 spending it
+then a side branch of two blocks from genesis becomes the chain, with extra in its second block
+*/
+func reorganize(t *testing.T, extra func(owner *wallet.Wallet, coinbase *blockchain.Transaction) []*blockchain.Transaction) (*Mempool, *blockchain.Transaction, *blockchain.Transaction) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, owner)
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := genesis.Transactions[0]
+	UTXOSet := blockchain.UTXOSet{chain}
+	mp := New(chain, DefaultConfig)
+
+	tx1 := spendTx(owner, coinbase, 0, string(other.Address()))
+	mined := chain.MineBlock([]*blockchain.Transaction{blockchain.CoinbaseTx(string(owner.Address()), ""), tx1})
+	UTXOSet.Update(mined)
+	mp.BlockConnected(mined)
+	tx2 := spendTx(other, tx1, 0, string(owner.Address()))
+	if err := mp.Accept(tx2); err != nil {
+		t.Fatal(err)
+	}
+
+	side := sideBlock(&genesis, owner)
+	next := sideBlock(side, owner, extra(owner, coinbase)...)
+	for _, block := range []*blockchain.Block{side, next} {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	disconnected, connected, err := chain.Branches(mined.Hash, next.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UTXOSet.Reorganize(disconnected, connected); err != nil {
+		t.Fatal(err)
+	}
+	mp.Reorganized(disconnected)
+	return mp, tx1, tx2
+}
+
+func TestReorganizedPutsTransactionsBack(t *testing.T) {
+	mp, tx1, tx2 := reorganize(t, func(*wallet.Wallet, *blockchain.Transaction) []*blockchain.Transaction { return nil })
+	if mp.Count() != 2 || !mp.Has(tx1.ID) || !mp.Has(tx2.ID) {
+		t.Fatalf("the pool has %d transactions, expected the one of the block that left and the one spending it", mp.Count())
+	}
+	ancestors := mp.Ancestors(tx2.ID)
+	if len(ancestors) != 1 || string(ancestors[0].Tx.ID) != string(tx1.ID) {
+		t.Fatal("the transaction put back is not the parent of the one spending it")
+	}
+}
+
+func TestReorganizedDropsWhatTheNewBranchSpent(t *testing.T) {
+	mp, _, _ := reorganize(t, func(owner *wallet.Wallet, coinbase *blockchain.Transaction) []*blockchain.Transaction {
+		//the new branch spends the genesis output that tx1 spent on the old one
+		return []*blockchain.Transaction{spendTx(owner, coinbase, 0, string(owner.Address()))}
+	})
+	if mp.Count() != 0 {
+		t.Fatalf("the pool has %d transactions, expected the conflicting one and its child to be dropped", mp.Count())
+	}
+}
+
+func TestAcceptRejectsConflictingSpend(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	mp, funds := fundedPool(t, owner, DefaultConfig, 1)
+	first := payTx(owner, funds, 0, string(other.Address()), 1)
+	if err := mp.Accept(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Accept(first); err != ErrAlreadyKnown {
+		t.Fatalf("the same transaction accepted twice gave %v", err)
+	}
+
+	//spends the same output and pays more, but the first one does not signal that it can be replaced
+	second := payTx(owner, funds, 0, string(owner.Address()), 5)
+	if err := mp.Accept(second); err == nil || !strings.Contains(err.Error(), "does not signal") {
+		t.Fatalf("a conflicting spend was accepted with %v", err)
+	}
+	if mp.Count() != 1 || !mp.Has(first.ID) {
+		t.Fatal("the pool lost the first spend to a conflicting one")
+	}
+	if spender, ok := mp.Spender(funds.ID, 0); !ok || !bytes.Equal(spender, first.ID) {
+		t.Fatal("the output is not indexed as spent by the first transaction")
+	}
+}
+
+func TestAcceptRejectsInvalidTransactions(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	mp, funds := fundedPool(t, owner, DefaultConfig, 1)
+	prevOut := funds.Outputs[0]
+
+	//signed by a key the output is not locked to
+	stolen := payTx(other, funds, 0, string(other.Address()), 0)
+	if err := mp.Accept(stolen); err != ErrBadSignature {
+		t.Fatalf("a spend signed with another key gave %v", err)
+	}
+
+	//the output raised after signing, the ID is made to match but the signature is not
+	tampered := payTx(owner, funds, 0, string(other.Address()), 1)
+	tampered.Outputs[0].Value = prevOut.Value
+	tampered.ID = tampered.Hash()
+	if err := mp.Accept(tampered); err != ErrBadSignature {
+		t.Fatalf("a changed transaction gave %v", err)
+	}
+	tampered.ID = funds.ID
+	if err := mp.Accept(tampered); err != ErrIDMismatch {
+		t.Fatalf("a transaction with another ID gave %v", err)
+	}
+
+	overspend := payTx(owner, funds, 0, string(other.Address()), -1)
+	if err := mp.Accept(overspend); err == nil || !strings.Contains(err.Error(), "inputs are only worth") {
+		t.Fatalf("a transaction spending more than its inputs gave %v", err)
+	}
+
+	missing := payTx(owner, funds, 0, string(other.Address()), 0)
+	missing.Inputs[0].Out = len(funds.Outputs)
+	missing.ID = missing.Hash()
+	if _, ok := mp.Accept(missing).(MissingOutputError); !ok {
+		t.Fatal("a spend of an output that does not exist was not reported as missing")
+	}
+	if mp.Count() != 0 {
+		t.Fatalf("the pool has %d transactions, expected every invalid one to be refused", mp.Count())
+	}
+}
+
+func TestMakeRoomEvictsLowestFeeRate(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	mp, funds := fundedPool(t, owner, DefaultConfig, 4)
+	var txs []*blockchain.Transaction
+	size := 0
+	for out, fee := range []int{1, 4, 3, 2} {
+		tx := payTx(owner, funds, out, string(other.Address()), fee)
+		if len(tx.Serialize()) > size {
+			size = len(tx.Serialize())
+		}
+		txs = append(txs, tx)
+	}
+	//room for two of them, all of them are about the same size
+	mp.config.MaxSize = 2*size + size/2
+
+	for _, tx := range txs[:2] {
+		if err := mp.Accept(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mp.Accept(txs[2]); err != nil {
+		t.Fatal(err)
+	}
+	if mp.Count() != 2 || mp.Has(txs[0].ID) || !mp.Has(txs[1].ID) || !mp.Has(txs[2].ID) {
+		t.Fatal("the full pool did not evict the transaction paying the lowest fee rate")
+	}
+	if err := mp.Accept(txs[3]); err != ErrMempoolFull {
+		t.Fatalf("a transaction paying less than everything in the full pool gave %v", err)
+	}
+	if mp.Count() != 2 || mp.Info().Size > mp.config.MaxSize {
+		t.Fatalf("the pool has %d transactions of %d bytes, at most %d fit", mp.Count(), mp.Info().Size, mp.config.MaxSize)
+	}
+}
+
+func TestMakeRoomEvictsDescendantsWithTheirParent(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	mp, funds := fundedPool(t, owner, DefaultConfig, 2)
+	parent := payTx(owner, funds, 0, string(owner.Address()), 1)
+	//the parent alone pays the lowest rate, the package it leaves with pays less than the one coming in too
+	child := payTx(owner, parent, 0, string(other.Address()), 2)
+	richer := payTx(owner, funds, 1, string(other.Address()), 5)
+	mp.config.MaxSize = len(parent.Serialize()) + len(child.Serialize()) + len(richer.Serialize())/2
+
+	for _, tx := range []*blockchain.Transaction{parent, child, richer} {
+		if err := mp.Accept(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mp.Count() != 1 || !mp.Has(richer.ID) {
+		t.Fatal("the child was left in the pool without the parent it spends")
+	}
+}
+
+func TestAncestorAndDescendantLimits(t *testing.T) {
+	owner := wallet.MakeWallet()
+	//a chain of four transactions each spending the one before it
+	chainOf := func(mp *Mempool, funds *blockchain.Transaction) []*blockchain.Transaction {
+		txs := []*blockchain.Transaction{payTx(owner, funds, 0, string(owner.Address()), 0)}
+		for len(txs) < 4 {
+			txs = append(txs, payTx(owner, txs[len(txs)-1], 0, string(owner.Address()), 0))
+		}
+		for _, tx := range txs[:3] {
+			if err := mp.Accept(tx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return txs
+	}
+
+	mp, funds := fundedPool(t, owner, Config{MaxAncestors: 3}, 1)
+	txs := chainOf(mp, funds)
+	if err := mp.Accept(txs[3]); err == nil || !strings.Contains(err.Error(), "unconfirmed ancestors") {
+		t.Fatalf("a fourth transaction in a chain limited to three gave %v", err)
+	}
+	if ancestors := mp.Ancestors(txs[2].ID); len(ancestors) != 2 {
+		t.Fatalf("the last transaction of the chain has %d ancestors, expected 2", len(ancestors))
+	}
+
+	mp, funds = fundedPool(t, owner, Config{MaxDescendants: 3}, 1)
+	txs = chainOf(mp, funds)
+	if err := mp.Accept(txs[3]); err == nil || !strings.Contains(err.Error(), "most descendants") {
+		t.Fatalf("a fourth descendant of a transaction limited to three gave %v", err)
+	}
+	if descendants := mp.Descendants(txs[0].ID); len(descendants) != 2 || mp.Count() != 3 {
+		t.Fatalf("the first transaction of the chain has %d descendants, expected 2", len(descendants))
+	}
+}
+
+func TestLoadDropsWhatTheChainMinedOrConflicted(t *testing.T) {
+	owner, other := wallet.MakeWallet(), wallet.MakeWallet()
+	mp, funds := fundedPool(t, owner, DefaultConfig, 3)
+	mined := payTx(owner, funds, 0, string(other.Address()), 1)
+	conflicted := payTx(owner, funds, 1, string(other.Address()), 1)
+	child := payTx(other, conflicted, 0, string(owner.Address()), 1)
+	kept := payTx(owner, funds, 2, string(other.Address()), 1)
+	keptChild := payTx(other, kept, 0, string(owner.Address()), 1)
+	for _, tx := range []*blockchain.Transaction{mined, conflicted, child, kept, keptChild} {
+		if err := mp.Accept(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	accepted, _ := mp.Entry(kept.ID)
+	path := filepath.Join(t.TempDir(), "mempool.data")
+	if err := mp.Dump(path); err != nil {
+		t.Fatal(err)
+	}
+
+	//while the node was down a block mined one of the transactions and another spend of what a second one spends
+	chain := mp.chain
+	block := chain.MineBlock([]*blockchain.Transaction{blockchain.CoinbaseTx(string(owner.Address()), ""), mined, payTx(owner, funds, 1, string(owner.Address()), 2)})
+	UTXOSet := blockchain.UTXOSet{chain}
+	UTXOSet.Update(block)
+
+	restarted := New(chain, DefaultConfig)
+	loaded, dropped, err := restarted.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 2 || dropped != 3 {
+		t.Fatalf("loaded %d and dropped %d transactions, expected 2 and 3", loaded, dropped)
+	}
+	if !restarted.Has(kept.ID) || !restarted.Has(keptChild.ID) || restarted.Count() != 2 {
+		t.Fatal("the transactions the block left valid are not back in the pool")
+	}
+	if entry, ok := restarted.Entry(kept.ID); !ok || !entry.Time.Equal(accepted.Time) {
+		t.Fatal("a loaded transaction did not keep the time it was first accepted")
+	}
+	if ancestors := restarted.Ancestors(keptChild.ID); len(ancestors) != 1 || !bytes.Equal(ancestors[0].Tx.ID, kept.ID) {
+		t.Fatal("the loaded child does not spend its loaded parent")
+	}
+
+	//a missing file is an empty pool
+	if loaded, dropped, err := New(chain, DefaultConfig).Load(filepath.Join(t.TempDir(), "missing.data")); loaded != 0 || dropped != 0 || err != nil {
+		t.Fatalf("a missing file loaded %d, dropped %d with %v", loaded, dropped, err)
+	}
+}
@@ -0,0 +1,111 @@
+package mempool
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+)
+
+/*
+the pool is written to a file so the transactions in it survive a restart of the node
+every transaction is stored with the time it was accepted and after the pool transactions it spends from
+loading the file accepts them again in that order, so whatever the chain made invalid in the meantime is dropped like any other invalid transaction
+*/
+const dumpFileVersion = 1
+
+//the file the node with nodeID keeps its mempool in
+func File(nodeID string) string {
+	return fmt.Sprintf(".tmp/mempool_%s.data", nodeID)
+}
+
+type dumpFileContent struct {
+	Version int
+	Entries []dumpEntry
+}
+
+type dumpEntry struct {
+	Tx   *blockchain.Transaction
+	Time time.Time
+}
+
+//writes every transaction in the pool to path, the file is replaced atomically so a crash while writing keeps the last dump
+func (mp *Mempool) Dump(path string) error {
+	mp.mu.Lock()
+	var oldest []*Entry
+	for _, entry := range mp.entries {
+		oldest = append(oldest, entry)
+	}
+	sort.Slice(oldest, func(i, j int) bool { return oldest[i].Time.Before(oldest[j].Time) })
+	content := dumpFileContent{Version: dumpFileVersion}
+	picked := make(map[string]bool)
+	for _, entry := range oldest {
+		for _, tx := range mp.parentsFirst(hex.EncodeToString(entry.Tx.ID), picked) {
+			content.Entries = append(content.Entries, dumpEntry{tx, mp.entries[hex.EncodeToString(tx.ID)].Time})
+		}
+	}
+	mp.mu.Unlock()
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(content); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buffer.Bytes(), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+/*
+accepts the transactions of a file written by Dump, a missing file is an empty pool
+returns how many transactions are back in the pool and how many were dropped because they expired or are no longer valid
+*/
+func (mp *Mempool) Load(path string) (int, int, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var content dumpFileContent
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&content); err != nil {
+		return 0, 0, err
+	}
+	if content.Version != dumpFileVersion {
+		return 0, 0, fmt.Errorf("Mempool file has version %d, expected %d", content.Version, dumpFileVersion)
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	now := time.Now()
+	loaded, dropped := 0, 0
+	for _, saved := range content.Entries {
+		if saved.Tx == nil || (mp.config.Expiry > 0 && now.Sub(saved.Time) > mp.config.Expiry) {
+			dropped++
+			continue
+		}
+		//a transaction in the file never conflicts with another one in it, a conflict means the pool got it since the node started
+		entry, replaced, err := mp.check(saved.Tx)
+		if err != nil || len(replaced) > 0 {
+			dropped++
+			continue
+		}
+		entry.Time = saved.Time
+		if err := mp.makeRoom(entry, nil); err != nil {
+			dropped++
+			continue
+		}
+		mp.add(entry)
+		loaded++
+	}
+	return loaded, dropped, nil
+}
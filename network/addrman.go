@@ -0,0 +1,308 @@
+package network
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+peer discovery
+a node starts from its seeds and learns about the other nodes from the addr messages of its peers, asking them with getaddr
+every address it hears about goes into a new bucket, once a node answered our version it moves to a tried bucket
+the bucket of a new address depends on the peer that told us about it, so one peer sending lots of addresses can only fill a few of them
+a full bucket makes room by dropping the address that was seen the longest ago, a full tried bucket sends it back to the new ones
+*/
+const (
+	newBucketCount    = 64
+	triedBucketCount  = 16
+	bucketSize        = 32
+	bucketsPerSource  = 8                  //new buckets the addresses from a single peer can end up in
+	maxAddrPerMessage = 1000               //addresses in one addr message
+	maxOutboundPeers  = 8                  //peers we connect to ourselves, others can still connect to us
+	peerInterval      = 15 * time.Second   //how often we look for more peers and ask ours for addresses
+	addrHorizon       = 7 * 24 * time.Hour //an address not seen for longer is forgotten
+	maxAttempts       = 3                  //failed connections before an address that never worked is forgotten
+	freshAddr         = 10 * time.Minute   //addresses seen more recently than this are passed on when we hear about them
+	seedSource        = "seed"             //source of the addresses from the seed list
+)
+
+//an address with the last time its node was known to be up
+type PeerAddress struct {
+	Addr      string
+	Timestamp time.Time
+}
+
+type knownAddress struct {
+	PeerAddress
+	source      string
+	lastTried   time.Time
+	lastSuccess time.Time
+	attempts    int
+	tried       bool
+	bucket      int
+}
+
+type addrManager struct {
+	mu    sync.Mutex
+	addrs map[string]*knownAddress
+	new   [newBucketCount]map[string]bool
+	tried [triedBucketCount]map[string]bool
+}
+
+var (
+	Seeds   = []string{"localhost:3000"} //addresses a node starts from, set them before StartServer to take them from a flag
+	addrman = newAddrManager()
+)
+
+func newAddrManager() *addrManager {
+	am := &addrManager{addrs: make(map[string]*knownAddress)}
+	for i := range am.new {
+		am.new[i] = make(map[string]bool)
+	}
+	for i := range am.tried {
+		am.tried[i] = make(map[string]bool)
+	}
+	return am
+}
+
+//the seed list of a config file, one address per line, empty lines and lines starting with # are skipped
+func LoadSeeds(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var seeds []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		seeds = append(seeds, line)
+	}
+	return seeds, scanner.Err()
+}
+
+//the seeds of the node with nodeID, its seed file when it has one and fallback otherwise
+func seedAddresses(nodeID string, fallback []string) []PeerAddress {
+	seeds := fallback
+	seedFile := fmt.Sprintf(".tmp/seeds_%s.txt", nodeID)
+	if fileSeeds, err := LoadSeeds(seedFile); err == nil {
+		seeds = fileSeeds
+	} else if !os.IsNotExist(err) {
+		fmt.Printf("Could not read the seeds in %s: %s\n", seedFile, err)
+	}
+	var seedAddrs []PeerAddress
+	for _, seed := range seeds {
+		seedAddrs = append(seedAddrs, PeerAddress{seed, time.Now()})
+	}
+	return seedAddrs
+}
+
+func hashIndex(count int, parts ...string) int {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "/")))
+	return int(binary.BigEndian.Uint32(hash[:4]) % uint32(count))
+}
+
+func newBucket(addr, source string) int {
+	return hashIndex(newBucketCount, source, strconv.Itoa(hashIndex(bucketsPerSource, addr)))
+}
+
+func triedBucket(addr string) int {
+	return hashIndex(triedBucketCount, addr)
+}
+
+//adds the addresses source told us about, returns the ones we did not know yet, banned addresses are skipped
+func (am *addrManager) Add(addrs []PeerAddress, source string) []PeerAddress {
+	//checked before taking the lock as a host name has to be resolved first
+	banned := make(map[string]bool)
+	for _, addr := range addrs {
+		banned[addr.Addr] = addrIsBanned(addr.Addr)
+	}
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	var added []PeerAddress
+	for _, addr := range addrs {
+		if !banned[addr.Addr] && am.add(addr, source) {
+			added = append(added, addr)
+		}
+	}
+	return added
+}
+
+func (am *addrManager) add(addr PeerAddress, source string) bool {
+	now := time.Now()
+	if addr.Addr == "" || addr.Addr == nodeAddress || now.Sub(addr.Timestamp) > addrHorizon {
+		return false
+	}
+	//a node can not have been seen in the future, a peer claiming so would keep its addresses around forever
+	if addr.Timestamp.After(now) {
+		addr.Timestamp = now
+	}
+	if known, ok := am.addrs[addr.Addr]; ok {
+		if addr.Timestamp.After(known.Timestamp) {
+			known.Timestamp = addr.Timestamp
+		}
+		return false
+	}
+	known := &knownAddress{PeerAddress: addr, source: source}
+	am.addrs[addr.Addr] = known
+	am.putNew(known)
+	return true
+}
+
+func (am *addrManager) putNew(known *knownAddress) {
+	known.tried = false
+	known.bucket = newBucket(known.Addr, known.source)
+	bucket := am.new[known.bucket]
+	if len(bucket) >= bucketSize {
+		oldest := am.oldest(bucket, func(ka *knownAddress) time.Time { return ka.Timestamp })
+		delete(bucket, oldest)
+		delete(am.addrs, oldest)
+	}
+	bucket[known.Addr] = true
+}
+
+func (am *addrManager) oldest(bucket map[string]bool, since func(*knownAddress) time.Time) string {
+	var oldest string
+	for addr := range bucket {
+		if oldest == "" || since(am.addrs[addr]).Before(since(am.addrs[oldest])) {
+			oldest = addr
+		}
+	}
+	return oldest
+}
+
+/*
+the node at addr answered us, its address moves to a tried bucket
+only an address we dialed ourselves counts, false when we did not try it since it last answered
+any node can put any address in its version, taking that as working would let it fill the tried buckets with addresses it makes up
+*/
+func (am *addrManager) Good(addr string) bool {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	known, ok := am.addrs[addr]
+	if !ok || !known.lastTried.After(known.lastSuccess) {
+		return false
+	}
+	if !known.tried {
+		delete(am.new[known.bucket], addr)
+	}
+	now := time.Now()
+	known.Timestamp, known.lastSuccess, known.attempts = now, now, 0
+	if known.tried {
+		return true
+	}
+	known.tried = true
+	known.bucket = triedBucket(addr)
+	bucket := am.tried[known.bucket]
+	if len(bucket) >= bucketSize {
+		oldest := am.oldest(bucket, func(ka *knownAddress) time.Time { return ka.lastSuccess })
+		delete(bucket, oldest)
+		am.putNew(am.addrs[oldest])
+	}
+	bucket[addr] = true
+	return true
+}
+
+//we are about to connect to addr, an address that never worked is forgotten after maxAttempts tries, a seed never is
+func (am *addrManager) Attempt(addr string) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	known, ok := am.addrs[addr]
+	if !ok {
+		return
+	}
+	known.lastTried = time.Now()
+	known.attempts++
+	if !known.tried && known.source != seedSource && known.attempts > maxAttempts {
+		am.remove(known)
+	}
+}
+
+func (am *addrManager) remove(known *knownAddress) {
+	if known.tried {
+		delete(am.tried[known.bucket], known.Addr)
+	} else {
+		delete(am.new[known.bucket], known.Addr)
+	}
+	delete(am.addrs, known.Addr)
+}
+
+//forgets the new addresses not seen within addrHorizon
+func (am *addrManager) Expire() {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	for _, known := range am.addrs {
+		if !known.tried && known.source != seedSource && time.Since(known.Timestamp) > addrHorizon {
+			am.remove(known)
+		}
+	}
+}
+
+/*
+up to n addresses to connect to that skip says we are not connected to yet, taking turns between tried and new ones
+an address we tried within the last peerInterval waits for the next round
+*/
+func (am *addrManager) Select(n int, skip func(string) bool) []string {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	var tried, fresh []string
+	for addr, known := range am.addrs {
+		if skip(addr) || time.Since(known.lastTried) < peerInterval {
+			continue
+		}
+		if known.tried {
+			tried = append(tried, addr)
+		} else {
+			fresh = append(fresh, addr)
+		}
+	}
+	rand.Shuffle(len(tried), func(i, j int) { tried[i], tried[j] = tried[j], tried[i] })
+	rand.Shuffle(len(fresh), func(i, j int) { fresh[i], fresh[j] = fresh[j], fresh[i] })
+	var picked []string
+	for len(picked) < n && len(tried)+len(fresh) > 0 {
+		if len(tried) > 0 && (len(picked)%2 == 0 || len(fresh) == 0) {
+			picked, tried = append(picked, tried[0]), tried[1:]
+		} else {
+			picked, fresh = append(picked, fresh[0]), fresh[1:]
+		}
+	}
+	return picked
+}
+
+//a random sample of at most max addresses, the reply to getaddr
+func (am *addrManager) Sample(max int) []PeerAddress {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	var addrs []PeerAddress
+	for _, known := range am.addrs {
+		addrs = append(addrs, known.PeerAddress)
+	}
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	if len(addrs) > max {
+		addrs = addrs[:max]
+	}
+	return addrs
+}
+
+func (am *addrManager) Count() (int, int) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	tried := 0
+	for _, known := range am.addrs {
+		if known.tried {
+			tried++
+		}
+	}
+	return len(am.addrs) - tried, tried
+}
@@ -0,0 +1,188 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/mempool"
+)
+
+//addresses of n nodes that were seen just now, IPs so nothing has to be resolved
+func peerAddresses(prefix string, n int) []PeerAddress {
+	var addrs []PeerAddress
+	for i := 0; i < n; i++ {
+		addrs = append(addrs, PeerAddress{fmt.Sprintf("%s.%d.%d:3000", prefix, i/250, i%250), time.Now()})
+	}
+	return addrs
+}
+
+//fails the test when a bucket is over its size or does not hold exactly the addresses the manager knows
+func checkBuckets(t *testing.T, am *addrManager) {
+	t.Helper()
+	held := 0
+	for _, bucket := range append(am.new[:], am.tried[:]...) {
+		if len(bucket) > bucketSize {
+			t.Fatalf("a bucket holds %d addresses, at most %d fit", len(bucket), bucketSize)
+		}
+		held += len(bucket)
+	}
+	if held != len(am.addrs) {
+		t.Fatalf("the buckets hold %d addresses but %d are known", held, len(am.addrs))
+	}
+}
+
+func TestAddressTableIsCapped(t *testing.T) {
+	resetMisbehavior(t)
+	am := newAddrManager()
+	//one peer sending lots of addresses only fills the few buckets it maps to
+	am.Add(peerAddresses("10.1", 2000), "10.0.0.1:3000")
+	if fresh, _ := am.Count(); fresh == 0 || fresh > bucketsPerSource*bucketSize {
+		t.Fatalf("a single peer got %d addresses in, at most %d are allowed", fresh, bucketsPerSource*bucketSize)
+	}
+	checkBuckets(t, am)
+
+	for i := 0; i < 100; i++ {
+		am.Add(peerAddresses(fmt.Sprintf("10.%d", i+2), 100), fmt.Sprintf("10.0.1.%d:3000", i))
+	}
+	if fresh, _ := am.Count(); fresh > newBucketCount*bucketSize {
+		t.Fatalf("the new buckets hold %d addresses, at most %d fit", fresh, newBucketCount*bucketSize)
+	}
+	checkBuckets(t, am)
+
+	//what was dropped to make room was seen the longest ago, a recent address of a full bucket stays
+	recent := PeerAddress{"10.200.0.1:3000", time.Now()}
+	am.Add([]PeerAddress{recent}, "10.0.1.0:3000")
+	for i := 0; i < 2*bucketSize; i++ {
+		am.Add([]PeerAddress{{fmt.Sprintf("10.201.0.%d:3000", i), time.Now().Add(-time.Hour)}}, "10.0.1.0:3000")
+	}
+	if _, ok := am.addrs[recent.Addr]; !ok {
+		t.Fatal("the most recently seen address was dropped from its bucket")
+	}
+	checkBuckets(t, am)
+}
+
+func TestAddRejectsSelfAndBanned(t *testing.T) {
+	resetMisbehavior(t)
+	self := nodeAddress
+	nodeAddress = "127.0.0.1:3999"
+	t.Cleanup(func() { nodeAddress = self })
+	if err := bans.Ban("10.0.0.9", time.Hour, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	am := newAddrManager()
+	good := PeerAddress{"10.0.0.8:3000", time.Now()}
+	added := am.Add([]PeerAddress{{nodeAddress, time.Now()}, {"10.0.0.9:3000", time.Now()}, good}, "10.0.0.1:3000")
+	if len(added) != 1 || added[0].Addr != good.Addr {
+		t.Fatalf("added %v, expected only %s", added, good.Addr)
+	}
+	am.Good(nodeAddress)
+	if fresh, tried := am.Count(); fresh != 1 || tried != 0 {
+		t.Fatalf("%d new and %d tried addresses, our own address was added", fresh, tried)
+	}
+	if again := am.Add([]PeerAddress{good}, "10.0.0.2:3000"); len(again) != 0 {
+		t.Fatal("an address we know was added again")
+	}
+}
+
+func TestSeedsAreTheFallback(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	resetMisbehavior(t)
+
+	fallback := []string{"127.0.0.1:3000"}
+	if seeds := seedAddresses("3001", fallback); len(seeds) != 1 || seeds[0].Addr != fallback[0] {
+		t.Fatalf("without a seed file the seeds are %v, expected %v", seeds, fallback)
+	}
+	content := "# seeds of the test\n127.0.0.1:3002\n\n  127.0.0.1:3003  \n"
+	if err := ioutil.WriteFile(filepath.Join(".tmp", "seeds_3001.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	seeds := seedAddresses("3001", fallback)
+	if len(seeds) != 2 || seeds[0].Addr != "127.0.0.1:3002" || seeds[1].Addr != "127.0.0.1:3003" {
+		t.Fatalf("the seed file gave %v", seeds)
+	}
+
+	//a seed that never answers is kept to start over from, an address a peer gave us is not
+	am := newAddrManager()
+	am.Add(seeds, seedSource)
+	am.Add([]PeerAddress{{"10.0.0.8:3000", time.Now()}}, "10.0.0.1:3000")
+	for i := 0; i <= maxAttempts; i++ {
+		for _, addr := range []string{"127.0.0.1:3002", "10.0.0.8:3000"} {
+			am.Attempt(addr)
+		}
+	}
+	am.addrs["127.0.0.1:3003"].Timestamp = time.Now().Add(-2 * addrHorizon)
+	am.Expire()
+	if fresh, _ := am.Count(); fresh != 2 {
+		t.Fatalf("%d addresses are left, expected the two seeds", fresh)
+	}
+	if picked := am.Select(2, func(string) bool { return false }); len(picked) != 1 || picked[0] != "127.0.0.1:3003" {
+		t.Fatalf("picked %v, expected the seed that was not tried just now", picked)
+	}
+}
+
+func TestOnlyDialedAddressesAreTried(t *testing.T) {
+	resetMisbehavior(t)
+	am := newAddrManager()
+	addr := "10.0.0.8:3000"
+	am.Add([]PeerAddress{{addr, time.Now()}}, "10.0.0.1:3000")
+	if am.Good(addr) || am.Good("10.0.0.9:3000") {
+		t.Fatal("an address we never dialed was taken as working")
+	}
+	am.Attempt(addr)
+	if !am.Good(addr) {
+		t.Fatal("an address that answered when we dialed it was not taken as working")
+	}
+	if fresh, tried := am.Count(); fresh != 0 || tried != 1 {
+		t.Fatalf("%d new and %d tried addresses, expected the dialed one to be tried", fresh, tried)
+	}
+	if am.Good(addr) {
+		t.Fatal("an address answered twice to one dial")
+	}
+}
+
+func TestInboundVersionIsOnlyAClaim(t *testing.T) {
+	resetMisbehavior(t)
+	chain := newTestChain(t)
+	saved, savedPool := addrman, memoryPool
+	addrman, memoryPool = newAddrManager(), mempool.New(nil, mempool.DefaultConfig)
+	t.Cleanup(func() { addrman, memoryPool = saved, savedPool })
+	dialed, inbound := sink(t), sink(t)
+	t.Cleanup(func() {
+		for _, peer := range []string{dialed, inbound} {
+			removeKnownNode(peer)
+			relay.Forget(peer)
+			syncer.forgetPeer(peer)
+		}
+	})
+	addrman.Add([]PeerAddress{{dialed, time.Now()}}, seedSource)
+	addrman.Attempt(dialed)
+
+	for _, peer := range []string{dialed, inbound} {
+		request := append(CmdToBytes("version"), GobEncode(Version{version, 0, peer})...)
+		if err := HandleVersion(request, chain, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fresh, tried := addrman.Count(); fresh != 1 || tried != 1 {
+		t.Fatalf("%d new and %d tried addresses, expected only the one we dialed to be tried", fresh, tried)
+	}
+	if known, ok := addrman.addrs[inbound]; !ok || known.tried {
+		t.Fatal("the address of a peer that connected to us did not go into a new bucket")
+	}
+}
@@ -0,0 +1,103 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/banlist"
+)
+
+/*
+misbehavior
+a peer that breaks the protocol collects points, a message we can not decode or a block that is invalid is worth banScore at once
+smaller violations like oversized messages or headers that do not connect add up, at banScore the peer is banned for banlist.DefaultBanTime
+a banned peer is dropped from our peers, its messages are ignored and we do not connect to it again until the ban runs out
+peers are scored and banned by their address, the one we dial them at and that they put in their messages
+every message is a connection of its own from a port that changes each time, so the address in the message is the only thing that tells two nodes on one host apart
+banning the IP instead would take every node on localhost down with one bad node, IPs are only banned by the administrator with setban
+scores do not decay, so only what an honest node never does is scored, not what a race between two honest nodes can cause
+*/
+const (
+	banScore          = 100
+	banListInterval   = 5 * time.Second //how often the ban list file is checked for changes made by the CLI
+	scoreMalformed    = banScore        //a message that can not be decoded or crashes its handler
+	scoreInvalid      = banScore        //a block, header or transaction that breaks the consensus rules
+	scoreOversized    = 20              //more items in a message than the protocol allows
+	scoreUnconnecting = 20              //headers that do not follow any we know
+	scoreFalseHeight  = 20              //a best height in version that the peer's headers do not reach
+	scoreUselessInv   = 10              //an inventory with no items or items of a type we do not know
+)
+
+type peerScores struct {
+	mu     sync.Mutex
+	scores map[string]int
+}
+
+var (
+	scores = &peerScores{scores: make(map[string]int)}
+	bans   = banlist.New("") //loaded from the node's file by StartServer
+)
+
+//adds howMuch to the score of the peer with address peer and bans that address when the score reaches banScore
+func Misbehaving(peer string, howMuch int, reason string) {
+	if peer == "" {
+		return
+	}
+	scores.mu.Lock()
+	scores.scores[peer] += howMuch
+	score := scores.scores[peer]
+	if score >= banScore {
+		delete(scores.scores, peer)
+	}
+	scores.mu.Unlock()
+	fmt.Printf("%s misbehaved: %s, score %d\n", peer, reason, score)
+	if score < banScore {
+		return
+	}
+	fmt.Printf("Banning %s\n", peer)
+	if err := bans.Ban(peer, banlist.DefaultBanTime, reason); err != nil {
+		fmt.Printf("Could not save the ban list: %s\n", err)
+	}
+	dropBannedPeers()
+}
+
+//true when the peer at addr is banned or its host is
+func addrIsBanned(addr string) bool {
+	if bans.IsBanned(addr) {
+		return true
+	}
+	ips, err := banlist.PeerIPs(addr)
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if bans.IsBanned(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//drops the banned peers and the peers on banned hosts from everything that would have us talk to them again
+func dropBannedPeers() {
+	for _, node := range peerList() {
+		if addrIsBanned(node) {
+			fmt.Printf("Disconnecting banned %s\n", node)
+			removeKnownNode(node)
+			relay.Forget(node)
+			syncer.forgetPeer(node)
+		}
+	}
+}
+
+//picks up the bans the CLI made while we run and disconnects the peers they are for
+func WatchBanList() {
+	for range time.Tick(banListInterval) {
+		if err := bans.Reload(); err != nil {
+			fmt.Printf("Could not read the ban list: %s\n", err)
+			continue
+		}
+		dropBannedPeers()
+	}
+}
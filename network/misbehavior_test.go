@@ -0,0 +1,149 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/banlist"
+	"github.com/RavjotSandhu/GoBlockchain/mempool"
+)
+
+//forgets every score and ban when the test ends
+func resetMisbehavior(t *testing.T) {
+	reset := func() {
+		scores = &peerScores{scores: make(map[string]int)}
+		bans = banlist.New("")
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func score(peer string) int {
+	scores.mu.Lock()
+	defer scores.mu.Unlock()
+	return scores.scores[peer]
+}
+
+//sends request over a real connection to localhost and handles it like the server would
+func deliver(t *testing.T, request []byte) {
+	ln, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := net.Dial(protocol, ln.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write(request)
+		conn.Close()
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	HandleConnection(conn, nil)
+}
+
+//an address that takes every message and ignores it, a stand-in for the reply address of a peer
+func sink(t *testing.T) string {
+	ln, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+//an inventory from sender with no items, handled it is scored scoreUselessInv
+func uselessInv(sender string) []byte {
+	return append(CmdToBytes("inv"), GobEncode(Inv{sender, "tx", nil})...)
+}
+
+func TestMalformedMessageBansThePeerNotItsHost(t *testing.T) {
+	resetMisbehavior(t)
+	//two nodes on this host, only one of them misbehaves
+	bad, honest := "localhost:9999", "localhost:9998"
+	addKnownNode(honest)
+	t.Cleanup(func() { removeKnownNode(honest) })
+	payload := GobEncode(struct {
+		AddrFrom string
+		Type     string
+		Items    string
+	}{bad, "tx", "not a list"})
+	deliver(t, append(CmdToBytes("inv"), payload...))
+
+	if !bans.IsBanned(bad) {
+		t.Fatal("the peer that sent a message that can not be decoded is not banned")
+	}
+	if bans.IsBanned("127.0.0.1") || !NodeIsKnown(honest) {
+		t.Fatal("the host of the peer was banned along with it")
+	}
+	deliver(t, uselessInv(bad))
+	deliver(t, uselessInv(honest))
+	if score(bad) != 0 || score(honest) != scoreUselessInv {
+		t.Fatalf("scored %d for the banned peer and %d for the other one on its host, expected its messages to be ignored and the other's handled", score(bad), score(honest))
+	}
+}
+
+func TestAdministratorBansTheHost(t *testing.T) {
+	resetMisbehavior(t)
+	if err := bans.Ban("127.0.0.1", time.Hour, "set by the administrator"); err != nil {
+		t.Fatal(err)
+	}
+	deliver(t, uselessInv("localhost:9998"))
+	if score("localhost:9998") != 0 || !addrIsBanned("localhost:9998") {
+		t.Fatal("a message from a banned IP was handled")
+	}
+}
+
+func TestInventorySpamIsScored(t *testing.T) {
+	resetMisbehavior(t)
+	memoryPool = mempool.New(nil, mempool.DefaultConfig)
+	replyTo := sink(t)
+	peer := replyTo
+	//only what our peers have is remembered, the one the inventory comes from has to be one
+	addKnownNode(replyTo)
+	t.Cleanup(func() {
+		removeKnownNode(replyTo)
+		relay.Forget(replyTo)
+	})
+	inv := func(kind string, items ...[]byte) []byte {
+		return append(CmdToBytes("inv"), GobEncode(Inv{replyTo, kind, items})...)
+	}
+	if err := HandleInv(inv("tx", []byte("a")), nil, peer); err != nil {
+		t.Fatal(err)
+	}
+	if score(peer) != 0 {
+		t.Fatalf("a new inventory scored %d", score(peer))
+	}
+	//it crossed ours or got to us twice, honest nodes do that
+	HandleInv(inv("tx", []byte("a")), nil, peer)
+	if score(peer) != 0 {
+		t.Fatalf("a repeated inventory scored %d", score(peer))
+	}
+	HandleInv(inv("tx"), nil, peer)
+	HandleInv(inv("nonsense", []byte("b")), nil, peer)
+	if expected := 2 * scoreUselessInv; score(peer) != expected {
+		t.Fatalf("empty and unknown inventories scored %d in total, expected %d", score(peer), expected)
+	}
+}
+
+func TestUndecodableBlockIsAnError(t *testing.T) {
+	resetMisbehavior(t)
+	request := append(CmdToBytes("block"), GobEncode(Block{"localhost:1", []byte("not a block")})...)
+	if err := HandleBlock(request, nil, "10.0.0.2"); err == nil {
+		t.Fatal("a block that can not be decoded was accepted")
+	}
+}
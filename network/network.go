@@ -8,32 +8,45 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
 	"os"
-	"runtime"
+	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/RavjotSandhu/GoBlockchain/banlist"
 	"github.com/RavjotSandhu/GoBlockchain/blockchain"
-	"gopkg.in/vrecan/death.v3"
+	"github.com/RavjotSandhu/GoBlockchain/mempool"
 )
 
 const (
 	protocol      = "tcp"
 	version       = 1
 	commandLength = 12
+
+	mempoolDumpInterval = 10 * time.Minute //how often the mempool is written to its file besides on shutdown
 )
 
 var (
-	nodeAddress     string
-	mineAddress     string
-	knownNodes      = []string{"localhost:3000"}
-	blocksInTransit = [][]byte{}
-	memoryPool      = make(map[string]blockchain.Transaction)
+	nodeAddress string
+	mineAddress string
+	knownNodes  []string         //peers we are connected to, found through addrman, guarded by nodesMu
+	memoryPool  *mempool.Mempool //set up by StartServer once the chain is open
+	mempoolFile string
+	nodesMu     sync.Mutex
 )
 
 //list of addresses connected to each of node
 type Addr struct {
-	AddrList []string
+	AddrFrom string
+	AddrList []PeerAddress
+}
+
+//asks a peer for the addresses it knows
+type GetAddr struct {
+	AddrFrom string
 }
 
 type Block struct {
@@ -41,9 +54,15 @@ type Block struct {
 	Block    []byte //this is block itself, we would be able to identify where the block is coming from
 }
 
-//fetch the block from node
+/*
+fetch the hashes of the blocks the requester is missing
+Locator holds hashes of its chain from its tip back (see Blockchain.Locator), the reply starts after the first one on our chain
+it ends at Stop, or after maxInvPerMessage hashes, whichever comes first, a nil Stop asks for as many as fit
+*/
 type GetBlocks struct {
 	AddrFrom string
+	Locator  [][]byte
+	Stop     []byte
 }
 
 type GetData struct {
@@ -87,13 +106,29 @@ func BytesToCmd(bytes []byte) string {
 	return fmt.Sprintf("%s", cmd)
 }
 
+//waits for the node to be interrupted and closes the database properly before it exits, a block being connected is finished first
 func CloseDB(chain *blockchain.Blockchain) {
-	d := death.NewDeath(syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-	d.WaitForDeathWithFunc(func() {
-		defer os.Exit(1)
-		defer runtime.Goexit()
-		chain.Database.Close()
-	})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+	<-interrupt
+	syncer.mu.Lock()
+	DumpMempool()
+	chain.Database.Close()
+	os.Exit(1)
+}
+
+//writes the mempool to its file so the transactions in it are still there when the node starts again
+func DumpMempool() {
+	if err := memoryPool.Dump(mempoolFile); err != nil {
+		fmt.Printf("Could not save the mempool: %s\n", err)
+	}
+}
+
+//dumps the mempool every interval, a node that is killed without a chance to shut down only loses what came in since the last dump
+func DumpMempoolPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		DumpMempool()
+	}
 }
 
 //we would be using it to send commands to and fro and blocks,transactions,all our structs
@@ -107,55 +142,98 @@ func GobEncode(data interface{}) []byte {
 	return buff.Bytes()
 }
 
+//the IP conn comes from, what the bans of the administrator are checked against
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+/*
+the address of the peer that sent request, the one it is scored and banned by
+every message starts with the address of its sender, a message too broken to have one is put on the connection, which is never seen again
+*/
+func senderAddress(request []byte, conn net.Conn) string {
+	var sender struct{ AddrFrom string }
+	if err := decodePayload(request, &sender); err != nil || sender.AddrFrom == "" {
+		return conn.RemoteAddr().String()
+	}
+	return sender.AddrFrom
+}
+
+//decodes the payload of request into payload, a peer sending something else gets scoreMalformed for it
+func decodePayload(request []byte, payload interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(payload)
+}
+
 func HandleConnection(conn net.Conn, chain *blockchain.Blockchain) {
 	req, err := ioutil.ReadAll(conn)
 	defer conn.Close()
-	if err != nil {
-		log.Panic(err)
+	if err != nil || len(req) < commandLength {
+		return
 	}
 	command := BytesToCmd(req[:commandLength])
+	peer := senderAddress(req, conn)
+	if ip := remoteIP(conn); bans.IsBanned(ip) || bans.IsBanned(peer) {
+		fmt.Printf("Ignoring %s command from banned %s at %s\n", command, peer, ip)
+		return
+	}
+	//a handler that crashes is our bug rather than the peer's, it is not held against the peer but must not take the node down either
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Could not handle %s command from %s: %v\n", command, peer, r)
+		}
+	}()
 	fmt.Printf("Received %s command\n", command)
 	switch command {
 	case "addr":
-		HandleAddr(req)
+		err = HandleAddr(req, chain, peer)
+	case "getaddr":
+		err = HandleGetAddr(req, chain, peer)
 	case "block":
-		HandleBlock(req, chain)
+		err = HandleBlock(req, chain, peer)
 	case "inv":
-		HandleInv(req, chain)
+		err = HandleInv(req, chain, peer)
 	case "getblocks":
-		HandleGetBlocks(req, chain)
+		err = HandleGetBlocks(req, chain, peer)
 	case "getdata":
-		HandleGetData(req, chain)
+		err = HandleGetData(req, chain, peer)
+	case "getheaders":
+		err = HandleGetHeaders(req, chain, peer)
+	case "headers":
+		err = HandleHeaders(req, chain, peer)
 	case "tx":
-		HandleTx(req, chain)
+		err = HandleTx(req, chain, peer)
 	case "version":
-		HandleVersion(req, chain)
+		err = HandleVersion(req, chain, peer)
 	default:
 		fmt.Println("Unknown command")
 	}
-
+	if err != nil {
+		Misbehaving(peer, scoreMalformed, fmt.Sprintf("%s command that could not be decoded: %s", command, err))
+	}
 }
 
-//allows to send data from one node to the other
-func SendData(addr string, data []byte) {
+//allows to send data from one node to the other, a peer we can not reach is dropped
+func SendData(addr string, data []byte) error {
 	conn, err := net.Dial(protocol, addr)
 	if err != nil {
 		fmt.Printf("%s is not available\n", addr)
-		var updatedNodes []string
-
-		for _, node := range knownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
-		knownNodes = updatedNodes
-		return
+		removeKnownNode(addr)
+		relay.Forget(addr)
+		return err
 	}
 	defer conn.Close()
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		log.Panic(err)
+	if _, err = io.Copy(conn, bytes.NewReader(data)); err != nil {
+		fmt.Printf("Could not send to %s: %s\n", addr, err)
+		return err
 	}
+	return nil
 }
 
 /*
@@ -163,14 +241,18 @@ all the follwing send functions are the messages that we are sending to the peer
 */
 
 //for sending adderss from one of the peers to the other
-func SendAddr(address string) {
-	nodes := Addr{knownNodes}
-	nodes.AddrList = append(nodes.AddrList, nodeAddress)
-	payload := GobEncode(nodes)
+func SendAddr(address string, addrs []PeerAddress) {
+	payload := GobEncode(Addr{nodeAddress, addrs})
 	request := append(CmdToBytes("addr"), payload...)
 	SendData(address, request)
 }
 
+func SendGetAddr(address string) {
+	payload := GobEncode(GetAddr{nodeAddress})
+	request := append(CmdToBytes("getaddr"), payload...)
+	SendData(address, request)
+}
+
 //passing address from one of the peers to the other alongwith a block from blockchain unlike the SendAddr
 func SendBlock(addr string, b *blockchain.Block) {
 	data := Block{nodeAddress, b.Serialize()}
@@ -186,11 +268,11 @@ func SendInv(address, kind string, items [][]byte) {
 	SendData(address, request)
 }
 
-func SendTx(addr string, tnx *blockchain.Transaction) {
+func SendTx(addr string, tnx *blockchain.Transaction) error {
 	data := Tx{nodeAddress, tnx.Serialize()}
 	payload := GobEncode(data)
 	request := append(CmdToBytes("tx"), payload...)
-	SendData(addr, request)
+	return SendData(addr, request)
 }
 
 func SendVersion(addr string, chain *blockchain.Blockchain) {
@@ -201,8 +283,8 @@ func SendVersion(addr string, chain *blockchain.Blockchain) {
 }
 
 //sending from one of our peers to another that we want to get the blocks from their blockchain
-func SendGetBlocks(address string) {
-	payload := GobEncode(GetBlocks{nodeAddress}) //taking info from peer
+func SendGetBlocks(address string, locator [][]byte, stop []byte) {
+	payload := GobEncode(GetBlocks{nodeAddress, locator, stop}) //taking info from peer
 	request := append(CmdToBytes("getblocks"), payload...)
 	SendData(address, request)
 }
@@ -213,25 +295,73 @@ func SendGetData(address, kind string, id []byte) {
 	SendData(address, request)
 }
 
-func HandleAddr(request []byte) {
-	var buff bytes.Buffer
+func HandleAddr(request []byte, chain *blockchain.Blockchain, peer string) error {
 	var payload Addr
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+	if len(payload.AddrList) > maxAddrPerMessage {
+		Misbehaving(peer, scoreOversized, fmt.Sprintf("addr with %d addresses", len(payload.AddrList)))
+		return nil
+	}
+	added := addrman.Add(payload.AddrList, payload.AddrFrom)
+	fresh, tried := addrman.Count()
+	fmt.Printf("Received %d addresses, %d new, we know %d new and %d tried\n", len(payload.AddrList), len(added), fresh, tried)
+	//a node announcing itself sends a small addr message, passing on what is new and recent in it lets its address spread
+	if len(payload.AddrList) > 10 {
+		return nil
+	}
+	var relayed []PeerAddress
+	for _, addr := range added {
+		if time.Since(addr.Timestamp) < freshAddr {
+			relayed = append(relayed, addr)
+		}
+	}
+	if len(relayed) == 0 {
+		return nil
+	}
+	targets := relayTargets(payload.AddrFrom)
+	rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+	for i := 0; i < len(targets) && i < 2; i++ {
+		SendAddr(targets[i], relayed)
+	}
+	return nil
+}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+//answers with a sample of the addresses we know and our own
+func HandleGetAddr(request []byte, chain *blockchain.Blockchain, peer string) error {
+	var payload GetAddr
+	if err := decodePayload(request, &payload); err != nil {
+		return err
 	}
-	knownNodes = append(knownNodes, payload.AddrList...)
-	fmt.Printf("there are %d known nodes\n", len(knownNodes))
-	RequestBlocks()
+	addrs := addrman.Sample(maxAddrPerMessage - 1)
+	addrs = append(addrs, PeerAddress{nodeAddress, time.Now()})
+	SendAddr(payload.AddrFrom, addrs)
+	return nil
 }
 
-//making sure that all our blockchains are synced with one another
-func RequestBlocks() {
-	for _, node := range knownNodes {
-		SendGetBlocks(node)
+//connects to more peers until there are maxOutboundPeers of them, a peer is connected once it answers our version with its own
+func findPeers(chain *blockchain.Blockchain) {
+	missing := maxOutboundPeers - len(peerList())
+	if missing <= 0 {
+		return
+	}
+	skip := func(addr string) bool { return NodeIsKnown(addr) || addrIsBanned(addr) }
+	for _, addr := range addrman.Select(missing, skip) {
+		addrman.Attempt(addr)
+		SendVersion(addr, chain)
+	}
+}
+
+//looks for peers every peerInterval and asks one of ours for the addresses it knows
+func MaintainPeers(chain *blockchain.Blockchain) {
+	findPeers(chain)
+	for range time.Tick(peerInterval) {
+		addrman.Expire()
+		findPeers(chain)
+		if peers := relayTargets(""); len(peers) > 0 {
+			SendGetAddr(peers[rand.Intn(len(peers))])
+		}
 	}
 }
 
@@ -239,93 +369,92 @@ func ExtractCmd(request []byte) []byte {
 	return request[:commandLength]
 }
 
-func HandleBlock(request []byte, chain *blockchain.Blockchain) {
-	var buff bytes.Buffer
+func HandleBlock(request []byte, chain *blockchain.Blockchain, peer string) error {
 	var payload Block
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+	block, err := blockchain.DecodeBlock(payload.Block)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	blockData := payload.Block
-	block := blockchain.Deserialize(blockData)
 	fmt.Println("Recevied a new block!")
-
-	chain.AddBlock(block)
-	fmt.Printf("Added block %x\n", block.Hash)
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		SendGetData(payload.AddrFrom, "block", blockHash)
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := blockchain.UTXOSet{chain}
-		UTXOSet.Reindex()
+	relay.MarkKnown(payload.AddrFrom, [][]byte{block.Hash})
+	for _, failure := range syncer.blockReceived(block, chain, payload.AddrFrom) {
+		Misbehaving(failure.peer, scoreInvalid, fmt.Sprintf("invalid block %x: %s", failure.hash, failure.err))
 	}
+	return nil
 }
 
-func HandleGetBlocks(request []byte, chain *blockchain.Blockchain) {
-	var buff bytes.Buffer
+func HandleGetBlocks(request []byte, chain *blockchain.Blockchain, peer string) error {
 	var payload GetBlocks
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+	blocks := chain.BlockHashesAfter(payload.Locator, payload.Stop, maxInvPerMessage)
+	if len(blocks) > 0 {
+		SendInv(payload.AddrFrom, "block", blocks)
 	}
-	blocks := chain.GetBlockHashes()
-	SendInv(payload.AddrFrom, "block", blocks)
+	return nil
 }
 
-func HandleGetData(request []byte, chain *blockchain.Blockchain) {
-	var buff bytes.Buffer
+func HandleGetData(request []byte, chain *blockchain.Blockchain, peer string) error {
 	var payload GetData
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
 	}
 	if payload.Type == "block" {
 		block, err := chain.GetBlock([]byte(payload.ID))
 		if err != nil {
-			return
+			return nil
 		}
 		SendBlock(payload.AddrFrom, &block)
 	}
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := memoryPool[txID]
-
-		SendTx(payload.AddrFrom, &tx)
+		tx, ok := memoryPool.Get(payload.ID)
+		if !ok {
+			return nil
+		}
+		SendTx(payload.AddrFrom, tx)
 	}
+	return nil
 }
 
-func HandleVersion(request []byte, chain *blockchain.Blockchain) {
-	var buff bytes.Buffer
+func HandleVersion(request []byte, chain *blockchain.Blockchain, peer string) error {
 	var payload Version
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
 	}
 	bestHeight := chain.GetBestHeight()
 	otherHeight := payload.BestHeight
+	syncer.setPeerHeight(payload.AddrFrom, otherHeight)
+	//a peer answering our version is known to work, one connecting to us only claims its address
+	if !addrman.Good(payload.AddrFrom) {
+		addrman.Add([]PeerAddress{{payload.AddrFrom, time.Now()}}, peer)
+	}
+	//a new peer gets our version back so it knows our height and counts us as connected too
+	isNew := addKnownNode(payload.AddrFrom)
+	if isNew {
+		SendGetAddr(payload.AddrFrom)
+		relay.Pool(payload.AddrFrom)
+	}
 	if bestHeight < otherHeight {
-		SendGetBlocks(payload.AddrFrom)
-	} else if bestHeight > otherHeight {
-		SendVersion(payload.AddrFrom, chain)
+		syncer.expectHeight(payload.AddrFrom)
+		SendGetHeaders(payload.AddrFrom, chain.Locator(), nil)
 	}
-	if !NodeIsKnown(payload.AddrFrom) {
-		knownNodes = append(knownNodes, payload.AddrFrom)
+	if bestHeight > otherHeight || isNew {
+		SendVersion(payload.AddrFrom, chain)
 	}
+	return nil
 }
 
 func NodeIsKnown(addr string) bool {
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	return nodeIsKnown(addr)
+}
+
+func nodeIsKnown(addr string) bool {
 	for _, node := range knownNodes {
 		if node == addr {
 			return true
@@ -334,96 +463,161 @@ func NodeIsKnown(addr string) bool {
 	return false
 }
 
-func HandleInv(request []byte, chain *blockchain.Blockchain) {
-	var buff bytes.Buffer
-	var payload Inv
+//adds addr to our peers, false when it is one already
+func addKnownNode(addr string) bool {
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	if nodeIsKnown(addr) {
+		return false
+	}
+	knownNodes = append(knownNodes, addr)
+	return true
+}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+func removeKnownNode(addr string) {
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	var remaining []string
+	for _, node := range knownNodes {
+		if node != addr {
+			remaining = append(remaining, node)
+		}
+	}
+	knownNodes = remaining
+}
+
+//a copy of our peers that can be used without holding nodesMu
+func peerList() []string {
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	return append([]string{}, knownNodes...)
+}
+
+func HandleInv(request []byte, chain *blockchain.Blockchain, peer string) error {
+	var payload Inv
+	if err := decodePayload(request, &payload); err != nil {
+		return err
 	}
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
+	if len(payload.Items) > maxInvPerMessage {
+		Misbehaving(peer, scoreOversized, fmt.Sprintf("inventory with %d items", len(payload.Items)))
+		return nil
+	}
+	if len(payload.Items) == 0 || (payload.Type != "block" && payload.Type != "tx") {
+		Misbehaving(peer, scoreUselessInv, fmt.Sprintf("inventory with %d items of type %q", len(payload.Items), payload.Type))
+		return nil
+	}
+	//an inventory of what we have is not held against the peer, it crosses ours whenever we both get an item at the same time
+	relay.MarkKnown(payload.AddrFrom, payload.Items)
+	//a new block is announced by its hash, its header comes first like during sync and the block is downloaded once the header checks out
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-		blockHash := payload.Items[0]
-		SendGetData(payload.AddrFrom, "block", blockHash)
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+		for _, hash := range payload.Items {
+			if _, err := chain.GetBlock(hash); err != nil && !orphans.Has(hash) {
+				SendGetHeaders(payload.AddrFrom, chain.Locator(), nil)
+				break
 			}
 		}
-		blocksInTransit = newInTransit
 	}
 	if payload.Type == "tx" {
-		txID := payload.Items[0]
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
-			SendGetData(payload.AddrFrom, "tx", txID)
+		for _, txID := range payload.Items {
+			if !memoryPool.Has(txID) && !orphanTxs.Has(txID) {
+				SendGetData(payload.AddrFrom, "tx", txID)
+			}
 		}
 	}
+	return nil
 }
 
-func HandleTx(request []byte, chain *blockchain.Blockchain) {
-	var buff bytes.Buffer
+func HandleTx(request []byte, chain *blockchain.Blockchain, peer string) error {
 	var payload Tx
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+	tx, err := blockchain.DecodeTransaction(payload.Transaction)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	relay.MarkKnown(payload.AddrFrom, [][]byte{tx.ID})
+	if !acceptTx(&tx, payload.AddrFrom) {
+		return nil
+	}
+	if memoryPool.Count() >= 2 && len(mineAddress) > 0 {
+		MineTx(chain)
 	}
+	return nil
+}
 
-	txData := payload.Transaction
-	tx := blockchain.DeserializeTransaction(txData)
-	memoryPool[hex.EncodeToString(tx.ID)] = tx
-	fmt.Printf("%s, %d", nodeAddress, len(memoryPool))
-	if nodeAddress == knownNodes[0] {
-		for _, node := range knownNodes {
-			if node != nodeAddress && node != payload.AddrFrom {
-				SendInv(node, "tx", [][]byte{tx.ID})
-			}
+/*
+only transactions that could go into the next block are kept and relayed, false when tx is not
+one spending outputs we do not know of waits as an orphan, once it is accepted the orphans spending from it are tried again
+*/
+func acceptTx(tx *blockchain.Transaction, from string) bool {
+	err := memoryPool.Accept(tx)
+	if _, missing := err.(mempool.MissingOutputError); missing {
+		if orphanTxs.Add(tx, from) {
+			fmt.Printf("Keeping transaction %x until the transactions it spends from arrive\n", tx.ID)
 		}
-	} else {
-		if len(memoryPool) >= 2 && len(mineAddress) > 0 {
-			MineTx(chain)
+		return false
+	}
+	if err != nil {
+		fmt.Printf("Rejected transaction %x: %s\n", tx.ID, err)
+		//a transaction that spends what is already spent or pays too little may be fine elsewhere, one that no node could accept is not
+		if err == mempool.ErrCoinbase || err == mempool.ErrIDMismatch || err == mempool.ErrBadSignature {
+			Misbehaving(from, scoreInvalid, fmt.Sprintf("invalid transaction %x", tx.ID))
 		}
+		return false
 	}
+	relay.Tx(tx.ID, from)
+	for _, orphan := range orphanTxs.Children(tx.ID) {
+		acceptTx(orphan.tx, orphan.from)
+	}
+	return true
 }
 
 func MineTx(chain *blockchain.Blockchain) {
-	var txs []*blockchain.Transaction
-	for id := range memoryPool {
-		fmt.Printf("tx: %s\n", memoryPool[id].ID)
-		tx := memoryPool[id]
-		if chain.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
-		}
-	}
-	if len(txs) == 0 {
+	newBlock := mineBlock(chain)
+	if newBlock == nil {
 		fmt.Println("All Transactions are invalid")
 		return
 	}
-	cbTx := blockchain.CoinbaseTx(mineAddress, "")
-	txs = append(txs, cbTx)
-	newBlock := chain.MineBlock(txs)
-	UTXOSet := blockchain.UTXOSet{chain}
-	UTXOSet.Reindex()
 	fmt.Println("New Block mined")
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.ID)
-		delete(memoryPool, txID)
+	relay.Block(newBlock.Hash, "")
+	if memoryPool.Count() > 0 {
+		MineTx(chain)
 	}
-	for _, node := range knownNodes {
-		if node != nodeAddress {
-			SendInv(node, "block", [][]byte{newBlock.Hash})
+}
+
+//a block of the pool transactions that can go in, mined on top of our last block and connected, nil when none can
+func mineBlock(chain *blockchain.Blockchain) *blockchain.Block {
+	//the block is built and connected as one write to the chain, a block from a peer can not come in between
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+	var txs []*blockchain.Transaction
+	//the pool lists parents before their children, a child whose parent stays out has to stay out too
+	included := make(map[string]bool)
+Pool:
+	for _, tx := range memoryPool.Transactions() {
+		for _, in := range tx.Inputs {
+			if memoryPool.Has(in.ID) && !included[hex.EncodeToString(in.ID)] {
+				continue Pool
+			}
+		}
+		//the pool checked the transaction when it came in, only its lock can have changed since as time passes
+		if chain.CheckLocksUnconfirmed(tx, chain.GetBestHeight()+1, chain.LastHash, included) == nil {
+			txs = append(txs, tx)
+			included[hex.EncodeToString(tx.ID)] = true
 		}
 	}
-	if len(memoryPool) > 0 {
-		MineTx(chain)
+	if len(txs) == 0 {
+		return nil
 	}
+	cbTx := blockchain.CoinbaseTx(mineAddress, "")
+	txs = append([]*blockchain.Transaction{cbTx}, txs...)
+	newBlock := chain.MineBlock(txs)
+	UTXOSet := blockchain.UTXOSet{chain}
+	UTXOSet.Update(newBlock)
+	memoryPool.BlockConnected(newBlock)
+	return newBlock
 }
 
 func StartServer(nodeID, minerAddress string) {
@@ -437,11 +631,30 @@ func StartServer(nodeID, minerAddress string) {
 
 	chain := blockchain.ContinueBlockChain(nodeID)
 	defer chain.Database.Close()
+	memoryPool = mempool.New(chain, mempool.DefaultConfig)
+	mempoolFile = mempool.File(nodeID)
+	loaded, dropped, err := memoryPool.Load(mempoolFile)
+	if err != nil {
+		fmt.Printf("Could not load the mempool: %s\n", err)
+	} else if loaded+dropped > 0 {
+		fmt.Printf("Loaded %d transactions into the mempool, dropped %d that are expired or no longer valid\n", loaded, dropped)
+	}
 	go CloseDB(chain)
+	go DumpMempoolPeriodically(mempoolDumpInterval)
+	go RetryBlockDownloads()
+	go ExpireOrphans()
+	go TrickleTransactions()
 
-	if nodeAddress != knownNodes[0] {
-		SendVersion(knownNodes[0], chain)
+	banFile := banlist.File(nodeID)
+	if bans, err = banlist.Load(banFile); err != nil {
+		fmt.Printf("Could not load the ban list in %s: %s\n", banFile, err)
+		bans = banlist.New(banFile)
 	}
+	go WatchBanList()
+
+	//a seed file next to the chain replaces the seeds set by the caller
+	addrman.Add(seedAddresses(nodeID, Seeds), seedSource)
+	go MaintainPeers(chain)
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
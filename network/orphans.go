@@ -0,0 +1,188 @@
+package network
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+)
+
+/*
+orphan blocks
+a block whose parent we do not have can not be added to the chain yet, it is kept here under the hash of that parent
+its missing ancestors are asked from the peer that sent it and once the parent is connected the orphan is connected too, and then the orphans waiting on it
+the pool is bounded in count and in bytes, when it is full the oldest orphan goes, and an orphan whose parent never shows up expires
+a peer can only fill a part of it, past that its own oldest orphan makes room, and a block without valid proof of work is never kept
+*/
+const (
+	maxOrphans        = 100              //orphans kept at most
+	maxOrphansPerPeer = 20               //orphans kept at most from one peer
+	maxOrphanBytes    = 20 * 1024 * 1024 //bytes of serialized orphans kept at most
+	orphanExpiry      = 20 * time.Minute //time an orphan waits for its parent
+)
+
+type orphanBlock struct {
+	block *blockchain.Block
+	size  int
+	from  string //peer that sent the block, the one its ancestors are asked from and that is blamed when it is invalid
+	added time.Time
+}
+
+type orphanPool struct {
+	mu       sync.Mutex
+	byHash   map[string]*orphanBlock
+	byParent map[string][]string //hash of a missing parent -> hashes of the orphans waiting on it
+	byPeer   map[string]int      //orphans kept per peer address, nodes sharing a host each have their own
+	size     int
+}
+
+var orphans = newOrphanPool()
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		byHash:   make(map[string]*orphanBlock),
+		byParent: make(map[string][]string),
+		byPeer:   make(map[string]int),
+	}
+}
+
+//an orphan can not be checked against the chain yet, but its proof of work can be, a peer would have to spend real work on every orphan it sends
+func checkOrphan(block *blockchain.Block) error {
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("Orphan block %x has no transactions", block.Hash)
+	}
+	header := block.Header()
+	if !header.Validate() {
+		return fmt.Errorf("Orphan block %x has invalid proof of work", block.Hash)
+	}
+	return nil
+}
+
+func (op *orphanPool) Has(hash []byte) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	_, ok := op.byHash[hex.EncodeToString(hash)]
+	return ok
+}
+
+//keeps block until its parent is connected, false when it is already kept or is larger than the whole pool
+func (op *orphanPool) Add(block *blockchain.Block, from string) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	hash := hex.EncodeToString(block.Hash)
+	if _, ok := op.byHash[hash]; ok {
+		return false
+	}
+	size := len(block.Serialize())
+	if size > maxOrphanBytes {
+		return false
+	}
+	op.expire()
+	for op.byPeer[from] >= maxOrphansPerPeer {
+		op.remove(op.oldest(from))
+	}
+	for len(op.byHash) >= maxOrphans || op.size+size > maxOrphanBytes {
+		op.remove(op.oldest(""))
+	}
+	op.byHash[hash] = &orphanBlock{block, size, from, time.Now()}
+	parent := hex.EncodeToString(block.PrevHash)
+	op.byParent[parent] = append(op.byParent[parent], hash)
+	op.byPeer[from]++
+	op.size += size
+	return true
+}
+
+//the hash of the block the chain of orphans ending at hash is missing, that is the ancestor to ask for
+func (op *orphanPool) MissingAncestor(hash []byte) []byte {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	//orphans that lead back to one another never reach a missing block, there is nothing to ask for then
+	visited := make(map[string]bool)
+	for {
+		key := hex.EncodeToString(hash)
+		orphan, ok := op.byHash[key]
+		if !ok {
+			return hash
+		}
+		if visited[key] {
+			return nil
+		}
+		visited[key] = true
+		hash = orphan.block.PrevHash
+	}
+}
+
+//takes the orphans waiting on parent out of the pool
+func (op *orphanPool) Children(parent []byte) []*orphanBlock {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	var children []*orphanBlock
+	for _, hash := range op.byParent[hex.EncodeToString(parent)] {
+		children = append(children, op.byHash[hash])
+		op.remove(hash)
+	}
+	return children
+}
+
+func (op *orphanPool) Expire() {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.expire()
+}
+
+func (op *orphanPool) expire() {
+	for hash, orphan := range op.byHash {
+		if time.Since(orphan.added) > orphanExpiry {
+			fmt.Printf("Orphan block %s expired\n", hash)
+			op.remove(hash)
+		}
+	}
+}
+
+//the oldest orphan of peer, of any peer when it is empty
+func (op *orphanPool) oldest(peer string) string {
+	var oldest string
+	var added time.Time
+	for hash, orphan := range op.byHash {
+		if peer != "" && orphan.from != peer {
+			continue
+		}
+		if oldest == "" || orphan.added.Before(added) {
+			oldest, added = hash, orphan.added
+		}
+	}
+	return oldest
+}
+
+func (op *orphanPool) remove(hash string) {
+	orphan, ok := op.byHash[hash]
+	if !ok {
+		return
+	}
+	delete(op.byHash, hash)
+	op.size -= orphan.size
+	if op.byPeer[orphan.from]--; op.byPeer[orphan.from] <= 0 {
+		delete(op.byPeer, orphan.from)
+	}
+	parent := hex.EncodeToString(orphan.block.PrevHash)
+	var waiting []string
+	for _, other := range op.byParent[parent] {
+		if other != hash {
+			waiting = append(waiting, other)
+		}
+	}
+	if len(waiting) == 0 {
+		delete(op.byParent, parent)
+	} else {
+		op.byParent[parent] = waiting
+	}
+}
+
+func ExpireOrphans() {
+	for range time.Tick(orphanExpiry / 10) {
+		orphans.Expire()
+		orphanTxs.Expire()
+	}
+}
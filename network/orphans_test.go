@@ -0,0 +1,76 @@
+package network
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//a block with valid proof of work on top of a parent we do not have
+func workedOrphan(t *testing.T, parent []byte) *blockchain.Block {
+	coinbase := blockchain.CoinbaseTx(string(wallet.MakeWallet().Address()), "")
+	return blockchain.CreateBlock([]*blockchain.Transaction{coinbase}, parent, 5)
+}
+
+func missingParent(i int) []byte {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("missing %d", i)))
+	return hash[:]
+}
+
+func TestOrphanWithoutWorkIsRejected(t *testing.T) {
+	block := workedOrphan(t, missingParent(0))
+	if err := checkOrphan(block); err != nil {
+		t.Fatal(err)
+	}
+	block.Nonce++
+	if err := checkOrphan(block); err == nil {
+		t.Fatal("an orphan with invalid proof of work was accepted")
+	}
+	block.Transactions = nil
+	if err := checkOrphan(block); err == nil {
+		t.Fatal("an orphan without transactions was accepted")
+	}
+}
+
+func TestMissingAncestorOfOrphanCycle(t *testing.T) {
+	pool := newOrphanPool()
+	block := workedOrphan(t, missingParent(0))
+	block.PrevHash = block.Hash
+	pool.Add(block, "localhost:1")
+	if ancestor := pool.MissingAncestor(block.Hash); ancestor != nil {
+		t.Fatalf("an orphan that is its own parent is missing %x", ancestor)
+	}
+
+	child := workedOrphan(t, missingParent(1))
+	pool.Add(child, "localhost:1")
+	if ancestor := pool.MissingAncestor(child.Hash); string(ancestor) != string(missingParent(1)) {
+		t.Fatalf("missing ancestor is %x, expected %x", ancestor, missingParent(1))
+	}
+}
+
+func TestOrphansPerPeerAreCapped(t *testing.T) {
+	pool := newOrphanPool()
+	var first *blockchain.Block
+	for i := 0; i <= maxOrphansPerPeer; i++ {
+		block := workedOrphan(t, missingParent(i))
+		if i == 0 {
+			first = block
+		}
+		pool.Add(block, "localhost:1")
+	}
+	//another node on the same host has a quota of its own
+	other := workedOrphan(t, missingParent(-1))
+	pool.Add(other, "localhost:2")
+	if pool.byPeer["localhost:1"] != maxOrphansPerPeer {
+		t.Fatalf("the peer has %d orphans, expected %d", pool.byPeer["localhost:1"], maxOrphansPerPeer)
+	}
+	if pool.Has(first.Hash) {
+		t.Fatal("the oldest orphan of the peer was kept")
+	}
+	if !pool.Has(other.Hash) {
+		t.Fatal("the orphan of another peer made room")
+	}
+}
@@ -0,0 +1,190 @@
+package network
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+gossip
+every node passes a transaction or block it accepted on to all its peers but the one it came from, so it reaches the whole network whatever its shape
+for every one of our peers we remember the inventory it has, because it sent or announced it to us or we announced it to it, and never announce that again
+blocks are announced right away, transactions are collected and announced to each peer in one inv, so a burst of transactions costs one message per peer
+each peer gets its inv after its own random wait, trickleInterval on average, and the transactions in it are shuffled
+so neither the time a transaction reaches each peer nor its place in the inv tells which node it started from
+*/
+const (
+	trickleInterval   = 2 * time.Second        //average wait before the transactions waiting for a peer are announced to it
+	trickleTick       = 100 * time.Millisecond //how often we look for peers whose wait is over
+	maxKnownInventory = 5000                   //hashes remembered per peer, the oldest are forgotten first
+)
+
+type peerInventory struct {
+	known       map[string]bool
+	order       []string //known hashes, oldest first
+	pendingTxs  [][]byte //transactions to announce on the next trickle
+	nextTrickle time.Time
+}
+
+//a random wait with trickleInterval as its average, like the time between the events of a poisson process
+func trickleDelay() time.Duration {
+	return time.Duration(rand.ExpFloat64() * float64(trickleInterval))
+}
+
+type relayState struct {
+	mu    sync.Mutex
+	peers map[string]*peerInventory
+}
+
+var relay = &relayState{peers: make(map[string]*peerInventory)}
+
+//the inventory of addr, nil when it is not one of our peers, anyone else could make us remember inventory for any number of addresses
+func (r *relayState) peer(addr string) *peerInventory {
+	inv, ok := r.peers[addr]
+	if !ok {
+		if !NodeIsKnown(addr) {
+			return nil
+		}
+		inv = &peerInventory{known: make(map[string]bool), nextTrickle: time.Now().Add(trickleDelay())}
+		r.peers[addr] = inv
+	}
+	return inv
+}
+
+//true when the hash was not known for the peer yet
+func (inv *peerInventory) add(hash []byte) bool {
+	key := hex.EncodeToString(hash)
+	if inv.known[key] {
+		return false
+	}
+	inv.known[key] = true
+	inv.order = append(inv.order, key)
+	if len(inv.order) > maxKnownInventory {
+		delete(inv.known, inv.order[0])
+		inv.order = inv.order[1:]
+	}
+	return true
+}
+
+//records that peer has the items, it sent or announced them to us, returns how many of them we did not know it had
+func (r *relayState) MarkKnown(peer string, items [][]byte) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inv := r.peer(peer)
+	if inv == nil {
+		return len(items)
+	}
+	added := 0
+	for _, item := range items {
+		if inv.add(item) {
+			added++
+		}
+	}
+	return added
+}
+
+//peers we relay to, every known node but ourselves and source
+func relayTargets(source string) []string {
+	var targets []string
+	for _, node := range peerList() {
+		if node != nodeAddress && node != source {
+			targets = append(targets, node)
+		}
+	}
+	return targets
+}
+
+//announces the block to every peer that does not have it
+func (r *relayState) Block(hash []byte, source string) {
+	r.mu.Lock()
+	var targets []string
+	for _, node := range relayTargets(source) {
+		if inv := r.peer(node); inv != nil && inv.add(hash) {
+			targets = append(targets, node)
+		}
+	}
+	r.mu.Unlock()
+	for _, node := range targets {
+		SendInv(node, "block", [][]byte{hash})
+	}
+}
+
+//queues the transaction for the next trickle to every peer that does not have it
+func (r *relayState) Tx(id []byte, source string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range relayTargets(source) {
+		if inv := r.peer(node); inv != nil && inv.add(id) {
+			inv.pendingTxs = append(inv.pendingTxs, id)
+		}
+	}
+}
+
+//queues every transaction in our pool for the next trickle to a new peer, so what got into the pool while the node was stopped reaches the network too
+func (r *relayState) Pool(peer string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inv := r.peer(peer)
+	if inv == nil {
+		return
+	}
+	for _, entry := range memoryPool.Entries() {
+		if inv.add(entry.Tx.ID) {
+			inv.pendingTxs = append(inv.pendingTxs, entry.Tx.ID)
+		}
+	}
+}
+
+//announces the queued transactions that are still in the pool to every peer whose wait is over, one shuffled inv per peer
+func (r *relayState) trickle(now time.Time) {
+	r.mu.Lock()
+	batches := make(map[string][][]byte)
+	for node, inv := range r.peers {
+		if now.Before(inv.nextTrickle) {
+			continue
+		}
+		inv.nextTrickle = now.Add(trickleDelay())
+		var items, later [][]byte
+		for _, id := range inv.pendingTxs {
+			if !memoryPool.Has(id) {
+				continue
+			}
+			if len(items) < maxInvPerMessage {
+				items = append(items, id)
+			} else {
+				later = append(later, id)
+			}
+		}
+		inv.pendingTxs = later
+		if len(items) > 0 {
+			rand.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+			//parents still go before their children, a peer asking for them in this order does not have to keep the children as orphans
+			ancestors := make(map[string]int)
+			for _, id := range items {
+				ancestors[string(id)] = len(memoryPool.Ancestors(id))
+			}
+			sort.SliceStable(items, func(i, j int) bool { return ancestors[string(items[i])] < ancestors[string(items[j])] })
+			batches[node] = items
+		}
+	}
+	r.mu.Unlock()
+	for node, items := range batches {
+		SendInv(node, "tx", items)
+	}
+}
+
+//forgets a peer that went away
+func (r *relayState) Forget(peer string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.peers, peer)
+}
+
+func TrickleTransactions() {
+	for now := range time.Tick(trickleTick) {
+		relay.trickle(now)
+	}
+}
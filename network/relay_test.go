@@ -0,0 +1,60 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/mempool"
+)
+
+//makes addr one of our peers until the test ends
+func knownPeer(t *testing.T, addr string) {
+	addKnownNode(addr)
+	t.Cleanup(func() {
+		removeKnownNode(addr)
+		relay.Forget(addr)
+	})
+}
+
+func tracked(addr string) *peerInventory {
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	return relay.peers[addr]
+}
+
+func TestRelayOnlyTracksPeers(t *testing.T) {
+	stranger := "localhost:9001"
+	if added := relay.MarkKnown(stranger, [][]byte{[]byte("a"), []byte("b")}); added != 2 {
+		t.Fatalf("items from a node that is not a peer counted as %d new, expected all of them", added)
+	}
+	if tracked(stranger) != nil {
+		t.Fatal("inventory is remembered for a node that is not a peer")
+	}
+	knownPeer(t, stranger)
+	relay.MarkKnown(stranger, [][]byte{[]byte("a")})
+	if tracked(stranger) == nil {
+		t.Fatal("inventory is not remembered for a peer")
+	}
+}
+
+func TestTrickleWaitsForEachPeer(t *testing.T) {
+	memoryPool = mempool.New(nil, mempool.DefaultConfig)
+	due, waiting := sink(t), sink(t)
+	knownPeer(t, due)
+	knownPeer(t, waiting)
+	relay.Tx([]byte("tx"), "")
+
+	now := time.Now()
+	relay.mu.Lock()
+	relay.peers[due].nextTrickle = now
+	relay.peers[waiting].nextTrickle = now.Add(time.Hour)
+	relay.mu.Unlock()
+	relay.trickle(now)
+
+	if inv := tracked(due); len(inv.pendingTxs) != 0 || !inv.nextTrickle.After(now) {
+		t.Fatal("the peer whose wait was over did not get its trickle and a new wait")
+	}
+	if inv := tracked(waiting); len(inv.pendingTxs) != 1 || !inv.nextTrickle.Equal(now.Add(time.Hour)) {
+		t.Fatal("the peer still waiting got its trickle early")
+	}
+}
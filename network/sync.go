@@ -0,0 +1,475 @@
+package network
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+)
+
+/*
+headers-first sync
+a node behind a peer first asks it for headers, they are small and checking their proof of work and how they link up needs no blocks
+once it knows the best header chain it downloads the blocks of it from every peer that has them, a window of blocks at a time
+blocks can arrive in any order but they are connected one after another in the order of the header chain, each one updating the UTXO set
+a block that does not arrive in time is asked for again from another peer
+only the headers of the best header chain are kept, and no more than maxHeadersAhead past our last block, the rest is asked for again once the blocks catch up
+syncer.mu is held for every write to the chain, so a block from a peer and one we mine are never connected at the same time
+*/
+const (
+	maxHeadersPerMessage = 2000                      //headers in one reply, a full reply means the peer has more
+	maxInvPerMessage     = 500                       //block hashes in one reply to getblocks
+	downloadWindow       = 16                        //blocks after the next one to connect that can be downloaded at the same time
+	blockTimeout         = 20 * time.Second          //time a peer gets to send a block we asked it for
+	maxHeadersAhead      = 50 * maxHeadersPerMessage //headers kept past our best height
+)
+
+//like GetBlocks but the reply is the headers, at most maxHeadersPerMessage of them
+type GetHeaders struct {
+	AddrFrom string
+	Locator  [][]byte
+	Stop     []byte
+}
+
+type Headers struct {
+	AddrFrom string
+	Headers  []blockchain.BlockHeader
+}
+
+type blockRequest struct {
+	peer string
+	sent time.Time
+}
+
+type receivedBlock struct {
+	block *blockchain.Block
+	from  string //peer that sent the block, the one to blame when it is invalid
+}
+
+//a block that could not be connected and the address of the peer that sent it
+type blockFailure struct {
+	peer string
+	hash []byte
+	err  error
+}
+
+type syncState struct {
+	mu          sync.Mutex
+	headers     map[string]blockchain.BlockHeader //checked headers of blocks we do not have yet
+	queue       []string                          //hashes of the blocks to download, in the order they are connected
+	inFlight    map[string]blockRequest
+	received    map[string]receivedBlock //downloaded blocks waiting for the ones before them
+	peerHeights map[string]int           //best height every peer told us in its version message
+	checkHeight map[string]bool          //peers whose next headers have to reach the height they told us
+	nextPeer    int
+}
+
+var syncer = &syncState{
+	headers:     make(map[string]blockchain.BlockHeader),
+	inFlight:    make(map[string]blockRequest),
+	received:    make(map[string]receivedBlock),
+	peerHeights: make(map[string]int),
+	checkHeight: make(map[string]bool),
+}
+
+func SendGetHeaders(address string, locator [][]byte, stop []byte) {
+	payload := GobEncode(GetHeaders{nodeAddress, locator, stop})
+	request := append(CmdToBytes("getheaders"), payload...)
+	SendData(address, request)
+}
+
+func SendHeaders(address string, headers []blockchain.BlockHeader) {
+	payload := GobEncode(Headers{nodeAddress, headers})
+	request := append(CmdToBytes("headers"), payload...)
+	SendData(address, request)
+}
+
+func HandleGetHeaders(request []byte, chain *blockchain.Blockchain, peer string) error {
+	var payload GetHeaders
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+	SendHeaders(payload.AddrFrom, chain.HeadersAfter(payload.Locator, payload.Stop, maxHeadersPerMessage))
+	return nil
+}
+
+func HandleHeaders(request []byte, chain *blockchain.Blockchain, peer string) error {
+	var payload Headers
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+	fmt.Printf("Received %d headers\n", len(payload.Headers))
+	if len(payload.Headers) > maxHeadersPerMessage {
+		Misbehaving(peer, scoreOversized, fmt.Sprintf("%d headers", len(payload.Headers)))
+		return nil
+	}
+	accepted, invalid, full := syncer.addHeaders(payload.Headers, chain)
+	if invalid {
+		Misbehaving(peer, scoreInvalid, fmt.Sprintf("invalid header %x", payload.Headers[accepted].Hash))
+	} else if accepted < len(payload.Headers) && !full {
+		Misbehaving(peer, scoreUnconnecting, fmt.Sprintf("header %x that does not connect", payload.Headers[accepted].Hash))
+	}
+	if len(payload.Headers) < maxHeadersPerMessage {
+		if claimed, reached, ok := syncer.heightReached(payload.AddrFrom, payload.Headers, chain); !ok {
+			Misbehaving(peer, scoreFalseHeight, fmt.Sprintf("claimed height %d but its chain ends at %d", claimed, reached))
+		}
+	}
+	if accepted == len(payload.Headers) && accepted == maxHeadersPerMessage {
+		last := payload.Headers[len(payload.Headers)-1]
+		SendGetHeaders(payload.AddrFrom, append([][]byte{last.Hash}, chain.Locator()...), nil)
+	}
+	syncer.requestBlocks(chain, payload.AddrFrom)
+	return nil
+}
+
+//the header of hash from the headers we checked or from the block we have, false when we know neither
+func (s *syncState) header(hash []byte, chain *blockchain.Blockchain) (blockchain.BlockHeader, bool) {
+	if header, ok := s.headers[hex.EncodeToString(hash)]; ok {
+		return header, true
+	}
+	if block, err := chain.GetBlock(hash); err == nil {
+		return blockchain.BlockHeader{Hash: block.Hash, PrevHash: block.PrevHash, Height: block.Height}, true
+	}
+	return blockchain.BlockHeader{}, false
+}
+
+/*
+checks the headers in order and keeps them, the first one that does not have valid work or does not follow a header we know ends the check
+returns how many were kept, whether the check ended on an invalid header rather than one that does not connect
+and whether it ended because maxHeadersAhead were kept already
+the download queue is then the header chain with the most blocks that we do not have yet, the headers of other chains are dropped
+*/
+func (s *syncState) addHeaders(headers []blockchain.BlockHeader, chain *blockchain.Blockchain) (int, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accepted, invalid, full := 0, false, false
+	bestHeight := chain.GetBestHeight()
+	for _, header := range headers {
+		if header.Height > bestHeight+maxHeadersAhead {
+			full = true
+			break
+		}
+		parent, ok := s.header(header.PrevHash, chain)
+		if !ok {
+			fmt.Printf("Header %x does not extend a chain we know\n", header.Hash)
+			break
+		}
+		if header.Height != parent.Height+1 || !header.Validate() {
+			fmt.Printf("Header %x is invalid\n", header.Hash)
+			invalid = true
+			break
+		}
+		if _, err := chain.GetBlock(header.Hash); err != nil {
+			s.headers[hex.EncodeToString(header.Hash)] = header
+		}
+		accepted++
+	}
+
+	var best blockchain.BlockHeader
+	for _, header := range s.headers {
+		if header.Height > best.Height {
+			best = header
+		}
+	}
+	var queue []string
+	if best.Height > bestHeight {
+		for hash := hex.EncodeToString(best.Hash); ; {
+			header, ok := s.headers[hash]
+			if !ok {
+				break
+			}
+			queue = append([]string{hash}, queue...)
+			hash = hex.EncodeToString(header.PrevHash)
+		}
+	}
+	s.queue = queue
+	s.prune()
+	return accepted, invalid, full
+}
+
+//drops every header that is not in the download queue, along with the blocks of them we asked for or got
+func (s *syncState) prune() {
+	keep := make(map[string]bool)
+	for _, hash := range s.queue {
+		keep[hash] = true
+	}
+	for hash := range s.headers {
+		if !keep[hash] {
+			delete(s.headers, hash)
+			delete(s.inFlight, hash)
+			delete(s.received, hash)
+		}
+	}
+}
+
+//a peer whose best height reaches height, taking turns between them so downloads are spread, fallback when none is known to
+func (s *syncState) pickPeer(height int, fallback, exclude string) string {
+	var candidates []string
+	for _, node := range peerList() {
+		if node != nodeAddress && node != exclude && s.peerHeights[node] >= height {
+			candidates = append(candidates, node)
+		}
+	}
+	if len(candidates) == 0 {
+		return fallback
+	}
+	s.nextPeer++
+	return candidates[s.nextPeer%len(candidates)]
+}
+
+//asks for the blocks of the window that are neither downloaded nor asked for, source is the peer that gave us the headers
+func (s *syncState) requestBlocks(chain *blockchain.Blockchain, source string) {
+	s.mu.Lock()
+	requests := make(map[string]string)
+	for i, hash := range s.queue {
+		if i > downloadWindow {
+			break
+		}
+		if _, ok := s.received[hash]; ok {
+			continue
+		}
+		if _, ok := s.inFlight[hash]; ok {
+			continue
+		}
+		peer := s.pickPeer(s.headers[hash].Height, source, "")
+		s.inFlight[hash] = blockRequest{peer, time.Now()}
+		requests[hash] = peer
+	}
+	s.mu.Unlock()
+	sendBlockRequests(requests)
+}
+
+func sendBlockRequests(requests map[string]string) {
+	for hash, peer := range requests {
+		id, _ := hex.DecodeString(hash)
+		SendGetData(peer, "block", id)
+	}
+}
+
+//asks another peer for every block that was not sent in time
+func (s *syncState) retryTimedOut() {
+	s.mu.Lock()
+	requests := make(map[string]string)
+	for hash, req := range s.inFlight {
+		if time.Since(req.sent) < blockTimeout {
+			continue
+		}
+		fmt.Printf("%s did not send block %s in time\n", req.peer, hash)
+		peer := s.pickPeer(s.headers[hash].Height, req.peer, req.peer)
+		s.inFlight[hash] = blockRequest{peer, time.Now()}
+		requests[hash] = peer
+	}
+	s.mu.Unlock()
+	sendBlockRequests(requests)
+}
+
+func RetryBlockDownloads() {
+	for range time.Tick(blockTimeout / 4) {
+		syncer.retryTimedOut()
+	}
+}
+
+/*
+a block we asked for waits until the ones before it are connected, the others are connected right away when their parent is known
+and kept as orphans when it is not, the headers up to the missing ancestor are then asked from the peer that sent the block
+a new tip is announced to the other peers once there is nothing left to download, during sync they would only ask for blocks we do not have yet
+source is the address of the peer the block came from
+returns the blocks that could not be connected, with this one they can be orphans or downloaded blocks that were waiting for it
+a block of the header chain failing means the rest of that chain is dropped too
+*/
+func (s *syncState) blockReceived(block *blockchain.Block, chain *blockchain.Blockchain, source string) []blockFailure {
+	s.mu.Lock()
+	tip := chain.LastHash
+	hash := hex.EncodeToString(block.Hash)
+	delete(s.inFlight, hash)
+	if _, ok := s.headers[hash]; !ok {
+		if _, err := chain.GetBlock(block.PrevHash); err != nil {
+			if err := checkOrphan(block); err != nil {
+				s.mu.Unlock()
+				fmt.Println(err)
+				return []blockFailure{{source, block.Hash, err}}
+			}
+			var missing []byte
+			if orphans.Add(block, source) {
+				fmt.Printf("Block %x is an orphan\n", block.Hash)
+				//an ancestor of the header chain is downloaded already, the orphan only has to wait for it
+				if ancestor := orphans.MissingAncestor(block.Hash); ancestor != nil && !s.hasHeader(ancestor) {
+					missing = ancestor
+				}
+			}
+			s.mu.Unlock()
+			if missing != nil {
+				SendGetHeaders(source, chain.Locator(), missing)
+			}
+			return nil
+		}
+		var failures []blockFailure
+		if err := connectBlock(block, chain); err != nil {
+			fmt.Println(err)
+			failures = append(failures, blockFailure{source, block.Hash, err})
+		} else {
+			failures = s.connectOrphans(block.Hash, chain)
+		}
+		synced := len(s.queue) == 0
+		s.mu.Unlock()
+		announceTip(tip, chain, synced, source)
+		return failures
+	}
+	s.received[hash] = receivedBlock{block, source}
+	var failures []blockFailure
+	for len(s.queue) > 0 {
+		next, ok := s.received[s.queue[0]]
+		if !ok {
+			break
+		}
+		if err := connectBlock(next.block, chain); err != nil {
+			fmt.Println(err)
+			failures = append(failures, blockFailure{next.from, next.block.Hash, err})
+			s.dropQueue()
+			break
+		}
+		failures = append(failures, s.connectOrphans(next.block.Hash, chain)...)
+		delete(s.received, s.queue[0])
+		delete(s.headers, s.queue[0])
+		s.queue = s.queue[1:]
+	}
+	synced := len(s.queue) == 0
+	s.mu.Unlock()
+	s.requestBlocks(chain, source)
+	announceTip(tip, chain, synced, source)
+	//the headers may have stopped at maxHeadersAhead, asking again costs an empty reply when they did not
+	if synced && !bytes.Equal(tip, chain.LastHash) {
+		SendGetHeaders(source, chain.Locator(), nil)
+	}
+	return failures
+}
+
+func announceTip(oldTip []byte, chain *blockchain.Blockchain, synced bool, source string) {
+	if synced && !bytes.Equal(oldTip, chain.LastHash) {
+		relay.Block(chain.LastHash, source)
+	}
+}
+
+func (s *syncState) hasHeader(hash []byte) bool {
+	_, ok := s.headers[hex.EncodeToString(hash)]
+	return ok
+}
+
+//connects the orphans waiting on parent, then the ones waiting on those, an orphan that fails leaves its own orphans to expire
+func (s *syncState) connectOrphans(parent []byte, chain *blockchain.Blockchain) []blockFailure {
+	var failures []blockFailure
+	parents := [][]byte{parent}
+	for len(parents) > 0 {
+		for _, orphan := range orphans.Children(parents[0]) {
+			if err := connectBlock(orphan.block, chain); err != nil {
+				fmt.Printf("Orphan block from %s: %s\n", orphan.from, err)
+				failures = append(failures, blockFailure{orphan.from, orphan.block.Hash, err})
+				continue
+			}
+			parents = append(parents, orphan.block.Hash)
+		}
+		parents = parents[1:]
+	}
+	return failures
+}
+
+func (s *syncState) dropQueue() {
+	for _, hash := range s.queue {
+		delete(s.headers, hash)
+		delete(s.received, hash)
+		delete(s.inFlight, hash)
+	}
+	s.queue = nil
+}
+
+/*
+adds block to the chain and brings the UTXO set and the mempool up to date, the caller holds syncer.mu
+a block on a side branch is only stored, the mempool is about the chain we are on
+a block that moves the tip to another branch has the UTXO set undo the blocks that left the chain and apply the new ones, it is only rebuilt when that fails
+and the mempool is built again with the transactions of the blocks that left the chain
+*/
+func connectBlock(block *blockchain.Block, chain *blockchain.Blockchain) error {
+	if _, err := chain.GetBlock(block.Hash); err == nil {
+		return nil
+	}
+	oldTip := chain.LastHash
+	if err := chain.AddBlock(block); err != nil {
+		return err
+	}
+	if !bytes.Equal(chain.LastHash, block.Hash) {
+		fmt.Printf("Added block %x on a side branch\n", block.Hash)
+		return nil
+	}
+	fmt.Printf("Added block %x\n", block.Hash)
+	UTXOSet := blockchain.UTXOSet{chain}
+	if bytes.Equal(block.PrevHash, oldTip) {
+		UTXOSet.Update(block)
+		memoryPool.BlockConnected(block)
+		return nil
+	}
+	disconnected, connected, err := chain.Branches(oldTip, block.Hash)
+	if err != nil {
+		fmt.Printf("Could not find the blocks that left the chain, rebuilding the UTXO set: %s\n", err)
+		UTXOSet.Reindex()
+		return nil
+	}
+	if err := UTXOSet.Reorganize(disconnected, connected); err != nil {
+		fmt.Printf("Could not move the UTXO set to the new branch, rebuilding it: %s\n", err)
+		UTXOSet.Reindex()
+	}
+	kept, dropped := memoryPool.Reorganized(disconnected)
+	fmt.Printf("Switched to the branch of %x, %d blocks left the chain, the mempool has %d transactions and dropped %d\n", block.Hash, len(disconnected), kept, dropped)
+	return nil
+}
+
+func (s *syncState) setPeerHeight(peer string, height int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peerHeights[peer] = height
+}
+
+//the next headers of peer that are not a full message have to end at the height it told us in its version message
+func (s *syncState) expectHeight(peer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkHeight[peer] = true
+}
+
+/*
+false when we expected the headers to reach the height peer told us and they end below it, together with both heights
+a reply with no headers means the tip of peer is on our chain, so it can not be above our height
+*/
+func (s *syncState) heightReached(peer string, headers []blockchain.BlockHeader, chain *blockchain.Blockchain) (int, int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.checkHeight[peer] {
+		return 0, 0, true
+	}
+	delete(s.checkHeight, peer)
+	claimed := s.peerHeights[peer]
+	reached := chain.GetBestHeight()
+	if len(headers) > 0 {
+		reached = headers[len(headers)-1].Height
+	}
+	if reached >= claimed {
+		return claimed, reached, true
+	}
+	s.peerHeights[peer] = reached
+	return claimed, reached, false
+}
+
+//forgets what we know about peer and lets the blocks we are waiting for from it be asked from another peer right away
+func (s *syncState) forgetPeer(peer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.peerHeights, peer)
+	delete(s.checkHeight, peer)
+	for hash, req := range s.inFlight {
+		if req.peer == peer {
+			s.inFlight[hash] = blockRequest{peer, time.Time{}}
+		}
+	}
+}
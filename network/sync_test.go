@@ -0,0 +1,155 @@
+package network
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+	"github.com/RavjotSandhu/GoBlockchain/wallet"
+)
+
+//a new chain of only the genesis block in a temporary directory, the working directory is restored when the test ends
+func newTestChain(t *testing.T) *blockchain.Blockchain {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".tmp", "blocks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	chain := blockchain.InitBlockchain(string(wallet.MakeWallet().Address()))
+	t.Cleanup(func() {
+		chain.Database.Close()
+		os.Chdir(cwd)
+	})
+	return chain
+}
+
+//an empty state like syncer has at startup, so tests do not share it
+func newSyncState() *syncState {
+	return &syncState{
+		headers:     make(map[string]blockchain.BlockHeader),
+		inFlight:    make(map[string]blockRequest),
+		received:    make(map[string]receivedBlock),
+		peerHeights: make(map[string]int),
+		checkHeight: make(map[string]bool),
+	}
+}
+
+//headers of n blocks with valid work following parent, which is at height, the blocks themselves are not stored
+func headerChain(parent []byte, height, n int) []blockchain.BlockHeader {
+	var headers []blockchain.BlockHeader
+	for i := 1; i <= n; i++ {
+		coinbase := blockchain.CoinbaseTx(string(wallet.MakeWallet().Address()), "")
+		block := blockchain.CreateBlock([]*blockchain.Transaction{coinbase}, parent, height+i)
+		headers = append(headers, block.Header())
+		parent = block.Hash
+	}
+	return headers
+}
+
+func queued(s *syncState) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.queue...)
+}
+
+func TestAddHeadersRejectsWrongHeightOrNoWork(t *testing.T) {
+	chain := newTestChain(t)
+	s := newSyncState()
+	headers := headerChain(chain.LastHash, 0, 2)
+
+	//valid work on top of the second header, but at a height that does not follow it
+	skipping := headerChain(headers[1].Hash, 3, 1)[0]
+	if accepted, invalid, full := s.addHeaders(append(headers, skipping), chain); accepted != 2 || !invalid || full {
+		t.Fatalf("accepted %d, invalid %v, full %v, expected the header at the wrong height to be invalid", accepted, invalid, full)
+	}
+
+	unworked := headerChain(headers[1].Hash, 2, 1)[0]
+	unworked.Nonce++
+	if accepted, invalid, _ := s.addHeaders([]blockchain.BlockHeader{unworked}, chain); accepted != 0 || !invalid {
+		t.Fatal("a header without valid proof of work was accepted")
+	}
+
+	//a header we can not link up is not invalid, the ones before it may still be on their way
+	unconnected := headerChain(missingParent(0), 5, 1)
+	if accepted, invalid, full := s.addHeaders(unconnected, chain); accepted != 0 || invalid || full {
+		t.Fatalf("a header that does not connect gave accepted %d, invalid %v, full %v", accepted, invalid, full)
+	}
+	queue := queued(s)
+	if len(queue) != 2 || queue[0] != hex.EncodeToString(headers[0].Hash) || queue[1] != hex.EncodeToString(headers[1].Hash) {
+		t.Fatalf("the download queue is %v, expected the two valid headers", queue)
+	}
+}
+
+func TestAddHeadersStopsAtMaxHeadersAhead(t *testing.T) {
+	chain := newTestChain(t)
+	s := newSyncState()
+	headers := headerChain(chain.LastHash, 0, 1)
+	//its work and parent do not matter, headers that far ahead are not even looked at
+	ahead := headerChain(headers[0].Hash, maxHeadersAhead, 1)[0]
+	accepted, invalid, full := s.addHeaders(append(headers, ahead), chain)
+	if accepted != 1 || invalid || !full {
+		t.Fatalf("accepted %d, invalid %v, full %v, expected the header past maxHeadersAhead to end the check", accepted, invalid, full)
+	}
+	if _, ok := s.headers[hex.EncodeToString(ahead.Hash)]; ok || len(s.headers) != 1 {
+		t.Fatal("the header past maxHeadersAhead was kept")
+	}
+}
+
+func TestAddHeadersPrunesTheLosingBranch(t *testing.T) {
+	chain := newTestChain(t)
+	s := newSyncState()
+	losing := headerChain(chain.LastHash, 0, 2)
+	if accepted, _, _ := s.addHeaders(losing, chain); accepted != 2 {
+		t.Fatalf("accepted %d headers of the first branch", accepted)
+	}
+	losingHash := hex.EncodeToString(losing[0].Hash)
+	s.inFlight[losingHash] = blockRequest{"localhost:1", time.Now()}
+	s.received[hex.EncodeToString(losing[1].Hash)] = receivedBlock{}
+
+	winning := headerChain(chain.LastHash, 0, 3)
+	if accepted, _, _ := s.addHeaders(winning, chain); accepted != 3 {
+		t.Fatalf("accepted %d headers of the longer branch", accepted)
+	}
+	queue := queued(s)
+	if len(queue) != 3 || queue[2] != hex.EncodeToString(winning[2].Hash) {
+		t.Fatalf("the download queue is %v, expected the longer branch", queue)
+	}
+	if len(s.headers) != 3 || len(s.inFlight) != 0 || len(s.received) != 0 {
+		t.Fatalf("%d headers, %d requests and %d blocks left after the branch was pruned", len(s.headers), len(s.inFlight), len(s.received))
+	}
+}
+
+func TestRetryTimedOutAsksAnotherPeer(t *testing.T) {
+	s := newSyncState()
+	slow, other := sink(t), sink(t)
+	for _, peer := range []string{slow, other} {
+		addKnownNode(peer)
+		s.peerHeights[peer] = 10
+	}
+	t.Cleanup(func() {
+		removeKnownNode(slow)
+		removeKnownNode(other)
+	})
+	late, pending := hex.EncodeToString(missingParent(0)), hex.EncodeToString(missingParent(1))
+	s.headers[late] = blockchain.BlockHeader{Hash: missingParent(0), Height: 5}
+	s.headers[pending] = blockchain.BlockHeader{Hash: missingParent(1), Height: 6}
+	s.inFlight[late] = blockRequest{slow, time.Now().Add(-blockTimeout - time.Second)}
+	s.inFlight[pending] = blockRequest{slow, time.Now()}
+
+	s.retryTimedOut()
+	if req := s.inFlight[late]; req.peer != other || time.Since(req.sent) > time.Second {
+		t.Fatalf("the late block was asked for again from %s, expected %s", req.peer, other)
+	}
+	if s.inFlight[pending].peer != slow {
+		t.Fatal("a block that still has time was asked for from another peer")
+	}
+}
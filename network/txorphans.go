@@ -0,0 +1,160 @@
+package network
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+)
+
+/*
+orphan transactions
+a transaction spending an output we do not know of may just have overtaken its parent, every transaction travels on its own connection
+it is kept here under the IDs of the transactions it spends from and tried again once one of them is accepted into the pool
+the pool is bounded like the one of orphan blocks, a peer can only fill a part of it and an orphan whose parents never show up expires
+*/
+const (
+	maxOrphanTxs        = 100        //orphan transactions kept at most
+	maxOrphanTxsPerPeer = 20         //orphan transactions kept at most from one peer
+	maxOrphanTxSize     = 100 * 1024 //bytes of the largest orphan transaction kept
+)
+
+type orphanTx struct {
+	tx    *blockchain.Transaction
+	from  string //peer that sent the transaction, it is relayed to everyone else once accepted
+	added time.Time
+}
+
+type orphanTxPool struct {
+	mu       sync.Mutex
+	byID     map[string]*orphanTx
+	byParent map[string][]string //ID of a transaction spent from -> IDs of the orphans waiting on it
+	byPeer   map[string]int      //orphans kept per peer address, nodes sharing a host each have their own
+}
+
+var orphanTxs = newOrphanTxPool()
+
+func newOrphanTxPool() *orphanTxPool {
+	return &orphanTxPool{
+		byID:     make(map[string]*orphanTx),
+		byParent: make(map[string][]string),
+		byPeer:   make(map[string]int),
+	}
+}
+
+//the IDs of the transactions tx spends from, each once
+func parentIDs(tx *blockchain.Transaction) []string {
+	var parents []string
+	seen := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		id := hex.EncodeToString(in.ID)
+		if !seen[id] {
+			seen[id] = true
+			parents = append(parents, id)
+		}
+	}
+	return parents
+}
+
+func (op *orphanTxPool) Has(id []byte) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	_, ok := op.byID[hex.EncodeToString(id)]
+	return ok
+}
+
+//keeps tx until a transaction it spends from is accepted, false when it is already kept or too large
+func (op *orphanTxPool) Add(tx *blockchain.Transaction, from string) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := op.byID[id]; ok {
+		return false
+	}
+	if len(tx.Serialize()) > maxOrphanTxSize {
+		return false
+	}
+	op.expire()
+	for op.byPeer[from] >= maxOrphanTxsPerPeer {
+		op.remove(op.oldest(from))
+	}
+	for len(op.byID) >= maxOrphanTxs {
+		op.remove(op.oldest(""))
+	}
+	op.byID[id] = &orphanTx{tx, from, time.Now()}
+	for _, parent := range parentIDs(tx) {
+		op.byParent[parent] = append(op.byParent[parent], id)
+	}
+	op.byPeer[from]++
+	return true
+}
+
+//takes the orphans spending from parent out of the pool
+func (op *orphanTxPool) Children(parent []byte) []*orphanTx {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	var children []*orphanTx
+	for _, id := range op.byParent[hex.EncodeToString(parent)] {
+		if orphan, ok := op.byID[id]; ok {
+			children = append(children, orphan)
+			op.remove(id)
+		}
+	}
+	return children
+}
+
+func (op *orphanTxPool) Expire() {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.expire()
+}
+
+func (op *orphanTxPool) expire() {
+	for id, orphan := range op.byID {
+		if time.Since(orphan.added) > orphanExpiry {
+			fmt.Printf("Orphan transaction %s expired\n", id)
+			op.remove(id)
+		}
+	}
+}
+
+//the oldest orphan of peer, of any peer when it is empty
+func (op *orphanTxPool) oldest(peer string) string {
+	var oldest string
+	var added time.Time
+	for id, orphan := range op.byID {
+		if peer != "" && orphan.from != peer {
+			continue
+		}
+		if oldest == "" || orphan.added.Before(added) {
+			oldest, added = id, orphan.added
+		}
+	}
+	return oldest
+}
+
+func (op *orphanTxPool) remove(id string) {
+	orphan, ok := op.byID[id]
+	if !ok {
+		return
+	}
+	delete(op.byID, id)
+	if op.byPeer[orphan.from]--; op.byPeer[orphan.from] <= 0 {
+		delete(op.byPeer, orphan.from)
+	}
+	for _, parent := range parentIDs(orphan.tx) {
+		var waiting []string
+		for _, other := range op.byParent[parent] {
+			if other != id {
+				waiting = append(waiting, other)
+			}
+		}
+		if len(waiting) == 0 {
+			delete(op.byParent, parent)
+		} else {
+			op.byParent[parent] = waiting
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/RavjotSandhu/GoBlockchain/blockchain"
+)
+
+//a transaction spending output 0 of every parent, only its ID and inputs matter to the orphan pool
+func spendingTx(id byte, parents ...[]byte) *blockchain.Transaction {
+	var inputs []blockchain.TxInput
+	for _, parent := range parents {
+		inputs = append(inputs, blockchain.TxInput{parent, 0, nil, nil, blockchain.SequenceFinal, nil})
+	}
+	return &blockchain.Transaction{[]byte{id}, inputs, nil, 0}
+}
+
+func TestOrphanTxWaitsForEveryParent(t *testing.T) {
+	pool := newOrphanTxPool()
+	first, second := missingParent(0), missingParent(1)
+	orphan := spendingTx(1, first, second, first)
+	if !pool.Add(orphan, "localhost:1") || pool.Add(orphan, "localhost:1") {
+		t.Fatal("an orphan transaction was not kept exactly once")
+	}
+	if children := pool.Children(missingParent(2)); len(children) != 0 {
+		t.Fatalf("%d orphans wait on a transaction none of them spends from", len(children))
+	}
+	children := pool.Children(second)
+	if len(children) != 1 || children[0].tx != orphan {
+		t.Fatal("the orphan did not come out when one of its parents arrived")
+	}
+	if pool.Has(orphan.ID) || len(pool.Children(first)) != 0 || len(pool.byParent) != 0 {
+		t.Fatal("the orphan is still kept under its other parent")
+	}
+}
+
+func TestOrphanTxsPerPeerAreCapped(t *testing.T) {
+	pool := newOrphanTxPool()
+	for i := 0; i <= maxOrphanTxsPerPeer; i++ {
+		pool.Add(spendingTx(byte(i), missingParent(i)), "localhost:1")
+	}
+	//another node on the same host has a quota of its own
+	pool.Add(spendingTx(255, missingParent(255)), "localhost:2")
+	if pool.byPeer["localhost:1"] != maxOrphanTxsPerPeer || pool.byPeer["localhost:2"] != 1 {
+		t.Fatalf("peers keep %d and %d orphan transactions", pool.byPeer["localhost:1"], pool.byPeer["localhost:2"])
+	}
+	if pool.Has([]byte{0}) {
+		t.Fatal("the oldest orphan transaction of the peer was not the one dropped")
+	}
+}
@@ -0,0 +1,166 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+bech32 as described in BIP173, a human readable prefix, the separator 1 and the data in a 32 character alphabet followed by a 6 character checksum
+the checksum is a BCH code, it catches every error of up to four characters and when a single character is wrong we can tell which one
+*/
+const (
+	bech32Charset     = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32ChecksumLen = 6
+	bech32MaxLength   = 90
+)
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+var ErrBech32Checksum = errors.New("Invalid bech32 checksum")
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+func bech32VerifyChecksum(hrp string, data []byte) bool {
+	return bech32Polymod(append(bech32HRPExpand(hrp), data...)) == 1
+}
+
+func bech32Checksum(hrp string, data []byte) []byte {
+	values := append(bech32HRPExpand(hrp), data...)
+	values = append(values, make([]byte, bech32ChecksumLen)...)
+	polymod := bech32Polymod(values) ^ 1
+	checksum := make([]byte, bech32ChecksumLen)
+	for i := range checksum {
+		checksum[i] = byte(polymod>>uint(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+//data holds 5 bit values, use convertBits to get them from bytes
+func Bech32Encode(hrp string, data []byte) (string, error) {
+	if len(hrp)+1+len(data)+bech32ChecksumLen > bech32MaxLength {
+		return "", errors.New("Data is too long for bech32")
+	}
+	combined := append(append([]byte{}, data...), bech32Checksum(hrp, data)...)
+	var encoded strings.Builder
+	encoded.WriteString(hrp)
+	encoded.WriteByte('1')
+	for _, v := range combined {
+		if v > 31 {
+			return "", errors.New("Bech32 data values must be 5 bits")
+		}
+		encoded.WriteByte(bech32Charset[v])
+	}
+	return encoded.String(), nil
+}
+
+//returns the prefix and the 5 bit values without the checksum
+func Bech32Decode(encoded string) (string, []byte, error) {
+	if len(encoded) > bech32MaxLength {
+		return "", nil, errors.New("Bech32 string is too long")
+	}
+	if strings.ToLower(encoded) != encoded && strings.ToUpper(encoded) != encoded {
+		return "", nil, errors.New("Bech32 string mixes upper and lower case")
+	}
+	encoded = strings.ToLower(encoded)
+	sep := strings.LastIndexByte(encoded, '1')
+	if sep < 1 || sep+1+bech32ChecksumLen > len(encoded) {
+		return "", nil, errors.New("Bech32 string has no prefix or is too short")
+	}
+	hrp := encoded[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, fmt.Errorf("Invalid character in bech32 prefix at position %d", i)
+		}
+	}
+	data := make([]byte, 0, len(encoded)-sep-1)
+	for i := sep + 1; i < len(encoded); i++ {
+		v := strings.IndexByte(bech32Charset, encoded[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("Invalid bech32 character %q at position %d", encoded[i], i)
+		}
+		data = append(data, byte(v))
+	}
+	if !bech32VerifyChecksum(hrp, data) {
+		if pos := bech32LocateError(hrp, data); pos >= 0 {
+			return "", nil, fmt.Errorf("%s, the character at position %d is wrong", ErrBech32Checksum, sep+1+pos)
+		}
+		return "", nil, ErrBech32Checksum
+	}
+	return hrp, data[:len(data)-bech32ChecksumLen], nil
+}
+
+//tries every replacement of every character, when exactly one position can be fixed that is where the typo is, -1 otherwise
+func bech32LocateError(hrp string, data []byte) int {
+	found := -1
+	candidate := append([]byte{}, data...)
+	for i := range candidate {
+		original := candidate[i]
+		for v := byte(0); v < 32; v++ {
+			if v == original {
+				continue
+			}
+			candidate[i] = v
+			if bech32VerifyChecksum(hrp, candidate) {
+				if found >= 0 && found != i {
+					return -1
+				}
+				found = i
+			}
+		}
+		candidate[i] = original
+	}
+	return found
+}
+
+//regroups data from fromBits to toBits wide values, pad adds zero bits at the end instead of failing on leftovers
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<toBits - 1
+	var converted []byte
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			return nil, errors.New("Invalid data value for bit conversion")
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			converted = append(converted, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			converted = append(converted, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("Invalid padding in bech32 data")
+	}
+	return converted, nil
+}
@@ -0,0 +1,83 @@
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+//valid strings of BIP173, the prefix is not one of ours so only the encoding is checked
+func TestBech32ReferenceStrings(t *testing.T) {
+	for _, encoded := range []string{"A12UEL5L", "a12uel5l", "abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", "split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w"} {
+		hrp, data, err := Bech32Decode(encoded)
+		if err != nil {
+			t.Fatalf("%s: %v", encoded, err)
+		}
+		reencoded, err := Bech32Encode(hrp, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reencoded != strings.ToLower(encoded) {
+			t.Fatalf("%s encoded back as %s", encoded, reencoded)
+		}
+	}
+}
+
+//every wrong character is caught and reported at the position it is at
+func TestBech32LocatesSingleCharacterError(t *testing.T) {
+	address := string(MakeWallet().Address())
+	sep := strings.LastIndexByte(address, '1')
+	for i := sep + 1; i < len(address); i++ {
+		for _, c := range bech32Charset {
+			if byte(c) == address[i] {
+				continue
+			}
+			typo := address[:i] + string(c) + address[i+1:]
+			_, err := DecodeAddress(typo)
+			if err == nil {
+				t.Fatalf("%s with a typo at %d decoded", typo, i)
+			}
+			if expected := fmt.Sprintf("position %d is wrong", i); !strings.HasPrefix(err.Error(), ErrBech32Checksum.Error()) || !strings.Contains(err.Error(), expected) {
+				t.Fatalf("%s failed with %q, expected %q", typo, err, expected)
+			}
+		}
+	}
+}
+
+func TestBech32RejectsMixedCase(t *testing.T) {
+	address := string(MakeWallet().Address())
+	if _, err := DecodeAddress(strings.ToUpper(address)); err != nil {
+		t.Fatalf("upper case address failed with %v", err)
+	}
+	//the last letter stays lower case, the address can end in a digit
+	last := strings.LastIndexAny(address, "abcdefghijklmnopqrstuvwxyz")
+	mixed := strings.ToUpper(address[:last]) + address[last:]
+	if _, err := DecodeAddress(mixed); err == nil || !strings.Contains(err.Error(), "mixes upper and lower case") {
+		t.Fatalf("%s decoded with %v", mixed, err)
+	}
+}
+
+func TestDecodeAddressAcceptsBase58(t *testing.T) {
+	w := MakeWallet()
+	old := string(PubKeyHashToBase58Address(w.PubKeyHash()))
+	pubKeyHash, err := DecodeAddress(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pubKeyHash, w.PubKeyHash()) {
+		t.Fatalf("%s decoded to %x, expected %x", old, pubKeyHash, w.PubKeyHash())
+	}
+	if normalized, err := NormalizeAddress(old); err != nil || normalized != string(w.Address()) {
+		t.Fatalf("%s normalized to %s with %v, expected %s", old, normalized, err, w.Address())
+	}
+	typo := []byte(old)
+	if typo[5] == 'z' {
+		typo[5] = 'y'
+	} else {
+		typo[5] = 'z'
+	}
+	if _, err := DecodeAddress(string(typo)); err == nil {
+		t.Fatalf("base58 address with a typo %s decoded", typo)
+	}
+}
@@ -0,0 +1,85 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+//scrypt parameters for turning the passphrase into the key that seals the private keys
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	keyLength    = 32
+	saltLength   = 16
+	nonceLength  = 12
+	scalarLength = 32 //length of a P-256 private key
+)
+
+var ErrWrongPassphrase = errors.New("The wallet passphrase is not correct")
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keyLength)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//AES-GCM with a random nonce in front of the result, aad is authenticated along with the plaintext
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceLength)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < nonceLength {
+		return nil, ErrWrongPassphrase
+	}
+	plaintext, err := aead.Open(nil, sealed[:nonceLength], sealed[nonceLength:], aad)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+//the public key is authenticated with the private key so a sealed key can not be moved to another entry
+func sealKey(key []byte, w *Wallet) ([]byte, error) {
+	return seal(key, w.privateKeyBytes(), w.PublicKey)
+}
+
+func openKey(key []byte, w *Wallet) error {
+	scalar, err := open(key, w.encryptedKey, w.PublicKey)
+	if err != nil {
+		return err
+	}
+	return w.setPrivateKey(scalar)
+}
@@ -0,0 +1,224 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/gob"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//runs the test in a temporary directory so the wallet file of the test lands there, the working directory is restored when it ends
+func inTempDir(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(walletFile)), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+//an encrypted wallet file with a key of every scheme, what the private keys were before encryption is returned by address
+func encryptedWallets(t *testing.T, passphrase string) (*Wallets, map[string][]byte) {
+	inTempDir(t)
+	wallets := &Wallets{Wallets: map[string]*Wallet{}}
+	for id := range Schemes {
+		if _, err := wallets.AddKey(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := map[string][]byte{}
+	for address, w := range wallets.Wallets {
+		keys[address] = w.privateKeyBytes()
+	}
+	if err := wallets.Encrypt(passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	return wallets, keys
+}
+
+func TestEncryptedWalletRoundTrip(t *testing.T) {
+	_, keys := encryptedWallets(t, "correct horse")
+	content, err := ioutil.ReadFile(walletFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for address, key := range keys {
+		if bytes.Contains(content, key) {
+			t.Fatalf("the private key of %s is in the wallet file in the clear", address)
+		}
+	}
+
+	wallets, err := CreateWallets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wallets.IsLocked() {
+		t.Fatal("a wallet loaded from an encrypted file is not locked")
+	}
+	digest := sha256.Sum256([]byte("digest"))
+	for address := range keys {
+		if _, err := wallets.GetWallet(address).SignDigest(digest[:]); err != ErrWalletLocked {
+			t.Fatalf("locked key of %s signed with %v", address, err)
+		}
+	}
+
+	if err := wallets.Unlock("correct horse", 0); err != nil {
+		t.Fatal(err)
+	}
+	for address, key := range keys {
+		w := wallets.GetWallet(address)
+		if !bytes.Equal(w.privateKeyBytes(), key) {
+			t.Fatalf("the key of %s changed in the round trip", address)
+		}
+		sig, err := w.SignDigest(digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifySignature(w.PublicKey, digest[:], sig) {
+			t.Fatalf("the unlocked key of %s made an invalid signature", address)
+		}
+	}
+}
+
+func TestUnlockWithWrongPassphrase(t *testing.T) {
+	wallets, _ := encryptedWallets(t, "correct horse")
+	if err := wallets.Unlock("battery staple", 0); err != ErrWrongPassphrase {
+		t.Fatalf("unlocking with the wrong passphrase gave %v", err)
+	}
+	if !wallets.IsLocked() {
+		t.Fatal("the wallet is unlocked after a wrong passphrase")
+	}
+	for address, w := range wallets.Wallets {
+		if !w.IsLocked() {
+			t.Fatalf("the key of %s was left in memory after a wrong passphrase", address)
+		}
+	}
+}
+
+func TestUnlockRejectsTamperedKeys(t *testing.T) {
+	wallets, _ := encryptedWallets(t, "correct horse")
+	var entries []*Wallet
+	for _, w := range wallets.Wallets {
+		entries = append(entries, w)
+	}
+
+	sealed := entries[0].encryptedKey
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+	entries[0].encryptedKey = tampered
+	if err := wallets.Unlock("correct horse", 0); err != ErrWrongPassphrase {
+		t.Fatalf("a changed ciphertext unlocked with %v", err)
+	}
+
+	//a sealed key is bound to its public key, moved to another entry it does not open
+	entries[0].encryptedKey = sealed
+	entries[1].encryptedKey, entries[0].encryptedKey = entries[0].encryptedKey, entries[1].encryptedKey
+	if err := wallets.Unlock("correct horse", 0); err != ErrWrongPassphrase {
+		t.Fatalf("keys swapped between entries unlocked with %v", err)
+	}
+	entries[1].encryptedKey, entries[0].encryptedKey = entries[0].encryptedKey, entries[1].encryptedKey
+	if err := wallets.Unlock("correct horse", 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockTimerWipesKeys(t *testing.T) {
+	wallets, _ := encryptedWallets(t, "correct horse")
+	if err := wallets.Unlock("correct horse", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	//copies handed out before the timeout share the scalars, they have to be overwritten and not only dropped
+	var scalars []*big.Int
+	for _, w := range wallets.Wallets {
+		if w.IsLocked() {
+			t.Fatal("a key is locked right after unlocking")
+		}
+		if w.Scheme != SchemeEd25519 {
+			scalars = append(scalars, w.PrivateKey.D)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !wallets.IsLocked() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !wallets.IsLocked() {
+		t.Fatal("the wallet is still unlocked after its timeout")
+	}
+	for address, w := range wallets.Wallets {
+		if !w.IsLocked() || w.edKey != nil || w.PrivateKey.D != nil {
+			t.Fatalf("the key of %s is still in memory after the timeout", address)
+		}
+	}
+	for _, d := range scalars {
+		if d.Sign() != 0 {
+			t.Fatal("a private scalar was left in memory after the timeout")
+		}
+	}
+}
+
+//the curve as Go versions before 1.19 wrote it into a gob, which is how the first wallet files hold it
+type legacyGobCurve struct {
+	*elliptic.CurveParams
+}
+
+func TestLoadUnencryptedLegacyWalletFile(t *testing.T) {
+	inTempDir(t)
+	gob.RegisterName("crypto/elliptic.p256Curve", legacyGobCurve{})
+	type legacyWallet struct {
+		PrivateKey ecdsa.PrivateKey
+		PublicKey  []byte
+	}
+	private, _ := NewKeyPair()
+	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	private.PublicKey.Curve = legacyGobCurve{elliptic.P256().Params()}
+	address := string(PubKeyHashToBase58Address(PublicKeyHash(pubKey)))
+	var buffer bytes.Buffer
+	legacy := struct{ Wallets map[string]*legacyWallet }{map[string]*legacyWallet{address: {private, pubKey}}}
+	if err := gob.NewEncoder(&buffer).Encode(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(walletFile, buffer.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	wallets, err := CreateWallets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallets.IsEncrypted() || len(wallets.Wallets) != 1 {
+		t.Fatalf("legacy file loaded as %d keys, encrypted %v", len(wallets.Wallets), wallets.IsEncrypted())
+	}
+	//the wallet keeps it under the address in the current form
+	w, ok := wallets.Lookup(string(PubKeyHashToAddress(PublicKeyHash(pubKey))))
+	if !ok {
+		t.Fatalf("the key of %s is missing", address)
+	}
+	if w.Scheme != 0 || !bytes.Equal(w.PublicKey, pubKey) || w.PrivateKey.D.Cmp(private.D) != 0 {
+		t.Fatal("the legacy key was not read as it was written")
+	}
+	digest := sha256.Sum256([]byte("digest"))
+	sig, err := w.SignDigest(digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifySignature(w.PublicKey, digest[:], sig) {
+		t.Fatal("the legacy key made an invalid signature")
+	}
+}
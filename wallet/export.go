@@ -0,0 +1,167 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+
+	"github.com/mr-tron/base58"
+)
+
+/*
+a single key can be moved between wallets in two formats
+the WIF-like string is base58 of a version byte, the private key, a byte naming the key type and a checksum, the same checksum addresses use
+the type byte of an old ECDSA key is keyTypeP256, keys of a scheme have keyTypeScheme with the id of the scheme in the low bits
+PEM holds the key as PKCS8 so it can be read by other tools as well
+PKCS8 can not tell which encoding or scheme a P-256 key is used with, so when it is not an old ECDSA key a Key-Type line is written in front of the block
+*/
+const (
+	privateKeyVersion = byte(0x80)
+	keyTypeP256       = byte(0x01)
+	keyTypeScheme     = byte(0x80)
+	pemBlockType      = "PRIVATE KEY"
+	pemKeyTypeHeader  = "Key-Type:"
+	pemKeyTypeLegacy  = "ecdsa-legacy"
+)
+
+var (
+	ErrInvalidPrivateKey = errors.New("Invalid private key")
+	ErrKeyExists         = errors.New("The key is already in the wallet")
+)
+
+func (w Wallet) ExportWIF() (string, error) {
+	if w.WatchOnly {
+		return "", ErrWatchOnly
+	}
+	if w.IsLocked() {
+		return "", ErrWalletLocked
+	}
+	payload := append([]byte{privateKeyVersion}, w.privateKeyBytes()...)
+	if w.Scheme == 0 {
+		payload = append(payload, keyTypeP256)
+	} else {
+		payload = append(payload, keyTypeScheme|byte(w.Scheme))
+	}
+	payload = append(payload, Checksum(payload)...)
+	return base58.Encode(payload), nil
+}
+
+func ParseWIF(encoded string) (*Wallet, error) {
+	payload, err := base58.Decode(strings.TrimSpace(encoded))
+	if err != nil || len(payload) != 1+scalarLength+1+checksumLength {
+		return nil, ErrInvalidPrivateKey
+	}
+	data, checksum := payload[:len(payload)-checksumLength], payload[len(payload)-checksumLength:]
+	if !bytes.Equal(Checksum(data), checksum) {
+		return nil, errors.New("Private key checksum does not match")
+	}
+	if data[0] != privateKeyVersion {
+		return nil, errors.New("Unknown private key version")
+	}
+	keyType := data[len(data)-1]
+	if keyType == keyTypeP256 {
+		return walletFromKey(0, data[1:1+scalarLength])
+	}
+	if _, ok := Schemes[SchemeID(keyType&^keyTypeScheme)]; keyType&keyTypeScheme == 0 || !ok {
+		return nil, errors.New("Unsupported private key type")
+	}
+	return walletFromKey(SchemeID(keyType&^keyTypeScheme), data[1:1+scalarLength])
+}
+
+func (w Wallet) ExportPEM() ([]byte, error) {
+	if w.WatchOnly {
+		return nil, ErrWatchOnly
+	}
+	if w.IsLocked() {
+		return nil, ErrWalletLocked
+	}
+	var der []byte
+	var err error
+	if w.Scheme == SchemeEd25519 {
+		der, err = x509.MarshalPKCS8PrivateKey(w.edKey)
+	} else {
+		der, err = x509.MarshalPKCS8PrivateKey(&w.PrivateKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block := pem.EncodeToMemory(&pem.Block{Type: pemBlockType, Bytes: der})
+	if w.Scheme == 0 || w.Scheme == SchemeEd25519 {
+		return block, nil
+	}
+	return append([]byte(pemKeyTypeHeader+" "+w.scheme().Name()+"\n"), block...), nil
+}
+
+//reads a PKCS8 key, or a SEC1 "EC PRIVATE KEY" as openssl writes it by default, P-256 keys without a Key-Type line are old ECDSA keys
+func ParsePEM(data []byte) (*Wallet, error) {
+	scheme, err := pemKeyType(data)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+	var key interface{}
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if edKey, ok := key.(ed25519.PrivateKey); ok {
+		return walletFromKey(SchemeEd25519, edKey.Seed())
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve != elliptic.P256() || scheme == SchemeEd25519 {
+		return nil, errors.New("Only P-256 and Ed25519 keys can be imported")
+	}
+	return walletFromKey(scheme, padScalar(ecKey.D))
+}
+
+//the scheme named by the Key-Type line in front of the PEM block, 0 when there is none
+func pemKeyType(data []byte) (SchemeID, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-----BEGIN") {
+			break
+		}
+		if !strings.HasPrefix(line, pemKeyTypeHeader) {
+			continue
+		}
+		name := strings.TrimSpace(strings.TrimPrefix(line, pemKeyTypeHeader))
+		if name == pemKeyTypeLegacy {
+			return 0, nil
+		}
+		return GetScheme(name)
+	}
+	return 0, nil
+}
+
+//takes either format, PEM is recognised by its header
+func ParsePrivateKey(data []byte) (*Wallet, error) {
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		return ParsePEM(data)
+	}
+	return ParseWIF(string(data))
+}
+
+//adds a key that was exported from another wallet, it is stored like a random key even when it was derived from a seed there
+func (ws *Wallets) ImportKey(wallet *Wallet) (string, error) {
+	address := string(wallet.Address())
+	ws.mu.Lock()
+	existing, exists := ws.Wallets[address]
+	ws.mu.Unlock()
+	//importing the key of a watch-only address turns it into a normal one
+	if exists && !existing.WatchOnly {
+		return address, ErrKeyExists
+	}
+	return ws.addWallet(wallet)
+}
@@ -0,0 +1,107 @@
+package wallet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mr-tron/base58"
+)
+
+//a key of every kind a wallet can hold: an old ECDSA key and one of each scheme
+func testKeys(t *testing.T) map[string]*Wallet {
+	keys := map[string]*Wallet{}
+	scalar, err := newP256Scalar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys["legacy"], err = walletFromKey(0, scalar); err != nil {
+		t.Fatal(err)
+	}
+	for id, scheme := range Schemes {
+		if keys[scheme.Name()], err = NewWallet(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func sameKey(t *testing.T, name string, got, expected *Wallet) {
+	t.Helper()
+	if got.Scheme != expected.Scheme || !bytes.Equal(got.PublicKey, expected.PublicKey) || !bytes.Equal(got.privateKeyBytes(), expected.privateKeyBytes()) {
+		t.Fatalf("%s key changed in the round trip, scheme %d address %s, expected %d and %s", name, got.Scheme, got.Address(), expected.Scheme, expected.Address())
+	}
+}
+
+func TestWIFRoundTrip(t *testing.T) {
+	for name, w := range testKeys(t) {
+		encoded, err := w.ExportWIF()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ParsePrivateKey([]byte(encoded + "\n"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sameKey(t, name, decoded, w)
+	}
+}
+
+func TestWIFRejectsChecksumAndVersion(t *testing.T) {
+	encoded, err := MakeWallet().ExportWIF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := base58.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := append([]byte{}, payload...)
+	corrupted[5] ^= 1
+	if _, err := ParseWIF(base58.Encode(corrupted)); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("a key with a changed byte parsed with %v", err)
+	}
+
+	//a valid checksum over an unknown version
+	data := append([]byte{}, payload[:len(payload)-checksumLength]...)
+	data[0] = 0x81
+	if _, err := ParseWIF(base58.Encode(append(data, Checksum(data)...))); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Fatalf("a key of another version parsed with %v", err)
+	}
+
+	if _, err := ParseWIF(encoded[:len(encoded)-2]); err != ErrInvalidPrivateKey {
+		t.Fatalf("a truncated key parsed with %v", err)
+	}
+}
+
+func TestPEMRoundTrip(t *testing.T) {
+	for name, w := range testKeys(t) {
+		encoded, err := w.ExportPEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		//only the schemes PKCS8 can not tell apart need the Key-Type line
+		if hasType := strings.HasPrefix(string(encoded), pemKeyTypeHeader); hasType != (w.Scheme == SchemeECDSA || w.Scheme == SchemeSchnorr) {
+			t.Fatalf("%s key written with a Key-Type line: %v\n%s", name, hasType, encoded)
+		}
+		decoded, err := ParsePrivateKey(encoded)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sameKey(t, name, decoded, w)
+	}
+}
+
+func TestWatchOnlyKeyCanNotBeExported(t *testing.T) {
+	w, err := NewWatchOnlyAddress(string(MakeWallet().Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.ExportWIF(); err != ErrWatchOnly {
+		t.Fatalf("watch-only WIF export gave %v", err)
+	}
+	if _, err := w.ExportPEM(); err != ErrWatchOnly {
+		t.Fatalf("watch-only PEM export gave %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+/*
+hierarchical deterministic keys, BIP32 does not define P-256 so the derivation follows SLIP-0010 which does:
+the master key comes from HMAC-SHA512 of the seed with the key "Nist256p1 seed" and a child key is the parent key plus the left half of HMAC-SHA512(chain code, parent data || index)
+an index with HardenedOffset added derives from the private key, so leaking a child key and the parent chain code does not leak the parent
+
+addresses are derived at m/44'/1'/account'/chain/index where chain 0 is for receiving and chain 1 for change
+coin type 1 is the one registered for test networks, this chain has no coin type of its own
+*/
+const (
+	HardenedOffset = uint32(0x80000000)
+	ReceiveChain   = uint32(0)
+	ChangeChain    = uint32(1)
+
+	hdPurpose  = uint32(44)
+	hdCoinType = uint32(1)
+)
+
+var masterKeyHMACKey = []byte("Nist256p1 seed")
+
+type ExtendedKey struct {
+	Key       []byte //private scalar
+	ChainCode []byte
+}
+
+//where a key of a deterministic wallet sits in the tree
+type KeyPath struct {
+	Account uint32
+	Chain   uint32
+	Index   uint32
+}
+
+func (p KeyPath) String() string {
+	return fmt.Sprintf("m/%d'/%d'/%d'/%d/%d", hdPurpose, hdCoinType, p.Account, p.Chain, p.Index)
+}
+
+//retries with a new HMAC when the left half is not a valid scalar, which happens with a chance of about 2^-32 on P-256
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.New("Seed must be 16 to 64 bytes")
+	}
+	n := elliptic.P256().Params().N
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, masterKeyHMACKey)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		key := new(big.Int).SetBytes(sum[:32])
+		if key.Sign() != 0 && key.Cmp(n) < 0 {
+			return &ExtendedKey{sum[:32], sum[32:]}, nil
+		}
+		data = sum
+	}
+}
+
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	curve := elliptic.P256()
+	n := curve.Params().N
+	var data []byte
+	if index >= HardenedOffset {
+		data = append([]byte{0}, k.Key...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.Key)
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = append(data, uint32Bytes(index)...)
+
+	for {
+		mac := hmac.New(sha512.New, k.ChainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		tweak := new(big.Int).SetBytes(sum[:32])
+		child := new(big.Int).Add(tweak, new(big.Int).SetBytes(k.Key))
+		child.Mod(child, n)
+		if tweak.Cmp(n) < 0 && child.Sign() != 0 {
+			key := make([]byte, scalarLength)
+			raw := child.Bytes()
+			copy(key[scalarLength-len(raw):], raw)
+			return &ExtendedKey{key, sum[32:]}, nil
+		}
+		data = append(append([]byte{1}, sum[32:]...), uint32Bytes(index)...)
+	}
+}
+
+func (k *ExtendedKey) DerivePath(path KeyPath) (*ExtendedKey, error) {
+	key := k
+	var err error
+	for _, index := range []uint32{hdPurpose + HardenedOffset, hdCoinType + HardenedOffset, path.Account + HardenedOffset, path.Chain, path.Index} {
+		key, err = key.Child(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func uint32Bytes(i uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, i)
+	return b
+}
+
+/*
+the wallet for the key at path, its addresses work exactly like those of a random key
+the public key keeps the encoding it had before there were signature schemes, a mnemonic has to give back the same addresses whenever it was written down
+*/
+func deriveWallet(seed []byte, path KeyPath) (*Wallet, error) {
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	key, err := master.DerivePath(path)
+	if err != nil {
+		return nil, err
+	}
+	wallet, err := walletFromKey(0, key.Key)
+	if err != nil {
+		return nil, err
+	}
+	wallet.Path = &path
+	wallet.Internal = path.Chain == ChangeChain
+	return wallet, nil
+}
+
+func (ws *Wallets) HasSeed() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.seed != nil || ws.encryptedSeed != nil
+}
+
+//gives the wallet a seed to derive keys from, an encrypted wallet has to be unlocked so the seed can be sealed
+func (ws *Wallets) SetSeed(seed []byte) error {
+	if ws.HasSeed() {
+		return errors.New("Wallet already has a seed")
+	}
+	if _, err := NewMasterKey(seed); err != nil {
+		return err
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.encrypted && ws.key == nil {
+		return ErrWalletLocked
+	}
+	ws.seed = append([]byte{}, seed...)
+	if ws.encrypted {
+		return ws.sealSeed(ws.key)
+	}
+	return nil
+}
+
+//the index after the highest one used so far on the chain of the account
+func (ws *Wallets) nextIndex(account, chain uint32) uint32 {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	next := uint32(0)
+	for _, wallet := range ws.Wallets {
+		path := wallet.Path
+		if path != nil && path.Account == account && path.Chain == chain && path.Index >= next {
+			next = path.Index + 1
+		}
+	}
+	return next
+}
+
+func (ws *Wallets) deriveWallet(path KeyPath) (*Wallet, error) {
+	ws.mu.Lock()
+	seed := ws.seed
+	ws.mu.Unlock()
+	if seed == nil {
+		if ws.HasSeed() {
+			return nil, ErrWalletLocked
+		}
+		return nil, errors.New("Wallet has no seed, create or restore one first")
+	}
+	return deriveWallet(seed, path)
+}
+
+//derives the next unused key of the chain (ReceiveChain or ChangeChain) of the account
+func (ws *Wallets) NewHDAddress(account, chain uint32) (string, error) {
+	wallet, err := ws.deriveWallet(KeyPath{account, chain, ws.nextIndex(account, chain)})
+	if err != nil {
+		return "", err
+	}
+	return ws.addWallet(wallet)
+}
+
+/*
+rediscovers the keys of an account after restoring from a mnemonic
+both chains are walked from index 0 until gap keys in a row have never been used, every used key is added to the wallet
+used tells whether a public key hash appears anywhere in the chain, the caller answers that because this package knows nothing about blocks
+*/
+func (ws *Wallets) ScanHD(account uint32, gap int, used func(pubKeyHash []byte) bool) (int, error) {
+	found := 0
+	for _, chain := range []uint32{ReceiveChain, ChangeChain} {
+		unused := 0
+		for index := uint32(0); unused < gap; index++ {
+			wallet, err := ws.deriveWallet(KeyPath{account, chain, index})
+			if err != nil {
+				return found, err
+			}
+			if !used(PublicKeyHash(wallet.PublicKey)) {
+				unused++
+				continue
+			}
+			unused = 0
+			if _, ok := ws.Wallets[string(wallet.Address())]; ok {
+				continue
+			}
+			if _, err := ws.addWallet(wallet); err != nil {
+				return found, err
+			}
+			found++
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,74 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+//the NIST P-256 vectors of SLIP-0010, H marks a hardened index
+var slip10Vectors = []struct {
+	name      string
+	seed      string
+	path      []uint32
+	chainCode string
+	key       string
+}{
+	{"m", "000102030405060708090a0b0c0d0e0f", nil,
+		"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea", "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+	{"m/0H", "000102030405060708090a0b0c0d0e0f", []uint32{0 + HardenedOffset},
+		"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11", "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+	{"m/0H/1", "000102030405060708090a0b0c0d0e0f", []uint32{0 + HardenedOffset, 1},
+		"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c", "284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129"},
+	{"m/0H/1/2H", "000102030405060708090a0b0c0d0e0f", []uint32{0 + HardenedOffset, 1, 2 + HardenedOffset},
+		"98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318", "694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7"},
+	//the left half of the first HMAC is not a valid scalar for this child, so the derivation has to be retried
+	{"m/28578H", "000102030405060708090a0b0c0d0e0f", []uint32{28578 + HardenedOffset},
+		"e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2", "06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669"},
+	{"m/28578H/33941", "000102030405060708090a0b0c0d0e0f", []uint32{28578 + HardenedOffset, 33941},
+		"9e87fe95031f14736774cd82f25fd885065cb7c358c1edf813c72af535e83071", "092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a"},
+	//the same for the master key
+	{"m", "a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", nil,
+		"7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c", "3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f"},
+}
+
+func TestSLIP10Vectors(t *testing.T) {
+	for _, v := range slip10Vectors {
+		seed, _ := hex.DecodeString(v.seed)
+		key, err := NewMasterKey(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, index := range v.path {
+			if key, err = key.Child(index); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if hex.EncodeToString(key.ChainCode) != v.chainCode || hex.EncodeToString(key.Key) != v.key {
+			t.Fatalf("%s of %s gave chain code %x and key %x, expected %s and %s", v.name, v.seed, key.ChainCode, key.Key, v.chainCode, v.key)
+		}
+	}
+}
+
+//addresses of a mnemonic must never change, they are all a backup restores
+func TestDerivedWalletFollowsPath(t *testing.T) {
+	seed, _ := hex.DecodeString(slip10Vectors[0].seed)
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := KeyPath{Account: 0, Chain: ChangeChain, Index: 3}
+	key, err := master.DerivePath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := deriveWallet(seed, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(w.privateKeyBytes()) != hex.EncodeToString(key.Key) || !w.Internal || w.Scheme != 0 {
+		t.Fatalf("wallet at %s does not have the key of the path", path)
+	}
+	if path.String() != "m/44'/1'/0'/1/3" {
+		t.Fatalf("path written as %s", path)
+	}
+}
@@ -0,0 +1,66 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+/*
+signed messages prove that whoever holds the key of an address wrote them, without spending anything
+the message is hashed behind a fixed prefix so a signature over it can never pass as the signature of a transaction or anything else
+the signature carries the public key, the verifier checks that it hashes to the address and then checks the signature with it
+*/
+const (
+	messagePrefix    = "GoBlockchain Signed Message:\n"
+	messageSigFormat = byte(0x01)
+)
+
+var ErrInvalidSignature = errors.New("Invalid message signature")
+
+func messageHash(message string) []byte {
+	var data bytes.Buffer
+	data.WriteString(messagePrefix)
+	length := make([]byte, binary.MaxVarintLen64)
+	data.Write(length[:binary.PutUvarint(length, uint64(len(message)))])
+	data.WriteString(message)
+	first := sha256.Sum256(data.Bytes())
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+//base64 of the format byte, the length of the public key, the public key and the signature with the id of its scheme in front
+func (w Wallet) SignMessage(message string) (string, error) {
+	signature, err := w.SignDigest(messageHash(message))
+	if err != nil {
+		return "", err
+	}
+	sig := []byte{messageSigFormat, byte(len(w.PublicKey))}
+	sig = append(sig, w.PublicKey...)
+	sig = append(sig, signature...)
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func VerifyMessage(address, signature, message string) error {
+	if !ValidateAddress(address) {
+		return errors.New("Address is not Valid")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) < 2 || sig[0] != messageSigFormat {
+		return ErrInvalidSignature
+	}
+	keyLen := int(sig[1])
+	if len(sig) != 2+keyLen+SignatureLength {
+		return ErrInvalidSignature
+	}
+	pubKey := sig[2 : 2+keyLen]
+	if !bytes.Equal(PublicKeyHash(pubKey), AddressToPubKeyHash(address)) {
+		return errors.New("Signature was not made with the key of " + address)
+	}
+	if !VerifySignature(pubKey, messageHash(message), sig[2+keyLen:]) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSignMessageRoundTrip(t *testing.T) {
+	for name, w := range testKeys(t) {
+		signature, err := w.SignMessage("I own this address")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyMessage(string(w.Address()), signature, "I own this address"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		//the same key written as an old base58 address
+		if err := VerifyMessage(string(PubKeyHashToBase58Address(w.PubKeyHash())), signature, "I own this address"); err != nil {
+			t.Fatalf("%s with a base58 address: %v", name, err)
+		}
+	}
+}
+
+func TestVerifyMessageRejectsAnotherAddressOrMessage(t *testing.T) {
+	w, other := MakeWallet(), MakeWallet()
+	signature, err := w.SignMessage("pay me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMessage(string(other.Address()), signature, "pay me"); err == nil || !strings.Contains(err.Error(), "not made with the key") {
+		t.Fatalf("a signature verified for another address with %v", err)
+	}
+	if err := VerifyMessage(string(w.Address()), signature, "pay me 100"); err != ErrInvalidSignature {
+		t.Fatalf("a signature verified for a changed message with %v", err)
+	}
+
+	sig, _ := base64.StdEncoding.DecodeString(signature)
+	for name, tampered := range map[string][]byte{
+		"changed signature": append(append([]byte{}, sig[:len(sig)-1]...), sig[len(sig)-1]^1),
+		"unknown format":    append([]byte{messageSigFormat + 1}, sig[1:]...),
+		"truncated":         sig[:len(sig)-1],
+		"empty":             nil,
+	} {
+		if err := VerifyMessage(string(w.Address()), base64.StdEncoding.EncodeToString(tampered), "pay me"); err != ErrInvalidSignature {
+			t.Fatalf("%s verified with %v", name, err)
+		}
+	}
+	if err := VerifyMessage(string(w.Address()), "not base64!", "pay me"); err != ErrInvalidSignature {
+		t.Fatalf("a signature that is not base64 verified with %v", err)
+	}
+}
+
+//a signature over a plain hash, like the one of a transaction, is not a signature of a message with the same bytes
+func TestMessageHashIsDomainSeparated(t *testing.T) {
+	w := MakeWallet()
+	digest := sha256.Sum256([]byte("pay me"))
+	signature, err := w.SignDigest(digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append([]byte{messageSigFormat, byte(len(w.PublicKey))}, w.PublicKey...)
+	sig = append(sig, signature...)
+	if err := VerifyMessage(string(w.Address()), base64.StdEncoding.EncodeToString(sig), "pay me"); err != ErrInvalidSignature {
+		t.Fatalf("a signature of the plain hash verified as a message signature with %v", err)
+	}
+}
+
+func TestWatchOnlyAddressCanNotSignMessages(t *testing.T) {
+	w, err := NewWatchOnlyAddress(string(MakeWallet().Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.SignMessage("pay me"); err != ErrWatchOnly {
+		t.Fatalf("a watch-only address signed a message with %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+/*
+BIP39 mnemonics, the entropy plus a checksum of its first bits is split into 11 bit numbers that pick words from the word list
+the seed for the key tree is derived from the words, so writing them down is all the backup a wallet needs
+*/
+
+var ErrInvalidMnemonic = errors.New("Invalid mnemonic")
+
+//words is 12, 15, 18, 21 or 24
+func NewMnemonic(words int) (string, error) {
+	if words < 12 || words > 24 || words%3 != 0 {
+		return "", errors.New("A mnemonic has 12, 15, 18, 21 or 24 words")
+	}
+	entropy := make([]byte, words*4/3)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return EntropyToMnemonic(entropy)
+}
+
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", errors.New("Entropy must be 128 to 256 bits in steps of 32")
+	}
+	checksumBits := uint(bits / 32)
+	hash := sha256.Sum256(entropy)
+
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, checksumBits)
+	data.Or(data, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	count := (bits + int(checksumBits)) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		index := new(big.Int).And(data, mask)
+		words[i] = wordList[index.Int64()]
+		data.Rsh(data, 11)
+	}
+	return strings.Join(words, " "), nil
+}
+
+//checks the words and the checksum and returns the entropy
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrInvalidMnemonic
+	}
+	data := new(big.Int)
+	for _, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, ErrInvalidMnemonic
+		}
+		data.Lsh(data, 11)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := uint(len(words) * 11 / 33)
+	checksum := new(big.Int).And(data, big.NewInt(int64(1<<checksumBits-1)))
+	data.Rsh(data, checksumBits)
+	entropy := make([]byte, len(words)*4/3)
+	raw := data.Bytes()
+	copy(entropy[len(entropy)-len(raw):], raw)
+
+	hash := sha256.Sum256(entropy)
+	if checksum.Int64() != int64(hash[0]>>(8-checksumBits)) {
+		return nil, ErrInvalidMnemonic
+	}
+	return entropy, nil
+}
+
+//the 64 byte seed the key tree is derived from, passphrase is the optional BIP39 passphrase and not the one encrypting the wallet file
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := MnemonicToEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
+
+var wordIndex = func() map[string]int {
+	index := make(map[string]int, len(wordList))
+	for i, word := range wordList {
+		index[word] = i
+	}
+	return index
+}()
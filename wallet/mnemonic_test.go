@@ -0,0 +1,87 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+//vectors of the BIP39 reference implementation, the seeds are derived with the passphrase "TREZOR"
+var bip39Vectors = []struct {
+	entropy  string
+	mnemonic string
+	seed     string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		"80808080808080808080808080808080",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+	},
+	{
+		"ffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+		"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+	},
+}
+
+func TestBIP39Vectors(t *testing.T) {
+	for _, v := range bip39Vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		mnemonic, err := EntropyToMnemonic(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mnemonic != v.mnemonic {
+			t.Fatalf("entropy %s gave %q, expected %q", v.entropy, mnemonic, v.mnemonic)
+		}
+		decoded, err := MnemonicToEntropy(mnemonic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(decoded) != v.entropy {
+			t.Fatalf("%q decoded to %x, expected %s", mnemonic, decoded, v.entropy)
+		}
+		seed, err := MnemonicToSeed(mnemonic, "TREZOR")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(seed) != v.seed {
+			t.Fatalf("%q gave the seed %x, expected %s", mnemonic, seed, v.seed)
+		}
+	}
+}
+
+func TestMnemonicChecksum(t *testing.T) {
+	//the last word carries the checksum, another word there keeps the length but breaks the checksum
+	words := strings.Fields(bip39Vectors[0].mnemonic)
+	words[len(words)-1] = "abandon"
+	if _, err := MnemonicToEntropy(strings.Join(words, " ")); err != ErrInvalidMnemonic {
+		t.Fatalf("mnemonic with a wrong checksum decoded with %v", err)
+	}
+	if _, err := MnemonicToSeed(strings.Join(words, " "), ""); err != ErrInvalidMnemonic {
+		t.Fatalf("seed derived from a mnemonic with a wrong checksum with %v", err)
+	}
+	for _, mnemonic := range []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",      //11 words
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abut", //not in the list
+	} {
+		if _, err := MnemonicToEntropy(mnemonic); err != ErrInvalidMnemonic {
+			t.Fatalf("%q decoded with %v", mnemonic, err)
+		}
+	}
+}
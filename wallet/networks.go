@@ -0,0 +1,54 @@
+package wallet
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+every network has its own address prefix so coins can not be sent to an address of another network by mistake
+Version is the version byte of the old base58 addresses, which are still accepted
+*/
+type Network struct {
+	Name    string
+	HRP     string
+	Version byte
+}
+
+var (
+	Mainnet = Network{"mainnet", "gb", 0x00}
+	Testnet = Network{"testnet", "tgb", 0x6f}
+	Regtest = Network{"regtest", "gbrt", 0x6f}
+)
+
+var Networks = map[string]*Network{
+	Mainnet.Name: &Mainnet,
+	Testnet.Name: &Testnet,
+	Regtest.Name: &Regtest,
+}
+
+//the network addresses are made and checked for
+var ActiveNetwork = &Mainnet
+
+func SetNetwork(name string) error {
+	network, ok := Networks[name]
+	if !ok {
+		var names []string
+		for n := range Networks {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("Unknown network %s, use one of %v", name, names)
+	}
+	ActiveNetwork = network
+	return nil
+}
+
+func networkByHRP(hrp string) *Network {
+	for _, network := range Networks {
+		if network.HRP == hrp {
+			return network
+		}
+	}
+	return nil
+}
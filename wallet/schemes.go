@@ -0,0 +1,439 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+/*
+the signature schemes a key can use, the id is the first byte of every public key and signature so a verifier knows which scheme made it
+every part of a key or signature is written with a fixed width, numbers are left padded, so nothing has to be guessed from the length
+
+keys made before there was a choice are ECDSA P-256 keys whose public key is x||y without the id or padding
+their addresses commit to that encoding so it can not change, ParsePublicKey still reads it and they sign in the new format
+*/
+type SchemeID byte
+
+const (
+	SchemeECDSA   SchemeID = 0x01
+	SchemeEd25519 SchemeID = 0x02
+	SchemeSchnorr SchemeID = 0x03
+
+	privateKeyLength = 32 //every scheme has 32 byte private keys: the P-256 scalar or the Ed25519 seed
+	rawSigLength     = 64
+	SignatureLength  = 1 + rawSigLength
+)
+
+type Scheme interface {
+	ID() SchemeID
+	Name() string
+	NewKey() ([]byte, error)
+	//the public key without the id in front
+	PublicKey(privKey []byte) ([]byte, error)
+	Sign(privKey, hash []byte) ([]byte, error)
+	Verify(pubKey, hash, sig []byte) bool
+}
+
+//schemes that can check many signatures at once faster than one at a time
+type BatchScheme interface {
+	Scheme
+	VerifyBatch(pubKeys, hashes, sigs [][]byte) bool
+}
+
+var Schemes = map[SchemeID]Scheme{
+	SchemeECDSA:   ecdsaScheme{},
+	SchemeEd25519: ed25519Scheme{},
+	SchemeSchnorr: schnorrScheme{},
+}
+
+func GetScheme(name string) (SchemeID, error) {
+	var names []string
+	for id, scheme := range Schemes {
+		if scheme.Name() == name {
+			return id, nil
+		}
+		names = append(names, scheme.Name())
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("Unknown signature scheme %s, use one of %v", name, names)
+}
+
+func publicKeyLength(id SchemeID) int {
+	if id == SchemeEd25519 {
+		return ed25519.PublicKeySize
+	}
+	return 2 * privateKeyLength
+}
+
+/*
+returns the scheme of a public key and the key without its id
+a key that does not start with an id and has the length of one is an old ECDSA key, x||y with neither of them padded
+a coordinate with leading zero bytes made its part shorter, so the key is split where both parts give a point on the curve, trying the middle first
+*/
+func ParsePublicKey(pubKey []byte) (Scheme, []byte, error) {
+	if len(pubKey) > 0 {
+		if scheme, ok := Schemes[SchemeID(pubKey[0])]; ok && len(pubKey) == 1+publicKeyLength(scheme.ID()) {
+			return scheme, pubKey[1:], nil
+		}
+	}
+	if len(pubKey) < 2 || len(pubKey) > 2*privateKeyLength {
+		return nil, nil, errors.New("Invalid public key")
+	}
+	half := len(pubKey) / 2
+	splits := []int{half}
+	for split := len(pubKey) - privateKeyLength; split <= privateKeyLength; split++ {
+		if split > 0 && split < len(pubKey) && split != half {
+			splits = append(splits, split)
+		}
+	}
+	for _, split := range splits {
+		raw := append(padBytes(pubKey[:split]), padBytes(pubKey[split:])...)
+		if _, _, ok := p256Point(raw); ok {
+			return ecdsaScheme{}, raw, nil
+		}
+	}
+	return nil, nil, errors.New("Invalid public key")
+}
+
+/*
+checks a signature made with pubKey, signatures are the scheme id followed by 64 bytes
+old keys sign in this format as well, the r||s signatures written before there were schemes are not accepted
+they are the same signature as the one with the id in front, and taking both would let anyone change the ID of a transaction by rewriting its signature in the other form
+*/
+func VerifySignature(pubKey, hash, sig []byte) bool {
+	scheme, raw, err := ParsePublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+	if len(sig) == SignatureLength && SchemeID(sig[0]) == scheme.ID() {
+		return scheme.Verify(raw, hash, sig[1:])
+	}
+	return false
+}
+
+//one signature of a batch handed to VerifyBatch
+type SignatureCheck struct {
+	PubKey []byte
+	Hash   []byte
+	Sig    []byte
+}
+
+//true when every signature is valid, the ones of schemes that support it are checked together
+func VerifyBatch(checks []SignatureCheck) bool {
+	batches := make(map[SchemeID][][3][]byte)
+	for _, check := range checks {
+		scheme, raw, err := ParsePublicKey(check.PubKey)
+		if err != nil {
+			return false
+		}
+		if _, ok := scheme.(BatchScheme); ok && len(check.Sig) == SignatureLength && SchemeID(check.Sig[0]) == scheme.ID() {
+			batches[scheme.ID()] = append(batches[scheme.ID()], [3][]byte{raw, check.Hash, check.Sig[1:]})
+			continue
+		}
+		if !VerifySignature(check.PubKey, check.Hash, check.Sig) {
+			return false
+		}
+	}
+	for id, batch := range batches {
+		var pubKeys, hashes, sigs [][]byte
+		for _, item := range batch {
+			pubKeys = append(pubKeys, item[0])
+			hashes = append(hashes, item[1])
+			sigs = append(sigs, item[2])
+		}
+		if !Schemes[id].(BatchScheme).VerifyBatch(pubKeys, hashes, sigs) {
+			return false
+		}
+	}
+	return true
+}
+
+func padBytes(b []byte) []byte {
+	return padScalar(new(big.Int).SetBytes(b))
+}
+
+func padScalar(n *big.Int) []byte {
+	scalar := make([]byte, scalarLength)
+	b := n.Bytes()
+	copy(scalar[scalarLength-len(b):], b)
+	return scalar
+}
+
+func newP256Scalar() ([]byte, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return padScalar(private.D), nil
+}
+
+func p256PublicKey(privKey []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(privKey)
+	if len(privKey) != privateKeyLength || d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrInvalidPrivateKey
+	}
+	x, y := curve.ScalarBaseMult(privKey)
+	return append(padScalar(x), padScalar(y)...), nil
+}
+
+func p256Point(pubKey []byte) (*big.Int, *big.Int, bool) {
+	if len(pubKey) != 2*privateKeyLength {
+		return nil, nil, false
+	}
+	x := new(big.Int).SetBytes(pubKey[:privateKeyLength])
+	y := new(big.Int).SetBytes(pubKey[privateKeyLength:])
+	return x, y, elliptic.P256().IsOnCurve(x, y)
+}
+
+/*
+ECDSA over P-256, what every key used before other schemes were added
+s and n-s make equally valid signatures, so only the lower one is made and accepted and nobody can change the ID of a transaction by flipping it
+*/
+type ecdsaScheme struct{}
+
+var p256HalfOrder = new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+func (ecdsaScheme) ID() SchemeID {
+	return SchemeECDSA
+}
+
+func (ecdsaScheme) Name() string {
+	return "ecdsa"
+}
+
+func (ecdsaScheme) NewKey() ([]byte, error) {
+	return newP256Scalar()
+}
+
+func (ecdsaScheme) PublicKey(privKey []byte) ([]byte, error) {
+	return p256PublicKey(privKey)
+}
+
+func (ecdsaScheme) Sign(privKey, hash []byte) ([]byte, error) {
+	pubKey, err := p256PublicKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	x, y, _ := p256Point(pubKey)
+	key := ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, D: new(big.Int).SetBytes(privKey)}
+	r, s, err := ecdsa.Sign(rand.Reader, &key, hash)
+	if err != nil {
+		return nil, err
+	}
+	if s.Cmp(p256HalfOrder) > 0 {
+		s.Sub(elliptic.P256().Params().N, s)
+	}
+	return append(padScalar(r), padScalar(s)...), nil
+}
+
+func (ecdsaScheme) Verify(pubKey, hash, sig []byte) bool {
+	x, y, ok := p256Point(pubKey)
+	if !ok || len(sig) != rawSigLength {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:scalarLength])
+	s := new(big.Int).SetBytes(sig[scalarLength:])
+	if s.Cmp(p256HalfOrder) > 0 {
+		return false
+	}
+	return ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, hash, r, s)
+}
+
+type ed25519Scheme struct{}
+
+func (ed25519Scheme) ID() SchemeID {
+	return SchemeEd25519
+}
+
+func (ed25519Scheme) Name() string {
+	return "ed25519"
+}
+
+func (ed25519Scheme) NewKey() ([]byte, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return private.Seed(), nil
+}
+
+func (ed25519Scheme) PublicKey(privKey []byte) ([]byte, error) {
+	if len(privKey) != ed25519.SeedSize {
+		return nil, ErrInvalidPrivateKey
+	}
+	return ed25519.NewKeyFromSeed(privKey).Public().(ed25519.PublicKey), nil
+}
+
+func (ed25519Scheme) Sign(privKey, hash []byte) ([]byte, error) {
+	if len(privKey) != ed25519.SeedSize {
+		return nil, ErrInvalidPrivateKey
+	}
+	return ed25519.Sign(ed25519.NewKeyFromSeed(privKey), hash), nil
+}
+
+func (ed25519Scheme) Verify(pubKey, hash, sig []byte) bool {
+	return len(pubKey) == ed25519.PublicKeySize && ed25519.Verify(pubKey, hash, sig)
+}
+
+/*
+Schnorr signatures over P-256 in the style of BIP340
+the signature is the x coordinate of the nonce point R, which is always picked with an even y, and s = k + e*d where e hashes R, the public key and the message
+a signature is valid when s*G - e*P is R, and because that equation is linear many signatures can be checked with one combined equation
+*/
+type schnorrScheme struct{}
+
+const schnorrTag = "GoBlockchain/Schnorr"
+
+func (schnorrScheme) ID() SchemeID {
+	return SchemeSchnorr
+}
+
+func (schnorrScheme) Name() string {
+	return "schnorr"
+}
+
+func (schnorrScheme) NewKey() ([]byte, error) {
+	return newP256Scalar()
+}
+
+func (schnorrScheme) PublicKey(privKey []byte) ([]byte, error) {
+	return p256PublicKey(privKey)
+}
+
+func schnorrChallenge(rx, pubKey, hash []byte) *big.Int {
+	tag := sha256.Sum256([]byte(schnorrTag))
+	hasher := sha256.New()
+	hasher.Write(tag[:])
+	hasher.Write(tag[:])
+	hasher.Write(rx)
+	hasher.Write(pubKey)
+	hasher.Write(hash)
+	e := new(big.Int).SetBytes(hasher.Sum(nil))
+	return e.Mod(e, elliptic.P256().Params().N)
+}
+
+func (schnorrScheme) Sign(privKey, hash []byte) ([]byte, error) {
+	pubKey, err := p256PublicKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	curve := elliptic.P256()
+	n := curve.Params().N
+	k, err := rand.Int(rand.Reader, new(big.Int).Sub(n, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	k.Add(k, big.NewInt(1))
+	rx, ry := curve.ScalarBaseMult(padScalar(k))
+	if ry.Bit(0) == 1 {
+		k.Sub(n, k)
+	}
+	e := schnorrChallenge(padScalar(rx), pubKey, hash)
+	s := new(big.Int).Mul(e, new(big.Int).SetBytes(privKey))
+	s.Add(s, k)
+	s.Mod(s, n)
+	return append(padScalar(rx), padScalar(s)...), nil
+}
+
+func (schnorrScheme) Verify(pubKey, hash, sig []byte) bool {
+	curve := elliptic.P256()
+	n := curve.Params().N
+	px, py, ok := p256Point(pubKey)
+	if !ok || len(sig) != rawSigLength {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:scalarLength])
+	s := new(big.Int).SetBytes(sig[scalarLength:])
+	if r.Cmp(curve.Params().P) >= 0 || s.Cmp(n) >= 0 {
+		return false
+	}
+	e := schnorrChallenge(sig[:scalarLength], pubKey, hash)
+	//R = s*G - e*P
+	sx, sy := curve.ScalarBaseMult(padScalar(s))
+	negE := new(big.Int).Sub(n, e)
+	ex, ey := curve.ScalarMult(px, py, padScalar(negE.Mod(negE, n)))
+	rx, ry := curve.Add(sx, sy, ex, ey)
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return false
+	}
+	return ry.Bit(0) == 0 && rx.Cmp(r) == 0
+}
+
+//the point with x coordinate x and an even y, P-256's prime is 3 mod 4 so the square root is a single exponentiation
+func liftX(x *big.Int) (*big.Int, bool) {
+	params := elliptic.P256().Params()
+	p := params.P
+	y2 := new(big.Int).Exp(x, big.NewInt(3), p)
+	y2.Sub(y2, new(big.Int).Mul(big.NewInt(3), x))
+	y2.Add(y2, params.B)
+	y2.Mod(y2, p)
+	exp := new(big.Int).Add(p, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(y2, exp, p)
+	if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(y2) != 0 {
+		return nil, false
+	}
+	if y.Bit(0) == 1 {
+		y.Sub(p, y)
+	}
+	return y, true
+}
+
+/*
+checks (a1*s1 + a2*s2 + ...)*G == a1*R1 + a1*e1*P1 + a2*R2 + a2*e2*P2 + ...
+the random weights a make it impossible to craft invalid signatures that cancel each other out, the first one can be 1
+*/
+func (schnorrScheme) VerifyBatch(pubKeys, hashes, sigs [][]byte) bool {
+	curve := elliptic.P256()
+	params := curve.Params()
+	n := params.N
+	sum := new(big.Int)
+	var accX, accY *big.Int
+	add := func(x, y *big.Int) {
+		if accX == nil {
+			accX, accY = x, y
+			return
+		}
+		accX, accY = curve.Add(accX, accY, x, y)
+	}
+	for i := range sigs {
+		px, py, ok := p256Point(pubKeys[i])
+		if !ok || len(sigs[i]) != rawSigLength {
+			return false
+		}
+		r := new(big.Int).SetBytes(sigs[i][:scalarLength])
+		s := new(big.Int).SetBytes(sigs[i][scalarLength:])
+		if r.Cmp(params.P) >= 0 || s.Cmp(n) >= 0 {
+			return false
+		}
+		ry, ok := liftX(r)
+		if !ok {
+			return false
+		}
+		a := big.NewInt(1)
+		if i > 0 {
+			var err error
+			if a, err = rand.Int(rand.Reader, n); err != nil || a.Sign() == 0 {
+				return false
+			}
+		}
+		e := schnorrChallenge(sigs[i][:scalarLength], pubKeys[i], hashes[i])
+		sum.Add(sum, new(big.Int).Mul(a, s))
+		add(curve.ScalarMult(r, ry, padScalar(a)))
+		ae := new(big.Int).Mul(a, e)
+		add(curve.ScalarMult(px, py, padScalar(ae.Mod(ae, n))))
+	}
+	if accX == nil {
+		return true
+	}
+	sx, sy := curve.ScalarBaseMult(padScalar(sum.Mod(sum, n)))
+	return sx.Cmp(accX) == 0 && sy.Cmp(accY) == 0
+}
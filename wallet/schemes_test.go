@@ -0,0 +1,162 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+)
+
+//an old style wallet with a leading zero byte in one coordinate, so its public key is 63 bytes
+func shortLegacyWallet(t *testing.T, xShorter bool) *Wallet {
+	for i := 0; i < 100000; i++ {
+		key, err := newP256Scalar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := walletFromKey(0, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, y := w.PrivateKey.PublicKey.X.Bytes(), w.PrivateKey.PublicKey.Y.Bytes()
+		if (xShorter && len(x) == scalarLength-1 && len(y) == scalarLength) || (!xShorter && len(y) == scalarLength-1 && len(x) == scalarLength) {
+			return w
+		}
+	}
+	t.Fatal("no key with a short coordinate found")
+	return nil
+}
+
+func TestParseShortLegacyPublicKey(t *testing.T) {
+	for _, xShorter := range []bool{true, false} {
+		w := shortLegacyWallet(t, xShorter)
+		if len(w.PublicKey)%2 == 0 {
+			t.Fatalf("legacy key has %d bytes", len(w.PublicKey))
+		}
+		scheme, raw, err := ParsePublicKey(w.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := append(padScalar(w.PrivateKey.PublicKey.X), padScalar(w.PrivateKey.PublicKey.Y)...)
+		if scheme.ID() != SchemeECDSA || string(raw) != string(expected) {
+			t.Fatalf("key parsed to %x, expected %x", raw, expected)
+		}
+
+		hash := sha256.Sum256([]byte("message"))
+		sig, err := w.SignDigest(hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifySignature(w.PublicKey, hash[:], sig) {
+			t.Fatal("signature of a key with a short coordinate does not verify")
+		}
+	}
+}
+
+//the same signature without its scheme id would give the transaction carrying it another ID
+func TestLegacySignatureFormRejected(t *testing.T) {
+	w := shortLegacyWallet(t, true)
+	hash := sha256.Sum256([]byte("message"))
+	sig, err := w.SignDigest(hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifySignature(w.PublicKey, hash[:], sig) {
+		t.Fatal("signature does not verify")
+	}
+	if VerifySignature(w.PublicKey, hash[:], sig[1:]) {
+		t.Fatal("signature without its scheme id verifies")
+	}
+	if VerifyBatch([]SignatureCheck{{w.PublicKey, hash[:], sig[1:]}}) {
+		t.Fatal("signature without its scheme id verifies in a batch")
+	}
+}
+
+func TestSchemesSignAndVerify(t *testing.T) {
+	hash := sha256.Sum256([]byte("message"))
+	other := sha256.Sum256([]byte("another message"))
+	for id, scheme := range Schemes {
+		w, err := NewWallet(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stranger, err := NewWallet(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := w.SignDigest(hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sig) != SignatureLength || SchemeID(sig[0]) != id {
+			t.Fatalf("%s signature is %d bytes starting with %d", scheme.Name(), len(sig), sig[0])
+		}
+		if !VerifySignature(w.PublicKey, hash[:], sig) {
+			t.Fatalf("%s signature does not verify", scheme.Name())
+		}
+		if VerifySignature(w.PublicKey, other[:], sig) {
+			t.Fatalf("%s signature verifies for another message", scheme.Name())
+		}
+		if VerifySignature(stranger.PublicKey, hash[:], sig) {
+			t.Fatalf("%s signature verifies for another key", scheme.Name())
+		}
+		tampered := append([]byte{}, sig...)
+		tampered[len(tampered)-1] ^= 1
+		if VerifySignature(w.PublicKey, hash[:], tampered) {
+			t.Fatalf("tampered %s signature verifies", scheme.Name())
+		}
+	}
+}
+
+//n-s is as valid as s for plain ECDSA, only the lower of the two may pass
+func TestECDSAOnlyLowS(t *testing.T) {
+	w := MakeWallet()
+	n := elliptic.P256().Params().N
+	hash := sha256.Sum256([]byte("message"))
+	for i := 0; i < 32; i++ {
+		sig, err := w.SignDigest(hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := new(big.Int).SetBytes(sig[1+scalarLength:])
+		if s.Cmp(p256HalfOrder) > 0 {
+			t.Fatalf("signed with a high s %x", s)
+		}
+		flipped := append(append([]byte{}, sig[:1+scalarLength]...), padScalar(new(big.Int).Sub(n, s))...)
+		if VerifySignature(w.PublicKey, hash[:], flipped) || VerifyBatch([]SignatureCheck{{w.PublicKey, hash[:], flipped}}) {
+			t.Fatal("the high s twin of a signature verifies")
+		}
+	}
+}
+
+func TestVerifyBatchFailsOnOneBadSignature(t *testing.T) {
+	var checks []SignatureCheck
+	for i := 0; i < 6; i++ {
+		//mostly Schnorr so they are checked as one batch, with keys of the other schemes mixed in
+		id := SchemeSchnorr
+		if i%3 == 2 {
+			id = SchemeID(1 + i%2)
+		}
+		w, err := NewWallet(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := sha256.Sum256([]byte{byte(i)})
+		sig, err := w.SignDigest(hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		checks = append(checks, SignatureCheck{w.PublicKey, hash[:], sig})
+	}
+	if !VerifyBatch(checks) {
+		t.Fatal("a batch of valid signatures fails")
+	}
+	for i := range checks {
+		bad := append([]SignatureCheck{}, checks...)
+		other := sha256.Sum256([]byte("not what was signed"))
+		bad[i].Hash = other[:]
+		if VerifyBatch(bad) {
+			t.Fatalf("a batch with a bad signature at %d verifies", i)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+)
+
+/*
+a Signer holds the key of one address, transactions are built and signed through it without ever seeing the private key
+the key can be in this process (Wallet), in a file (FileSigner) or in a separate signer daemon reached over a unix socket (blockchain.SocketSigner)
+*/
+type Signer interface {
+	//the public key as it goes into transaction inputs, with the id of its scheme in front unless it is an old ECDSA key
+	GetPublicKey() ([]byte, error)
+	//signs a 32 byte digest, the signature starts with the id of the scheme
+	SignDigest(digest []byte) ([]byte, error)
+}
+
+//the address a signer's key pays to
+func SignerAddress(signer Signer) (string, error) {
+	pubKey, err := signer.GetPublicKey()
+	if err != nil {
+		return "", err
+	}
+	return string(PubKeyHashToAddress(PublicKeyHash(pubKey))), nil
+}
+
+//a watch-only address added without its public key has nothing to give
+func (w Wallet) GetPublicKey() ([]byte, error) {
+	if w.PublicKey == nil {
+		return nil, ErrWatchOnly
+	}
+	return w.PublicKey, nil
+}
+
+func (w Wallet) SignDigest(digest []byte) ([]byte, error) {
+	if w.WatchOnly {
+		return nil, ErrWatchOnly
+	}
+	if w.IsLocked() {
+		return nil, ErrWalletLocked
+	}
+	sig, err := w.scheme().Sign(w.privateKeyBytes(), digest)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(w.scheme().ID())}, sig...), nil
+}
+
+/*
+signs with a key read from a file in either format exportkey writes, meant for tests and scripts
+the file is read again for every signature so replacing it switches the key
+*/
+type FileSigner struct {
+	Path string
+}
+
+func NewFileSigner(path string) *FileSigner {
+	return &FileSigner{path}
+}
+
+func (fs *FileSigner) load() (*Wallet, error) {
+	data, err := ioutil.ReadFile(fs.Path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, errors.New("Key file " + fs.Path + ": " + err.Error())
+	}
+	return w, nil
+}
+
+func (fs *FileSigner) GetPublicKey() ([]byte, error) {
+	w, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+	return w.GetPublicKey()
+}
+
+func (fs *FileSigner) SignDigest(digest []byte) ([]byte, error) {
+	w, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+	defer w.wipe()
+	return w.SignDigest(digest)
+}
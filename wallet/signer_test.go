@@ -0,0 +1,77 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeKeyFile(t *testing.T, path string, w *Wallet) {
+	wif, err := w.ExportWIF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(wif), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileSignerSignsWithTheKeyOfTheFile(t *testing.T) {
+	w := MakeWallet()
+	path := filepath.Join(t.TempDir(), "key")
+	writeKeyFile(t, path, w)
+	signer := NewFileSigner(path)
+
+	pubKey, err := signer.GetPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pubKey, w.PublicKey) {
+		t.Fatalf("signer key %x, expected %x", pubKey, w.PublicKey)
+	}
+	digest := sha256.Sum256([]byte("digest"))
+	sig, err := signer.SignDigest(digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifySignature(w.PublicKey, digest[:], sig) {
+		t.Fatal("signature of the file signer does not verify")
+	}
+}
+
+//the file is read for every signature, so writing another key into it switches the signer over
+func TestFileSignerFollowsTheFile(t *testing.T) {
+	first, second := MakeWallet(), MakeWallet()
+	path := filepath.Join(t.TempDir(), "key")
+	writeKeyFile(t, path, first)
+	signer := NewFileSigner(path)
+	if address, _ := SignerAddress(signer); address != string(first.Address()) {
+		t.Fatalf("signer address %s, expected %s", address, first.Address())
+	}
+
+	writeKeyFile(t, path, second)
+	if address, _ := SignerAddress(signer); address != string(second.Address()) {
+		t.Fatalf("signer address %s after replacing the file, expected %s", address, second.Address())
+	}
+	digest := sha256.Sum256([]byte("digest"))
+	sig, err := signer.SignDigest(digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifySignature(second.PublicKey, digest[:], sig) {
+		t.Fatal("signature is not made with the new key")
+	}
+}
+
+func TestFileSignerWithoutFile(t *testing.T) {
+	signer := NewFileSigner(filepath.Join(t.TempDir(), "missing"))
+	if _, err := signer.GetPublicKey(); err == nil {
+		t.Fatal("missing key file gave a public key")
+	}
+	digest := sha256.Sum256([]byte("digest"))
+	if _, err := signer.SignDigest(digest[:]); err == nil {
+		t.Fatal("missing key file gave a signature")
+	}
+}
@@ -3,41 +3,190 @@ package wallet
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"log"
+	"math/big"
+	"strings"
 
+	"github.com/mr-tron/base58"
 	"golang.org/x/crypto/ripemd160"
 )
 
 const (
-	checksumLength = 4
-	version        = byte(0x00)
+	checksumLength   = 4
+	pubKeyHashLength = 20
+	addressVersion   = byte(0x00) //first value of the data of a bech32 address
 )
 
 type Wallet struct {
-	PrivateKey ecdsa.PrivateKey
+	PrivateKey ecdsa.PrivateKey //the key of P-256 schemes, Ed25519 keys are kept in edKey
 	PublicKey  []byte
+	Scheme     SchemeID //0 for the ECDSA keys made before there were other schemes, see schemes.go
+
+	Path *KeyPath //position in the key tree for keys derived from the wallet seed, nil for random keys
+
+	WatchOnly bool   //there is no private key, the address is only followed for its balance and history
+	Internal  bool   //the address was made to receive change and is not handed out to others
+	watchHash []byte //public key hash of a watch-only address that was added without its public key
+
+	edKey        ed25519.PrivateKey
+	encryptedKey []byte //sealed private key when the wallet file is encrypted
 }
 
-//makes our public and private key
+//makes our public and private key, the public key is the fixed width ECDSA encoding with the scheme id in front
 func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 	curve := elliptic.P256()
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
 		log.Panic(err)
 	}
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	pub := append([]byte{byte(SchemeECDSA)}, padScalar(private.PublicKey.X)...)
+	pub = append(pub, padScalar(private.PublicKey.Y)...)
 	return *private, pub //get retuned in tuple
 }
 
 func MakeWallet() *Wallet {
 	private, public := NewKeyPair()
-	wallet := Wallet{private, public}
+	wallet := Wallet{PrivateKey: private, PublicKey: public, Scheme: SchemeECDSA}
 	return &wallet
 }
 
+//a wallet with a new random key of the scheme
+func NewWallet(scheme SchemeID) (*Wallet, error) {
+	s, ok := Schemes[scheme]
+	if !ok {
+		return nil, errors.New("Unknown signature scheme")
+	}
+	key, err := s.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	return walletFromKey(scheme, key)
+}
+
+//the wallet for a private key, scheme 0 gives the public key the encoding of the keys made before there were schemes
+func walletFromKey(scheme SchemeID, key []byte) (*Wallet, error) {
+	wallet := &Wallet{Scheme: scheme}
+	if err := wallet.setPrivateKey(key); err != nil {
+		return nil, err
+	}
+	pubKey, err := wallet.scheme().PublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == 0 {
+		wallet.PublicKey = append(wallet.PrivateKey.PublicKey.X.Bytes(), wallet.PrivateKey.PublicKey.Y.Bytes()...)
+	} else {
+		wallet.PublicKey = append([]byte{byte(scheme)}, pubKey...)
+	}
+	return wallet, nil
+}
+
+func (w Wallet) scheme() Scheme {
+	if w.Scheme == 0 {
+		return Schemes[SchemeECDSA]
+	}
+	return Schemes[w.Scheme]
+}
+
+/*
+a watch-only entry for an address, all we know is the hash its outputs are locked to
+NewWatchOnlyPubKey takes the public key instead, which is only needed to check that the key is on the curve
+*/
+func NewWatchOnlyAddress(address string) (*Wallet, error) {
+	if !ValidateAddress(address) {
+		return nil, errors.New("Address is not Valid")
+	}
+	return &Wallet{WatchOnly: true, watchHash: AddressToPubKeyHash(address)}, nil
+}
+
+/*
+pubKey is a public key as this wallet writes it, with the id of its scheme in front or in the old x||y form
+the 65 byte uncompressed SEC1 form of a P-256 key is turned into the old form, which is what other tools made before schemes existed
+*/
+func NewWatchOnlyPubKey(pubKey []byte) (*Wallet, error) {
+	curve := elliptic.P256()
+	if len(pubKey) == 65 && pubKey[0] == 4 {
+		x, y := elliptic.Unmarshal(curve, pubKey)
+		if x == nil {
+			return nil, errors.New("Invalid public key")
+		}
+		pubKey = append(x.Bytes(), y.Bytes()...)
+	}
+	scheme, raw, err := ParsePublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if scheme.ID() != SchemeEd25519 {
+		if _, _, ok := p256Point(raw); !ok {
+			return nil, errors.New("Invalid public key")
+		}
+	}
+	return &Wallet{PublicKey: pubKey, Scheme: scheme.ID(), WatchOnly: true}, nil
+}
+
+func (w Wallet) PubKeyHash() []byte {
+	if w.PublicKey == nil {
+		return w.watchHash
+	}
+	return PublicKeyHash(w.PublicKey)
+}
+
+//a wallet from an encrypted file has no private key until it is unlocked
+func (w Wallet) IsLocked() bool {
+	if w.Scheme == SchemeEd25519 {
+		return w.edKey == nil
+	}
+	return w.PrivateKey.D == nil || w.PrivateKey.D.Sign() == 0
+}
+
+//the private scalar left padded to its full length, or the seed of an Ed25519 key
+func (w Wallet) privateKeyBytes() []byte {
+	if w.Scheme == SchemeEd25519 {
+		return w.edKey.Seed()
+	}
+	return padScalar(w.PrivateKey.D)
+}
+
+func (w *Wallet) setPrivateKey(scalar []byte) error {
+	if w.Scheme == SchemeEd25519 {
+		if len(scalar) != ed25519.SeedSize {
+			return ErrInvalidPrivateKey
+		}
+		w.edKey = ed25519.NewKeyFromSeed(scalar)
+		return nil
+	}
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(scalar)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return errors.New("Invalid private key")
+	}
+	x, y := curve.ScalarBaseMult(scalar)
+	w.PrivateKey = ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+	return nil
+}
+
+//overwrites the private scalar, copies of the wallet share it so they end up locked as well
+func (w *Wallet) wipe() {
+	if w.PrivateKey.D != nil {
+		words := w.PrivateKey.D.Bits()
+		for i := range words {
+			words[i] = 0
+		}
+		w.PrivateKey.D.SetInt64(0)
+	}
+	w.PrivateKey = ecdsa.PrivateKey{}
+	for i := range w.edKey {
+		w.edKey[i] = 0
+	}
+	w.edKey = nil
+}
+
 func PublicKeyHash(pubKey []byte) []byte {
 	pubHash := sha256.Sum256(pubKey)
 	hasher := ripemd160.New()
@@ -57,19 +206,104 @@ func Checksum(payload []byte) []byte {
 
 //this method allows to generate address for each of our wallet
 func (w Wallet) Address() []byte {
-	pubHash := PublicKeyHash(w.PublicKey)
-	versionedHash := append([]byte{version}, pubHash...)
+	return PubKeyHashToAddress(w.PubKeyHash())
+}
+
+//builds the bech32 address of the active network for a public key hash, used when all we have is the hash an output is locked to
+func PubKeyHashToAddress(pubHash []byte) []byte {
+	data, err := convertBits(pubHash, 8, 5, true)
+	if err != nil {
+		log.Panic(err)
+	}
+	address, err := Bech32Encode(ActiveNetwork.HRP, append([]byte{addressVersion}, data...))
+	if err != nil {
+		log.Panic(err)
+	}
+	return []byte(address)
+}
+
+//the base58check form addresses had before they were bech32, still accepted everywhere an address is
+func PubKeyHashToBase58Address(pubHash []byte) []byte {
+	versionedHash := append([]byte{ActiveNetwork.Version}, pubHash...)
 	checkSum := Checksum(versionedHash)
 	fullHash := append(versionedHash, checkSum...)
 	address := Base58Encode(fullHash)
 	return address
 }
 
+//returns the public key hash of a bech32 or base58 address, the address has to belong to the active network
+func DecodeAddress(address string) ([]byte, error) {
+	lower := strings.ToLower(address)
+	for _, network := range Networks {
+		if strings.HasPrefix(lower, network.HRP+"1") {
+			return decodeBech32Address(address)
+		}
+	}
+	return decodeBase58Address(address)
+}
+
+func decodeBech32Address(address string) ([]byte, error) {
+	hrp, data, err := Bech32Decode(address)
+	if err != nil {
+		return nil, err
+	}
+	if hrp != ActiveNetwork.HRP {
+		network := networkByHRP(hrp)
+		if network == nil {
+			return nil, fmt.Errorf("Address is for an unknown network with the prefix %s", hrp)
+		}
+		return nil, fmt.Errorf("Address is for %s, not %s", network.Name, ActiveNetwork.Name)
+	}
+	if len(data) == 0 || data[0] != addressVersion {
+		return nil, errors.New("Unknown address version")
+	}
+	pubKeyHash, err := convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(pubKeyHash) != pubKeyHashLength {
+		return nil, errors.New("Address has the wrong length")
+	}
+	return pubKeyHash, nil
+}
+
+func decodeBase58Address(address string) ([]byte, error) {
+	decoded, err := base58.Decode(address)
+	if err != nil {
+		return nil, errors.New("Address is neither bech32 nor base58")
+	}
+	if len(decoded) != 1+pubKeyHashLength+checksumLength {
+		return nil, errors.New("Address has the wrong length")
+	}
+	payload, checksum := decoded[:len(decoded)-checksumLength], decoded[len(decoded)-checksumLength:]
+	if !bytes.Equal(Checksum(payload), checksum) {
+		return nil, errors.New("Invalid address checksum")
+	}
+	if payload[0] != ActiveNetwork.Version {
+		return nil, fmt.Errorf("Address is not for %s", ActiveNetwork.Name)
+	}
+	return payload[1:], nil
+}
+
+//reverse of PubKeyHashToAddress, the address must have been checked with ValidateAddress
+func AddressToPubKeyHash(address string) []byte {
+	pubKeyHash, err := DecodeAddress(address)
+	if err != nil {
+		log.Panic(err)
+	}
+	return pubKeyHash
+}
+
+//the address in the form this wallet shows it, so base58 and bech32 addresses of the same key are the same address
+func NormalizeAddress(address string) (string, error) {
+	pubKeyHash, err := DecodeAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return string(PubKeyHashToAddress(pubKeyHash)), nil
+}
+
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
-	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
-	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+	_, err := DecodeAddress(address)
+	return err == nil
 }
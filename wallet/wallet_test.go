@@ -0,0 +1,34 @@
+package wallet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddressRoundTrip(t *testing.T) {
+	w := MakeWallet()
+	address := string(w.Address())
+	if !ValidateAddress(address) {
+		t.Fatalf("%s is not valid", address)
+	}
+	if got := AddressToPubKeyHash(address); string(got) != string(w.PubKeyHash()) {
+		t.Fatalf("address decodes to %x, expected %x", got, w.PubKeyHash())
+	}
+}
+
+func TestDecodeAddressOfOtherNetwork(t *testing.T) {
+	ActiveNetwork = &Testnet
+	address := string(MakeWallet().Address())
+	ActiveNetwork = &Mainnet
+	_, err := DecodeAddress(address)
+	if err == nil || !strings.Contains(err.Error(), "testnet") {
+		t.Fatalf("testnet address decoded on mainnet with %v", err)
+	}
+}
+
+//a bech32 string whose prefix starts like one of ours but belongs to no network
+func TestDecodeAddressOfUnknownNetwork(t *testing.T) {
+	if ValidateAddress("gb1x1qpzrt6dvxz") {
+		t.Fatal("address of an unknown network is valid")
+	}
+}
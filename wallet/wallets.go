@@ -2,18 +2,64 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"math/big"
 	"os"
+	"sync"
+	"time"
 )
 
-const walletFile = ".tmp/wallets.data"
+const (
+	walletFile        = ".tmp/wallets.data"
+	walletFileVersion = 1
+)
+
+var (
+	ErrWalletLocked      = errors.New("Wallet is locked, unlock it with the passphrase first")
+	ErrWalletEncrypted   = errors.New("Wallet is already encrypted")
+	ErrWalletUnencrypted = errors.New("Wallet is not encrypted")
+	ErrWatchOnly         = errors.New("Address is watch-only, the wallet has no private key to sign with")
+)
 
 type Wallets struct {
 	Wallets map[string]*Wallet
+
+	mu        sync.Mutex
+	encrypted bool
+	salt      []byte
+	key       []byte //key derived from the passphrase, only set while the wallet is unlocked
+	lockTimer *time.Timer
+
+	seed          []byte //seed of the key tree (see hd.go), nil for wallets without one and while locked
+	encryptedSeed []byte
+
+	labels map[string]string //notes of the user keyed by address or hex transaction id
+}
+
+/*
+what is written to the wallet file, private keys are stored as their fixed width scalar
+when the file is encrypted that scalar is sealed with a key derived from the passphrase while the public keys stay readable so addresses can be listed without it
+*/
+type walletFileContent struct {
+	Version   int
+	Encrypted bool
+	Salt      []byte
+	Keys      []walletFileKey
+	Seed      []byte //sealed like the private keys when the file is encrypted
+	Labels    map[string]string
+}
+
+type walletFileKey struct {
+	PublicKey  []byte
+	PrivateKey []byte
+	Scheme     SchemeID //0 for files written before there were signature schemes, which only had ECDSA keys
+	Path       *KeyPath
+	WatchOnly  bool
+	Internal   bool
+	PubKeyHash []byte //only set for watch-only addresses added without their public key
 }
 
 func CreateWallets() (*Wallets, error) {
@@ -23,11 +69,76 @@ func CreateWallets() (*Wallets, error) {
 	return &wallets, err
 }
 
-func (ws *Wallets) AddWallet() string {
-	wallet := MakeWallet()
+/*
+wallets with a seed derive the next receiving key of the first account, others get a random key
+new keys of an encrypted wallet have to be sealed, so the wallet has to be unlocked to add one
+*/
+func (ws *Wallets) AddWallet() (string, error) {
+	if ws.HasSeed() {
+		return ws.NewHDAddress(0, ReceiveChain)
+	}
+	return ws.addWallet(MakeWallet())
+}
+
+//a random key of the scheme, keys derived from the seed are always ECDSA
+func (ws *Wallets) AddKey(scheme SchemeID) (string, error) {
+	wallet, err := NewWallet(scheme)
+	if err != nil {
+		return "", err
+	}
+	return ws.addWallet(wallet)
+}
+
+func (ws *Wallets) addWallet(wallet *Wallet) (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.encrypted && !wallet.WatchOnly {
+		if ws.key == nil {
+			return "", ErrWalletLocked
+		}
+		sealed, err := sealKey(ws.key, wallet)
+		if err != nil {
+			return "", err
+		}
+		wallet.encryptedKey = sealed
+	}
 	address := fmt.Sprintf("%s", wallet.Address())
 	ws.Wallets[address] = wallet
-	return address
+	return address, nil
+}
+
+/*
+a fresh address for the change of a transaction from the from address, so the change can not be linked to it
+wallets with a seed take it from the change chain of the account from belongs to, others get a new random key
+*/
+func (ws *Wallets) NewChangeAddress(from string) (string, error) {
+	if ws.HasSeed() {
+		account := uint32(0)
+		ws.mu.Lock()
+		if w, ok := ws.Wallets[from]; ok && w.Path != nil {
+			account = w.Path.Account
+		}
+		ws.mu.Unlock()
+		return ws.NewHDAddress(account, ChangeChain)
+	}
+	wallet := MakeWallet()
+	wallet.Internal = true
+	return ws.addWallet(wallet)
+}
+
+//watch-only entries need no private key, so they can be added to a locked wallet
+func (ws *Wallets) AddWatchOnly(wallet *Wallet) (string, error) {
+	if !wallet.WatchOnly {
+		return "", errors.New("Wallet has a private key, import it with ImportKey")
+	}
+	address := string(wallet.Address())
+	ws.mu.Lock()
+	_, exists := ws.Wallets[address]
+	ws.mu.Unlock()
+	if exists {
+		return address, errors.New("The address is already in the wallet")
+	}
+	return ws.addWallet(wallet)
 }
 
 func (ws *Wallets) GetAllAddresses() []string {
@@ -38,39 +149,324 @@ func (ws *Wallets) GetAllAddresses() []string {
 	return addresses
 }
 
-func (ws Wallets) GetWallet(address string) Wallet {
+//the wallet of address, false when we have no key or watch-only entry for it
+func (ws *Wallets) Lookup(address string) (Wallet, bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	w, ok := ws.Wallets[address]
+	if !ok {
+		return Wallet{}, false
+	}
+	return *w, true
+}
+
+func (ws *Wallets) GetWallet(address string) Wallet {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
 	return *ws.Wallets[address]
 }
 
+//attaches a note to an address or a transaction id, an empty label removes it
+func (ws *Wallets) SetLabel(key, label string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if label == "" {
+		delete(ws.labels, key)
+		return
+	}
+	if ws.labels == nil {
+		ws.labels = make(map[string]string)
+	}
+	ws.labels[key] = label
+}
+
+func (ws *Wallets) Label(key string) string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.labels[key]
+}
+
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.encrypted
+}
+
+func (ws *Wallets) IsLocked() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.encrypted && ws.key == nil
+}
+
+//decrypts the private keys, they are wiped from memory again after timeout, or only by Lock when timeout is 0
+func (ws *Wallets) Unlock(passphrase string, timeout time.Duration) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if !ws.encrypted {
+		return ErrWalletUnencrypted
+	}
+	key, err := deriveKey(passphrase, ws.salt)
+	if err != nil {
+		return err
+	}
+	for _, wallet := range ws.Wallets {
+		if wallet.WatchOnly {
+			continue
+		}
+		if err := openKey(key, wallet); err != nil {
+			ws.lock()
+			return err
+		}
+	}
+	if ws.encryptedSeed != nil {
+		seed, err := open(key, ws.encryptedSeed, []byte("seed"))
+		if err != nil {
+			ws.lock()
+			return err
+		}
+		ws.seed = seed
+	}
+	ws.key = key
+	if ws.lockTimer != nil {
+		ws.lockTimer.Stop()
+	}
+	ws.lockTimer = nil
+	if timeout > 0 {
+		ws.lockTimer = time.AfterFunc(timeout, ws.Lock)
+	}
+	return nil
+}
+
+func (ws *Wallets) Lock() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.lock()
+}
+
+func (ws *Wallets) lock() {
+	if !ws.encrypted {
+		return
+	}
+	if ws.lockTimer != nil {
+		ws.lockTimer.Stop()
+		ws.lockTimer = nil
+	}
+	for i := range ws.key {
+		ws.key[i] = 0
+	}
+	ws.key = nil
+	for _, wallet := range ws.Wallets {
+		wallet.wipe()
+	}
+	for i := range ws.seed {
+		ws.seed[i] = 0
+	}
+	ws.seed = nil
+}
+
+//seals every private key with a key derived from passphrase and locks the wallet, SaveFile has to be called afterwards
+func (ws *Wallets) Encrypt(passphrase string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.encrypted {
+		return ErrWalletEncrypted
+	}
+	salt, err := newSalt()
+	if err != nil {
+		return err
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	for _, wallet := range ws.Wallets {
+		if wallet.WatchOnly {
+			continue
+		}
+		sealed, err := sealKey(key, wallet)
+		if err != nil {
+			return err
+		}
+		wallet.encryptedKey = sealed
+	}
+	if err := ws.sealSeed(key); err != nil {
+		return err
+	}
+	ws.encrypted = true
+	ws.salt = salt
+	ws.lock()
+	return nil
+}
+
+//re-seals every private key under a new passphrase, SaveFile has to be called afterwards
+func (ws *Wallets) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if err := ws.Unlock(oldPassphrase, time.Minute); err != nil {
+		return err
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	salt, err := newSalt()
+	if err != nil {
+		return err
+	}
+	key, err := deriveKey(newPassphrase, salt)
+	if err != nil {
+		return err
+	}
+	for _, wallet := range ws.Wallets {
+		if wallet.WatchOnly {
+			continue
+		}
+		sealed, err := sealKey(key, wallet)
+		if err != nil {
+			return err
+		}
+		wallet.encryptedKey = sealed
+	}
+	if err := ws.sealSeed(key); err != nil {
+		return err
+	}
+	ws.salt = salt
+	ws.lock()
+	return nil
+}
+
+func (ws *Wallets) sealSeed(key []byte) error {
+	if ws.seed == nil {
+		return nil
+	}
+	sealed, err := seal(key, ws.seed, []byte("seed"))
+	if err != nil {
+		return err
+	}
+	ws.encryptedSeed = sealed
+	return nil
+}
+
 func (ws *Wallets) LoadFile() error {
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
-	var wallets Wallets
 	fileContent, err := ioutil.ReadFile(walletFile)
 	if err != nil {
 		return err
 	}
-	gob.Register(elliptic.P256())
+
+	var content walletFileContent
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
-	if err != nil {
+	if err := decoder.Decode(&content); err != nil || content.Version == 0 {
+		return ws.loadLegacyFile(fileContent)
+	}
+
+	wallets := make(map[string]*Wallet)
+	for _, key := range content.Keys {
+		wallet := &Wallet{PublicKey: key.PublicKey, Scheme: key.Scheme, Path: key.Path, WatchOnly: key.WatchOnly, Internal: key.Internal, watchHash: key.PubKeyHash}
+		if content.Encrypted {
+			wallet.encryptedKey = key.PrivateKey
+		} else if !key.WatchOnly {
+			if err := wallet.setPrivateKey(key.PrivateKey); err != nil {
+				return err
+			}
+		}
+		wallets[string(wallet.Address())] = wallet
+	}
+	ws.Wallets = wallets
+	ws.encrypted = content.Encrypted
+	ws.salt = content.Salt
+	ws.labels = content.Labels
+	//labels of addresses are kept under the address in the form Address gives it
+	for key, label := range ws.labels {
+		if normalized, err := NormalizeAddress(key); err == nil && normalized != key {
+			delete(ws.labels, key)
+			ws.labels[normalized] = label
+		}
+	}
+	if content.Encrypted {
+		ws.encryptedSeed = content.Seed
+	} else {
+		ws.seed = content.Seed
+	}
+	return nil
+}
+
+/*
+files written before the wallet file had a version were the gob encoded Wallets struct with the ecdsa keys in it
+the curve of those keys is gob encoded under a type name that depends on the Go version that wrote it, so it is skipped and only the scalar is read
+*/
+func (ws *Wallets) loadLegacyFile(fileContent []byte) error {
+	var wallets struct {
+		Wallets map[string]*struct {
+			PrivateKey struct {
+				D *big.Int
+			}
+			PublicKey []byte
+		}
+	}
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	if err := decoder.Decode(&wallets); err != nil {
 		return err
 	}
-	ws.Wallets = wallets.Wallets
+	//the map was keyed by the base58 address, the public key is kept as it was written since the address commits to it
+	for _, legacy := range wallets.Wallets {
+		if legacy.PrivateKey.D == nil {
+			return ErrInvalidPrivateKey
+		}
+		wallet := &Wallet{PublicKey: legacy.PublicKey}
+		if err := wallet.setPrivateKey(padScalar(legacy.PrivateKey.D)); err != nil {
+			return err
+		}
+		ws.Wallets[string(wallet.Address())] = wallet
+	}
 	return nil
 }
 
-func (ws *Wallets) SaveFile() {
-	var content bytes.Buffer
-	gob.Register(elliptic.P256())
-	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
-	if err != nil {
-		log.Panic(err)
+//the file is only readable by its owner and is replaced atomically so a crash can never leave half a wallet behind
+func (ws *Wallets) SaveFile() error {
+	ws.mu.Lock()
+	content := walletFileContent{walletFileVersion, ws.encrypted, ws.salt, nil, ws.seed, ws.labels}
+	if ws.encrypted {
+		content.Seed = ws.encryptedSeed
 	}
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	for _, wallet := range ws.Wallets {
+		key := walletFileKey{PublicKey: wallet.PublicKey, Scheme: wallet.Scheme, Path: wallet.Path, WatchOnly: wallet.WatchOnly, Internal: wallet.Internal, PubKeyHash: wallet.watchHash}
+		if ws.encrypted {
+			key.PrivateKey = wallet.encryptedKey
+		} else if !wallet.WatchOnly {
+			key.PrivateKey = wallet.privateKeyBytes()
+		}
+		content.Keys = append(content.Keys, key)
+	}
+	ws.mu.Unlock()
+
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if err := encoder.Encode(content); err != nil {
+		return err
+	}
+	return writeFileAtomic(walletFile, buffer.Bytes(), 0600)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
 	}
+	return os.Rename(tmp, path)
 }